@@ -1,6 +1,6 @@
 module simpleca
 
-go 1.18
+go 1.21
 
 require (
 	github.com/google/uuid v1.5.0
@@ -33,6 +33,7 @@ import (
 // https://tools.ietf.org/html/draft-ietf-acme-acme-16#section-7.1.6
 const (
 	StatusPending     = "pending"
+	StatusReady       = "ready"
 	StatusInvalid     = "invalid"
 	StatusValid       = "valid"
 	StatusProcessing  = "processing"
@@ -44,13 +45,14 @@ const (
 // Directory the ACME directory object.
 // - https://tools.ietf.org/html/draft-ietf-acme-acme-16#section-7.1.1
 type Directory struct {
-	NewNonceURL   string `json:"newNonce"`
-	NewAccountURL string `json:"newAccount"`
-	NewOrderURL   string `json:"newOrder"`
-	NewAuthzURL   string `json:"newAuthz",omitempty`
-	RevokeCertURL string `json:"revokeCert"`
-	KeyChangeURL  string `json:"keyChange"`
-	Meta          Meta   `json:"meta",omitempty`
+	NewNonceURL    string `json:"newNonce"`
+	NewAccountURL  string `json:"newAccount"`
+	NewOrderURL    string `json:"newOrder"`
+	NewAuthzURL    string `json:"newAuthz,omitempty"`
+	RevokeCertURL  string `json:"revokeCert"`
+	KeyChangeURL   string `json:"keyChange"`
+	RenewalInfoURL string `json:"renewalInfo,omitempty"`
+	Meta           Meta   `json:"meta,omitempty"`
 }
 
 // Meta the ACME meta object (related to Directory).
@@ -160,6 +162,11 @@ type Order struct {
 	// in the date format defined in [RFC3339].
 	NotAfter string `json:"notAfter,omitempty"`
 
+	// replaces (optional, string):
+	// The ARI certificate identifier of a certificate this order replaces.
+	// - https://datatracker.ietf.org/doc/draft-ietf-acme-ari/
+	Replaces string `json:"replaces,omitempty"`
+
 	// authorizations (required, array of string):
 	// For pending orders,
 	// the authorizations that the client needs to complete before the requested certificate can be issued (see Section 7.5),
@@ -178,6 +185,10 @@ type Order struct {
 	// certificate (optional, string):
 	// A URL for the certificate that has been issued in response to this order
 	Certificate string `json:"certificate,omitempty"`
+
+	// error (optional, object):
+	// The error that occurred while processing the order, if any.
+	Error *ProblemDetails `json:"error,omitempty"`
 }
 
 // Authorization the ACME authorization object.
@@ -242,7 +253,11 @@ type Challenge struct {
 	// The time at which the server validated this challenge,
 	// encoded in the format specified in RFC 3339 [RFC3339].
 	// This field is REQUIRED if the "status" field is "valid".
-	Validated time.Time `json:"validated,omitempty"`
+	Validated *time.Time `json:"validated,omitempty"`
+
+	// error (optional, object):
+	// Error that occurred while the server was validating the challenge, if any.
+	Error *ProblemDetails `json:"error,omitempty"`
 
 	// token (required, string):
 	// A random value that uniquely identifies the challenge.
@@ -255,7 +270,7 @@ type Challenge struct {
 	Token string `json:"token"`
 
 	// https://tools.ietf.org/html/draft-ietf-acme-acme-16#section-8.1
-	KeyAuthorization string `json:"keyAuthorization"`
+	KeyAuthorization string `json:"keyAuthorization,omitempty"`
 }
 
 // Identifier the ACME identifier object.
@@ -292,3 +307,21 @@ type RevokeCertMessage struct {
 	// The problem document detail SHOULD indicate which reasonCodes are allowed.
 	Reason *uint `json:"reason,omitempty"`
 }
+
+// RenewalInfo the ACME renewal information object.
+// - https://datatracker.ietf.org/doc/draft-ietf-acme-ari/
+type RenewalInfo struct {
+	// suggestedWindow (required, object):
+	// The window within which the certificate should be renewed, start and end in RFC 3339 format.
+	SuggestedWindow RenewalWindow `json:"suggestedWindow"`
+
+	// explanationURL (optional, string):
+	// A URL pointing to a page which may explain why the suggested renewal window is what it is.
+	ExplanationURL string `json:"explanationURL,omitempty"`
+}
+
+// RenewalWindow the suggested renewal window of a certificate.
+type RenewalWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
@@ -0,0 +1,84 @@
+// Copied from lego <https://github.com/go-acme/lego> with modifications.
+//
+// Copyright (c) 2015-2017 Sebastian Erhart
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package acme
+
+import "fmt"
+
+// Errors types.
+// - https://tools.ietf.org/html/rfc8555#section-6.7
+const (
+	errNS = "urn:ietf:params:acme:error:"
+
+	AccountDoesNotExistErr     = errNS + "accountDoesNotExist"
+	AlreadyRevokedErr          = errNS + "alreadyRevoked"
+	AlreadyReplacedErr         = errNS + "alreadyReplaced"
+	BadCSRErr                  = errNS + "badCSR"
+	BadNonceErr                = errNS + "badNonce"
+	BadPublicKeyErr            = errNS + "badPublicKey"
+	BadRevocationReasonErr     = errNS + "badRevocationReason"
+	BadSignatureAlgorithmErr   = errNS + "badSignatureAlgorithm"
+	CaaErr                     = errNS + "caa"
+	CompoundErr                = errNS + "compound"
+	ConnectionErr              = errNS + "connection"
+	DNSErr                     = errNS + "dns"
+	ExternalAccountRequiredErr = errNS + "externalAccountRequired"
+	IncorrectResponseErr       = errNS + "incorrectResponse"
+	InvalidContactErr          = errNS + "invalidContact"
+	MalformedErr               = errNS + "malformed"
+	OrderNotReadyErr           = errNS + "orderNotReady"
+	RateLimitedErr             = errNS + "rateLimited"
+	RejectedIdentifierErr      = errNS + "rejectedIdentifier"
+	ServerInternalErr          = errNS + "serverInternal"
+	TLSErr                     = errNS + "tls"
+	UnauthorizedErr            = errNS + "unauthorized"
+	UnsupportedContactErr      = errNS + "unsupportedContact"
+	UnsupportedIdentifierErr   = errNS + "unsupportedIdentifier"
+	UserActionRequiredErr      = errNS + "userActionRequired"
+)
+
+// ProblemDetails the problem details object.
+// - https://tools.ietf.org/html/rfc7807#section-3.1
+// - https://tools.ietf.org/html/rfc8555#section-6.7
+type ProblemDetails struct {
+	Type        string       `json:"type,omitempty"`
+	Detail      string       `json:"detail,omitempty"`
+	HTTPStatus  int          `json:"status,omitempty"`
+	Instance    string       `json:"instance,omitempty"`
+	SubProblems []SubProblem `json:"subproblems,omitempty"`
+}
+
+// SubProblem a "subproblems".
+// - https://tools.ietf.org/html/rfc8555#section-6.7.1
+type SubProblem struct {
+	Type       string      `json:"type,omitempty"`
+	Detail     string      `json:"detail,omitempty"`
+	Identifier *Identifier `json:"identifier,omitempty"`
+}
+
+func (p ProblemDetails) Error() string {
+	msg := fmt.Sprintf("acme: error: %d :: %s :: %s", p.HTTPStatus, p.Type, p.Detail)
+	for _, sub := range p.SubProblems {
+		msg += fmt.Sprintf(", problem: %q :: %s", sub.Type, sub.Detail)
+	}
+	return msg
+}
@@ -0,0 +1,386 @@
+package acmeca
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"path"
+	"simpleca/internal/acme"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+////
+// Types
+////
+
+// ACME account persisted in the data directory
+type account struct {
+	ID                   string          `json:"id"`
+	Status               string          `json:"status"`
+	Contact              []string        `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool            `json:"termsOfServiceAgreed,omitempty"`
+	Key                  json.RawMessage `json:"key"`
+	Thumbprint           string          `json:"thumbprint"`
+	ExternalAccountID    string          `json:"externalAccountId,omitempty"`
+	CreatedAt            time.Time       `json:"createdAt"`
+}
+
+////
+// Variables & Constants
+////
+
+var (
+	accounts    = map[string]*account{}
+	accountsMtx sync.Mutex
+)
+
+////
+// Utility functions
+////
+
+// Persist an account
+func saveAccount(a *account) error {
+	accountsMtx.Lock()
+	defer accountsMtx.Unlock()
+	if err := store.SaveAccount(a); err != nil {
+		return err
+	}
+	accounts[a.ID] = a
+	return nil
+}
+
+// Persist a new account, unless its key is already used by another account which is
+// returned (the check and the creation are done under the same lock)
+func createAccount(a *account) (*account, error) {
+	accountsMtx.Lock()
+	defer accountsMtx.Unlock()
+	if other := accountByKey(a.Thumbprint); other != nil {
+		return other, nil
+	}
+	if _, found := accounts[a.ID]; found {
+		return nil, errors.New("Account " + a.ID + " already exists")
+	}
+	if err := store.SaveAccount(a); err != nil {
+		return nil, err
+	}
+	accounts[a.ID] = a
+	return nil, nil
+}
+
+func getAccount(id string) *account {
+	accountsMtx.Lock()
+	defer accountsMtx.Unlock()
+	return accounts[id]
+}
+
+// Account of a JSON web key (by thumbprint)
+func findAccountByKey(thumbprint string) *account {
+	accountsMtx.Lock()
+	defer accountsMtx.Unlock()
+	return accountByKey(thumbprint)
+}
+
+// Replace the key of an account, unless the new key is already used by another account
+// which is returned (the check and the update are done under the same lock)
+func changeAccountKey(a *account, key json.RawMessage, thumbprint string) (*account, *account, error) {
+	accountsMtx.Lock()
+	defer accountsMtx.Unlock()
+	if other := accountByKey(thumbprint); other != nil {
+		return nil, other, nil
+	}
+	updated := *a
+	updated.Key = key
+	updated.Thumbprint = thumbprint
+	if err := store.SaveAccount(&updated); err != nil {
+		return nil, nil, err
+	}
+	accounts[updated.ID] = &updated
+	return &updated, nil, nil
+}
+
+// Account of a JSON web key (accountsMtx locked)
+func accountByKey(thumbprint string) *account {
+	for _, a := range accounts {
+		if a.Thumbprint == thumbprint {
+			return a
+		}
+	}
+	return nil
+}
+
+// Public key of the account
+func (a *account) PublicKey() (crypto.PublicKey, error) {
+	k, err := parseJWK(a.Key)
+	if err != nil {
+		return nil, err
+	}
+	return k.PublicKey()
+}
+
+// ACME account object of the account
+func (a *account) object(r *http.Request) acme.Account {
+	return acme.Account{
+		Status:               a.Status,
+		Contact:              a.Contact,
+		TermsOfServiceAgreed: a.TermsOfServiceAgreed,
+		Orders:               createURL(r, path.Join(accountPath, a.ID, "orders")),
+	}
+}
+
+// Check contact URLs (only mailto is supported)
+func checkContacts(contacts []string) error {
+	for _, c := range contacts {
+		if !strings.HasPrefix(c, "mailto:") {
+			return acme.ProblemDetails{Type: acme.UnsupportedContactErr, Detail: "Unsupported contact " + c, HTTPStatus: http.StatusBadRequest}
+		}
+		for _, addr := range strings.Split(strings.TrimPrefix(c, "mailto:"), ",") {
+			if _, err := mail.ParseAddress(addr); err != nil || strings.ContainsAny(addr, "<>?") {
+				return acme.ProblemDetails{Type: acme.InvalidContactErr, Detail: "Invalid contact " + c, HTTPStatus: http.StatusBadRequest}
+			}
+		}
+	}
+	return nil
+}
+
+////
+// Handlers
+////
+
+func accountHandler(w http.ResponseWriter, r *http.Request) interface{} {
+	var req acme.Account
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Invalid account request", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+
+	jwk := requestJWK(r)
+	thumbprint := jwk.Thumbprint()
+	if a := findAccountByKey(thumbprint); a != nil {
+		return existingAccount(w, r, a)
+	}
+	if req.OnlyReturnExisting {
+		writeProblem(w, acme.ProblemDetails{Type: acme.AccountDoesNotExistErr, Detail: "Account does not exist", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if err := checkContacts(req.Contact); err != nil {
+		writeProblem(w, err.(acme.ProblemDetails))
+		return nil
+	}
+
+	var binding *eabKey
+	if len(req.ExternalAccountBinding) > 0 {
+		var err error
+		if binding, err = verifyEAB(r, req.ExternalAccountBinding, jwk); err != nil {
+			if problem, ok := err.(acme.ProblemDetails); ok {
+				writeProblem(w, problem)
+			} else {
+				writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+			}
+			return nil
+		}
+	} else if eabRequired {
+		writeProblem(w, acme.ProblemDetails{Type: acme.ExternalAccountRequiredErr, Detail: "New accounts must have an external account binding", HTTPStatus: http.StatusUnauthorized})
+		return nil
+	}
+
+	key, _ := json.Marshal(jwk)
+	a := &account{
+		ID:                   randomID(),
+		Status:               acme.StatusValid,
+		Contact:              req.Contact,
+		TermsOfServiceAgreed: req.TermsOfServiceAgreed,
+		Key:                  key,
+		Thumbprint:           thumbprint,
+		CreatedAt:            time.Now(),
+	}
+	if binding != nil {
+		if err := bindEABKey(binding, a.ID); err != nil {
+			if problem, ok := err.(acme.ProblemDetails); ok {
+				writeProblem(w, problem)
+			} else {
+				writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+			}
+			return nil
+		}
+		a.ExternalAccountID = binding.ID
+	}
+	other, err := createAccount(a)
+	if (err != nil || other != nil) && binding != nil {
+		unbindEABKey(binding)
+	}
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+		return nil
+	} else if other != nil {
+		return existingAccount(w, r, other)
+	}
+
+	w.Header().Add("Location", createURL(r, path.Join(accountPath, a.ID)))
+	w.WriteHeader(http.StatusCreated)
+	return a.object(r)
+}
+
+// Response to a new account request with the key of an existing account
+func existingAccount(w http.ResponseWriter, r *http.Request, a *account) interface{} {
+	if a.Status != acme.StatusValid {
+		writeProblem(w, acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "Account is " + a.Status, HTTPStatus: http.StatusUnauthorized})
+		return nil
+	}
+	w.Header().Add("Location", createURL(r, path.Join(accountPath, a.ID)))
+	return a.object(r)
+}
+
+// Account key rollover: the payload is a JWS signed by the new key (RFC 8555 section 7.3.5)
+func keyChangeHandler(w http.ResponseWriter, r *http.Request) interface{} {
+	a := requestAccount(r)
+	outer := requestHeader(r)
+	body, err := readPayload(r)
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Can not read request", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	jws, header, payload, sig, err := decodeJWS(body)
+	if err != nil {
+		writeProblem(w, err.(acme.ProblemDetails))
+		return nil
+	}
+	if len(header.Jwk) == 0 || len(header.Kid) > 0 || len(header.Nonce) > 0 {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Inner JWS must be signed with a JWK and without nonce", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if header.URL != outer.URL {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Inner JWS url does not match the outer JWS url", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	jwk, publicKey, err := headerJWK(header)
+	if err != nil {
+		writeProblem(w, err.(acme.ProblemDetails))
+		return nil
+	}
+	if err = verifySignature(header.Alg, publicKey, []byte(jws.Protected+"."+jws.Payload), sig); err != nil {
+		if problem, ok := err.(acme.ProblemDetails); ok {
+			writeProblem(w, problem)
+		} else {
+			writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Inner " + err.Error(), HTTPStatus: http.StatusBadRequest})
+		}
+		return nil
+	}
+
+	var req struct {
+		Account string          `json:"account"`
+		OldKey  json.RawMessage `json:"oldKey"`
+	}
+	if err = json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Invalid key change request", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if req.Account != outer.Kid {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Key change account does not match the outer JWS key identifier", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if oldKey, err := parseJWK(req.OldKey); err != nil || oldKey.Thumbprint() != a.Thumbprint {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Key change oldKey is not the account key", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	key, _ := json.Marshal(jwk)
+	updated, other, err := changeAccountKey(a, key, jwk.Thumbprint())
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+		return nil
+	}
+	if other != nil {
+		w.Header().Add("Location", createURL(r, path.Join(accountPath, other.ID)))
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "New key is already used by an account", HTTPStatus: http.StatusConflict})
+		return nil
+	}
+	return updated.object(r)
+}
+
+// Orders of an account which are not invalid (RFC 8555 section 7.1.2.1)
+func accountOrdersHandler(w http.ResponseWriter, r *http.Request) interface{} {
+	a := requestAccount(r)
+	if path.Base(path.Dir(r.URL.Path)) != a.ID {
+		writeProblem(w, acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "Account does not match the request key", HTTPStatus: http.StatusUnauthorized})
+		return nil
+	}
+
+	ordersMtx.Lock()
+	list := []*orderCtx{}
+	for _, o := range orders {
+		if o.account != a.ID {
+			continue
+		}
+		refreshOrder(o)
+		if o.obj.Status != acme.StatusInvalid {
+			list = append(list, o)
+		}
+	}
+	ordersMtx.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].created.Before(list[j].created) })
+	urls := []string{}
+	for _, o := range list {
+		urls = append(urls, createURL(r, path.Join(orderPath, o.id)))
+	}
+	return struct {
+		Orders []string `json:"orders"`
+	}{urls}
+}
+
+// Account update (contact, deactivation) or POST-as-GET
+func updateAccountHandler(w http.ResponseWriter, r *http.Request) interface{} {
+	if path.Base(r.URL.Path) == "orders" {
+		return accountOrdersHandler(w, r)
+	}
+	a := requestAccount(r)
+	if path.Base(r.URL.Path) != a.ID {
+		writeProblem(w, acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "Account does not match the request key", HTTPStatus: http.StatusUnauthorized})
+		return nil
+	}
+
+	var req struct {
+		Status  string    `json:"status"`
+		Contact *[]string `json:"contact"`
+	}
+	body, err := readPayload(r)
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Can not read request", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if len(body) == 0 {
+		return a.object(r)
+	}
+	if err = json.Unmarshal(body, &req); err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Invalid account update", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+
+	updated := *a
+	if len(req.Status) > 0 {
+		if req.Status != acme.StatusDeactivated {
+			writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Account status can only be set to deactivated", HTTPStatus: http.StatusBadRequest})
+			return nil
+		}
+		updated.Status = acme.StatusDeactivated
+	}
+	if req.Contact != nil {
+		if err := checkContacts(*req.Contact); err != nil {
+			writeProblem(w, err.(acme.ProblemDetails))
+			return nil
+		}
+		updated.Contact = *req.Contact
+	}
+	if err := saveAccount(&updated); err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+		return nil
+	}
+	if updated.Status == acme.StatusDeactivated {
+		deactivateAuthzs(updated.ID)
+	}
+	return updated.object(r)
+}
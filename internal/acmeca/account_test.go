@@ -0,0 +1,69 @@
+package acmeca
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Ed25519 JSON web key
+func testJWK(t *testing.T) *jsonWebKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}
+}
+
+// New account request signed by a key, returns the status code and the account identifier
+func newAccount(t *testing.T, jwk *jsonWebKey) (int, string) {
+	r := httptest.NewRequest("POST", newAccountPath, strings.NewReader(`{"termsOfServiceAgreed":true}`))
+	r = r.WithContext(context.WithValue(r.Context(), jwkCtxKey, jwk))
+	w := httptest.NewRecorder()
+	if obj := accountHandler(w, r); obj == nil {
+		t.Fatalf("new account: %s", w.Body.String())
+	}
+	return w.Code, path.Base(w.Header().Get("Location"))
+}
+
+func TestNewAccountAfterKeyChange(t *testing.T) {
+	defer func(s Storage, a map[string]*account) { store, accounts = s, a }(store, accounts)
+	var err error
+	if store, err = NewFileStorage(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	accounts = map[string]*account{}
+
+	oldKey, newKey := testJWK(t), testJWK(t)
+	code, id := newAccount(t, oldKey)
+	if code != http.StatusCreated {
+		t.Fatalf("new account: got status %d, expected %d", code, http.StatusCreated)
+	}
+	key, _ := json.Marshal(newKey)
+	if _, other, err := changeAccountKey(getAccount(id), key, newKey.Thumbprint()); err != nil || other != nil {
+		t.Fatalf("key change: got %v, %v", other, err)
+	}
+
+	code, otherID := newAccount(t, oldKey)
+	if code != http.StatusCreated {
+		t.Errorf("new account with the old key: got status %d, expected %d", code, http.StatusCreated)
+	}
+	if otherID == id {
+		t.Errorf("new account with the old key: got account %s, expected a new account", otherID)
+	}
+	if a := getAccount(id); a == nil || a.Thumbprint != newKey.Thumbprint() {
+		t.Errorf("rolled over account: got %+v, expected the new key", a)
+	}
+
+	code, sameID := newAccount(t, newKey)
+	if code != http.StatusOK || sameID != id {
+		t.Errorf("new account with the new key: got status %d and account %s, expected %d and %s", code, sameID, http.StatusOK, id)
+	}
+}
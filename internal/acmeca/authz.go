@@ -0,0 +1,292 @@
+package acmeca
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"simpleca/internal/acme"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////
+// Types
+////
+
+type authzCtx struct {
+	id      string
+	obj     *acme.Authorization
+	account string
+}
+
+////
+// Variables & Constants
+////
+
+const (
+	authzPath = "/authz/"
+	challPath = "/chall/"
+
+	orderLifetime = 7 * 24 * time.Hour
+	authzLifetime = 7 * 24 * time.Hour
+	authzValidity = 30 * 24 * time.Hour
+)
+
+// Authorizations by ID, protected by ordersMtx
+var authzs = map[string]*authzCtx{}
+
+////
+// Utility functions
+////
+
+// Random identifier of 128 bits (base64url encoded)
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Challenge types which can be used to validate an identifier
+// Wildcard names can only be validated by dns-01, IP addresses by http-01 and tls-alpn-01.
+func challengeTypes(identifier acme.Identifier) []string {
+	if identifier.Type == "ip" {
+		return []string{"http-01", "tls-alpn-01"}
+	}
+	types := []string{"http-01", "dns-01", "tls-alpn-01"}
+	if strings.HasPrefix(identifier.Value, "*.") {
+		types = []string{"dns-01"}
+	}
+	allowed := Policy.AllowedChallenges(strings.TrimPrefix(identifier.Value, "*."))
+	if allowed == nil {
+		return types
+	}
+	filtered := []string{}
+	for _, t := range types {
+		for _, a := range allowed {
+			if t == a {
+				filtered = append(filtered, t)
+			}
+		}
+	}
+	return filtered
+}
+
+// Create a pending authorization with its challenges
+func createAuthz(r *http.Request, account string, identifier acme.Identifier) *authzCtx {
+	id := randomID()
+	a := &acme.Authorization{
+		Status:     acme.StatusPending,
+		Expires:    time.Now().Add(authzLifetime).UTC(),
+		Identifier: identifier,
+		Challenges: []acme.Challenge{},
+	}
+	if strings.HasPrefix(identifier.Value, "*.") {
+		a.Identifier.Value = strings.TrimPrefix(identifier.Value, "*.")
+		a.Wildcard = true
+	}
+	for i, t := range challengeTypes(identifier) {
+		a.Challenges = append(a.Challenges, acme.Challenge{
+			Type:   t,
+			URL:    createURL(r, path.Join(challPath, id, strconv.Itoa(i))),
+			Status: acme.StatusPending,
+			Token:  randomID(),
+		})
+	}
+	return &authzCtx{id, a, account}
+}
+
+// Expire authorizations (ordersMtx locked)
+func refreshAuthz(a *authzCtx) {
+	if (a.obj.Status == acme.StatusPending || a.obj.Status == acme.StatusValid) && time.Now().After(a.obj.Expires) {
+		a.obj.Status = acme.StatusExpired
+	}
+}
+
+// Deactivate the pending and valid authorizations of a deactivated account (RFC 8555 section 7.3.6)
+func deactivateAuthzs(account string) {
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	for _, a := range authzs {
+		if a.account != account {
+			continue
+		}
+		refreshAuthz(a)
+		if a.obj.Status == acme.StatusPending || a.obj.Status == acme.StatusValid {
+			a.obj.Status = acme.StatusDeactivated
+			saveAuthz(a)
+		}
+	}
+}
+
+// Update the status of an order from its authorizations (ordersMtx locked)
+func refreshOrder(o *orderCtx) {
+	if o.obj.Status != acme.StatusPending && o.obj.Status != acme.StatusReady {
+		return
+	}
+	if expires, err := time.Parse(time.RFC3339, o.obj.Expires); err == nil && time.Now().After(expires) {
+		o.obj.Status = acme.StatusInvalid
+		return
+	}
+	ready := true
+	for _, id := range o.authzs {
+		a := authzs[id]
+		if a == nil {
+			// Authorization lost (deleted from the data directory): the order can not be fulfilled
+			o.obj.Status = acme.StatusInvalid
+			return
+		}
+		refreshAuthz(a)
+		switch a.obj.Status {
+		case acme.StatusValid:
+		case acme.StatusPending:
+			ready = false
+		default:
+			o.obj.Status = acme.StatusInvalid
+			return
+		}
+	}
+	if ready {
+		o.obj.Status = acme.StatusReady
+	}
+}
+
+// Current status of an order
+func orderStatus(o *orderCtx) string {
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	refreshOrder(o)
+	return o.obj.Status
+}
+
+// Copy of an authorization object, safe to encode once ordersMtx is unlocked
+func (a *authzCtx) copy() acme.Authorization {
+	obj := *a.obj
+	obj.Challenges = append([]acme.Challenge{}, a.obj.Challenges...)
+	return obj
+}
+
+// Validate a challenge and update its authorization
+func validateChallenge(a *authzCtx, index int) {
+	ordersMtx.Lock()
+	chall := a.obj.Challenges[index]
+	identifier := a.obj.Identifier
+	ordersMtx.Unlock()
+
+	var err error
+	if acct := getAccount(a.account); acct == nil {
+		err = acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "Account does not exist"}
+	} else if validate, found := validators[chall.Type]; !found {
+		err = acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Unsupported challenge type " + chall.Type}
+	} else {
+		err = validate(identifier, chall.Token, chall.Token+"."+acct.Thumbprint)
+	}
+
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	c := &a.obj.Challenges[index]
+	now := time.Now().UTC()
+	if a.obj.Status != acme.StatusPending {
+		c.Status = acme.StatusInvalid
+	} else if err == nil {
+		fmt.Println("Challenge", chall.Type, "valid for", identifier.Value)
+		c.Status = acme.StatusValid
+		c.Validated = &now
+		a.obj.Status = acme.StatusValid
+		a.obj.Expires = now.Add(authzValidity)
+	} else {
+		fmt.Println("Challenge", chall.Type, "invalid for", identifier.Value+":", err)
+		problem, ok := err.(acme.ProblemDetails)
+		if !ok {
+			problem = acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error()}
+		}
+		c.Status = acme.StatusInvalid
+		c.Error = &problem
+		a.obj.Status = acme.StatusInvalid
+	}
+	saveAuthz(a)
+}
+
+////
+// Handlers
+////
+
+// Authorization POST-as-GET or deactivation
+func authzHandler(w http.ResponseWriter, r *http.Request) interface{} {
+	id := path.Base(r.URL.Path)
+	body, err := readPayload(r)
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Can not read request", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	a := authzs[id]
+	if a == nil || a.account != requestAccount(r).ID {
+		fmt.Println("Not found")
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	refreshAuthz(a)
+	if len(body) > 0 {
+		var req struct {
+			Status string `json:"status"`
+		}
+		if err = json.Unmarshal(body, &req); err != nil || req.Status != acme.StatusDeactivated {
+			writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Authorization status can only be set to deactivated", HTTPStatus: http.StatusBadRequest})
+			return nil
+		}
+		if a.obj.Status != acme.StatusPending && a.obj.Status != acme.StatusValid {
+			writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Authorization is " + a.obj.Status, HTTPStatus: http.StatusBadRequest})
+			return nil
+		}
+		a.obj.Status = acme.StatusDeactivated
+		saveAuthz(a)
+	}
+	return a.copy()
+}
+
+// Challenge POST-as-GET or validation request
+func challHandler(w http.ResponseWriter, r *http.Request) interface{} {
+	id := path.Base(path.Dir(r.URL.Path))
+	index, err := strconv.Atoi(path.Base(r.URL.Path))
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	body, err := readPayload(r)
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Can not read request", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	a := authzs[id]
+	if a == nil || a.account != requestAccount(r).ID || index < 0 || index >= len(a.obj.Challenges) {
+		fmt.Println("Not found")
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil
+	}
+	w.Header().Add("Link", "<"+createURL(r, path.Join(authzPath, id))+">;rel=\"up\"")
+	refreshAuthz(a)
+	chall := &a.obj.Challenges[index]
+
+	// An empty JSON object starts the validation, an empty payload is a POST-as-GET
+	if len(body) > 0 && chall.Status == acme.StatusPending {
+		if a.obj.Status != acme.StatusPending {
+			writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Authorization is " + a.obj.Status, HTTPStatus: http.StatusBadRequest})
+			return nil
+		}
+		chall.Status = acme.StatusProcessing
+		saveAuthz(a)
+		go validateChallenge(a, index)
+	}
+	return *chall
+}
@@ -0,0 +1,94 @@
+package acmeca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"path"
+	"simpleca/internal/ca"
+	"simpleca/internal/cert"
+	"strconv"
+	"strings"
+)
+
+////
+// Variables & Constants
+////
+
+const issuerPath = "/issuer/"
+
+// Issuing chains sent along with certificates, the default one first
+var chains [][]*x509.Certificate
+
+////
+// Utility functions
+////
+
+// Load alternate issuing chains (e.g. the CA certificate cross-signed by another root)
+// Each file holds a certificate of the CA key followed by its parents.
+func loadAltChains(filenames []string) error {
+	chains = [][]*x509.Certificate{CaChain}
+	for _, filename := range filenames {
+		certs, err := cert.LoadCertsFile(filename)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(certs[0].RawSubject, CaCert.RawSubject) || !keysEqual(certs[0].PublicKey, CaCert.PublicKey) {
+			return errors.New("Alternate chain " + filename + " does not start with a certificate of the certificate authority")
+		}
+		chains = append(chains, append([]*x509.Certificate{certs[0]}, ca.IssuingChain(certs[1:])...))
+	}
+	return nil
+}
+
+// Certificate of the issuer of the certificates, in a chain
+func chainIssuer(n int) *x509.Certificate {
+	if n == 0 || len(chains[n]) == 0 {
+		return CaCert
+	}
+	return chains[n][0]
+}
+
+// Certificate URL of a chain (the default one has no index)
+func chainURL(r *http.Request, orderID string, n int) string {
+	if n == 0 {
+		return createURL(r, path.Join(certificatePath, orderID))
+	}
+	return createURL(r, path.Join(certificatePath, orderID, strconv.Itoa(n)))
+}
+
+// Add the issuer and alternate chain links of a certificate
+func addChainLinks(w http.ResponseWriter, r *http.Request, orderID string, n int) {
+	w.Header().Add("Link", "<"+createURL(r, path.Join(issuerPath, strconv.Itoa(n)))+">;rel=\"up\"")
+	for i := range chains {
+		if i != n {
+			w.Header().Add("Link", "<"+chainURL(r, orderID, i)+">;rel=\"alternate\"")
+		}
+	}
+}
+
+// Test if a request accepts a media type explicitly
+func accepts(r *http.Request, mediaType string) bool {
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		if strings.TrimSpace(strings.Split(a, ";")[0]) == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+////
+// Handlers
+////
+
+// Certificate of the issuer of a chain (DER)
+func issuerHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(path.Base(r.URL.Path))
+	if err != nil || n < 0 || n >= len(chains) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Add("Content-Type", "application/pkix-cert")
+	w.Write(chainIssuer(n).Raw)
+}
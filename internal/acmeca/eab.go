@@ -0,0 +1,290 @@
+package acmeca
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"simpleca/internal/acme"
+	"text/tabwriter"
+	"time"
+)
+
+////
+// Types
+////
+
+// External account binding key provisioned by an operator (RFC 8555 section 7.3.4)
+type eabKey struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"` // HMAC key, base64url encoded
+	Account   string    `json:"account,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+////
+// Variables & Constants
+////
+
+var (
+	eabRequired bool
+	eabKeys     = map[string]*eabKey{} // protected by accountsMtx
+	eabLoaded   time.Time              // last load of the keys from the storage, protected by accountsMtx
+
+	// Minimum interval between two loads of the keys for an unknown key identifier
+	eabReloadInterval = 10 * time.Second
+
+	// Key identifiers are used as file names
+	eabKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+////
+// Utility functions
+////
+
+func eabUsage() {
+	fmt.Print(`
+Usage:  simpleca acme eab COMMAND
+
+Manage the external account binding keys of the ACME server
+
+Commands:
+  add              Create a key binding new accounts to an external account
+  list             List external account binding keys
+
+`)
+}
+
+func EABAddUsage() {
+	fmt.Println(`
+Usage:  simpleca acme eab add [OPTIONS]
+
+Create a key binding new accounts to an external account, the key identifier and
+the HMAC key (base64url encoded) must be given to the ACME client
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func EABListUsage() {
+	fmt.Println(`
+Usage:  simpleca acme eab list [OPTIONS]
+
+List external account binding keys
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+// External account binding key management
+func EAB(args []string) {
+	if len(args) <= 1 {
+		eabUsage()
+		return
+	}
+	switch cmd := args[1]; cmd {
+	case "add":
+		EABAdd(args[1:])
+	case "list":
+		EABList(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown command "+cmd)
+		eabUsage()
+		os.Exit(1)
+	}
+}
+
+// Open the file storage of the data directory
+func openStorage(dataDirectory, caCertFile string) *FileStorage {
+	if len(dataDirectory) == 0 {
+		dataDirectory = filepath.Join(filepath.Dir(caCertFile), "acme")
+	}
+	s, err := NewFileStorage(dataDirectory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	return s
+}
+
+func EABAdd(args []string) {
+	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificate authority")
+	dataDirectory := f.String("data", "", "ACME data directory (default acme next to the CA certificate)")
+	kid := f.String("kid", "", "Key identifier, letters, digits, - and _ (default random)")
+
+	f.SetUsage(EABAddUsage)
+	f.Parse(args[1:])
+	if f.NArg() != 0 {
+		EABAddUsage()
+	}
+
+	s := openStorage(*dataDirectory, *caCertFile)
+	if len(*kid) == 0 {
+		*kid = randomID()
+	} else if !eabKeyID.MatchString(*kid) {
+		fmt.Fprintln(os.Stderr, "Invalid key identifier "+*kid+" (letters, digits, - and _ only)")
+		os.Exit(1)
+	}
+	keys, err := s.LoadEABKeys()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	for _, k := range keys {
+		if k.ID == *kid {
+			fmt.Fprintln(os.Stderr, "External account binding key "+*kid+" already exists")
+			os.Exit(1)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	k := &eabKey{ID: *kid, Key: base64.RawURLEncoding.EncodeToString(b), CreatedAt: time.Now()}
+	if err = s.SaveEABKey(k); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("Key ID:   " + k.ID)
+	fmt.Println("HMAC key: " + k.Key)
+}
+
+func EABList(args []string) {
+	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificate authority")
+	dataDirectory := f.String("data", "", "ACME data directory (default acme next to the CA certificate)")
+
+	f.SetUsage(EABListUsage)
+	f.Parse(args[1:])
+	if f.NArg() != 0 {
+		EABListUsage()
+	}
+
+	keys, err := openStorage(*dataDirectory, *caCertFile).LoadEABKeys()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY ID\tCREATED\tACCOUNT")
+	for _, k := range keys {
+		fmt.Fprintln(w, k.ID+"\t"+k.CreatedAt.Format(time.RFC3339)+"\t"+k.Account)
+	}
+	w.Flush()
+}
+
+// Verify the external account binding of a new account signed by a JSON web key
+// The binding is a JWS of the account key, MAC-ed with the HMAC key of the key identifier.
+func verifyEAB(r *http.Request, body []byte, jwk *jsonWebKey) (*eabKey, error) {
+	unauthorized := func(detail string) error {
+		return acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: detail, HTTPStatus: http.StatusUnauthorized}
+	}
+	jws, header, payload, sig, err := decodeJWS(body)
+	if err != nil {
+		return nil, err
+	}
+	var h func() hash.Hash
+	switch header.Alg {
+	case "HS256":
+		h = sha256.New
+	case "HS384":
+		h = sha512.New384
+	case "HS512":
+		h = sha512.New
+	default:
+		return nil, acme.ProblemDetails{Type: acme.BadSignatureAlgorithmErr, Detail: "Unsupported external account binding algorithm " + header.Alg, HTTPStatus: http.StatusBadRequest}
+	}
+	if len(header.Kid) == 0 || len(header.Jwk) > 0 || len(header.Nonce) > 0 {
+		return nil, malformed("External account binding must have a key identifier and no nonce")
+	}
+	if header.URL != requestHeader(r).URL {
+		return nil, malformed("External account binding url does not match the request url")
+	}
+	bound, err := parseJWK(payload)
+	if err != nil || bound.Thumbprint() != jwk.Thumbprint() {
+		return nil, malformed("External account binding is not for the account key")
+	}
+
+	k := findEABKey(header.Kid)
+	if k == nil {
+		return nil, unauthorized("Unknown external account binding key " + header.Kid)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, errors.New("Invalid external account binding key " + k.ID)
+	}
+	mac := hmac.New(h, key)
+	mac.Write([]byte(jws.Protected + "." + jws.Payload))
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return nil, unauthorized("External account binding signature verification failed")
+	}
+	return k, nil
+}
+
+// External account binding key by identifier, keys added since the server started are loaded
+// from the storage (at most once per eabReloadInterval, outside of the lock)
+func findEABKey(kid string) *eabKey {
+	accountsMtx.Lock()
+	k := eabKeys[kid]
+	reload := k == nil && time.Since(eabLoaded) >= eabReloadInterval
+	if reload {
+		eabLoaded = time.Now()
+	}
+	accountsMtx.Unlock()
+	if !reload {
+		return k
+	}
+
+	keys, err := store.LoadEABKeys()
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	accountsMtx.Lock()
+	defer accountsMtx.Unlock()
+	for _, k := range keys {
+		if eabKeys[k.ID] == nil {
+			eabKeys[k.ID] = k
+		}
+	}
+	return eabKeys[kid]
+}
+
+// Bind an external account binding key to an account, a key binds a single account
+func bindEABKey(k *eabKey, account string) error {
+	accountsMtx.Lock()
+	defer accountsMtx.Unlock()
+	current := eabKeys[k.ID]
+	if len(current.Account) > 0 && current.Account != account {
+		return acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "External account binding key " + k.ID + " is already used", HTTPStatus: http.StatusUnauthorized}
+	}
+	bound := *current
+	bound.Account = account
+	if err := store.SaveEABKey(&bound); err != nil {
+		return err
+	}
+	eabKeys[k.ID] = &bound
+	return nil
+}
+
+// Restore a key as it was before bindEABKey, when its account could not be saved
+func unbindEABKey(k *eabKey) {
+	accountsMtx.Lock()
+	defer accountsMtx.Unlock()
+	if err := store.SaveEABKey(k); err != nil {
+		fmt.Println(err)
+		return
+	}
+	eabKeys[k.ID] = k
+}
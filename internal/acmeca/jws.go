@@ -0,0 +1,352 @@
+package acmeca
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"simpleca/internal/acme"
+	"strings"
+)
+
+////
+// Types
+////
+
+// Flattened JWS JSON serialization (RFC 7515 section 7.2.2)
+type jwsobj struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// JWS protected header (RFC 8555 section 6.2)
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	Jwk   json.RawMessage `json:"jwk,omitempty"`
+	Kid   string          `json:"kid,omitempty"`
+	Nonce string          `json:"nonce,omitempty"`
+	URL   string          `json:"url"`
+}
+
+// JSON web key (RFC 7517) of RSA, EC and OKP (Ed25519) types
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Keys accepted to sign a request
+type signer int
+
+const (
+	signedByAccount signer = iota // key identifier of an existing account
+	signedByJWK                   // JSON web key of the protected header (newAccount)
+	signedByAny                   // either of them (revokeCert)
+)
+
+type ctxKey int
+
+const (
+	accountCtxKey ctxKey = iota
+	jwkCtxKey
+	headerCtxKey
+)
+
+////
+// Utility functions
+////
+
+func parseJWK(data []byte) (*jsonWebKey, error) {
+	var k jsonWebKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, errors.New("invalid JWK")
+	}
+	return &k, nil
+}
+
+// Public key of a JSON web key
+func (k *jsonWebKey) PublicKey() (crypto.PublicKey, error) {
+	decode := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	switch k.Kty {
+	case "RSA":
+		n, e := decode(k.N), decode(k.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA JWK")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported JWK curve " + k.Crv)
+		}
+		x, y := decode(k.X), decode(k.Y)
+		if x == nil || y == nil || !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC JWK")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP JWK")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("unsupported JWK type " + k.Kty)
+}
+
+// JWK thumbprint (RFC 7638)
+func (k *jsonWebKey) Thumbprint() string {
+	var s string
+	switch k.Kty {
+	case "RSA":
+		s = `{"e":"` + k.E + `","kty":"RSA","n":"` + k.N + `"}`
+	case "EC":
+		s = `{"crv":"` + k.Crv + `","kty":"EC","x":"` + k.X + `","y":"` + k.Y + `"}`
+	default:
+		s = `{"crv":"` + k.Crv + `","kty":"` + k.Kty + `","x":"` + k.X + `"}`
+	}
+	sum := sha256.Sum256([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Verify a JWS signature (RS256, ES256, ES384, ES512 and EdDSA)
+func verifySignature(alg string, publicKey crypto.PublicKey, input, sig []byte) error {
+	errSig := errors.New("JWS signature verification failed")
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			break
+		}
+		sum := sha256.Sum256(input)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) != nil {
+			return errSig
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch {
+		case alg == "ES256" && pub.Curve == elliptic.P256():
+			sum := sha256.Sum256(input)
+			digest = sum[:]
+		case alg == "ES384" && pub.Curve == elliptic.P384():
+			sum := sha512.Sum384(input)
+			digest = sum[:]
+		case alg == "ES512" && pub.Curve == elliptic.P521():
+			sum := sha512.Sum512(input)
+			digest = sum[:]
+		default:
+			return acme.ProblemDetails{Type: acme.BadSignatureAlgorithmErr, Detail: "Unsupported JWS algorithm " + alg + " for this key", HTTPStatus: http.StatusBadRequest}
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errSig
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errSig
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			break
+		}
+		if !ed25519.Verify(pub, input, sig) {
+			return errSig
+		}
+		return nil
+	}
+	return acme.ProblemDetails{Type: acme.BadSignatureAlgorithmErr, Detail: "Unsupported JWS algorithm " + alg + " for this key", HTTPStatus: http.StatusBadRequest}
+}
+
+func malformed(detail string) error {
+	return acme.ProblemDetails{Type: acme.MalformedErr, Detail: detail, HTTPStatus: http.StatusBadRequest}
+}
+
+// Decode a JWS in flattened JSON serialization
+func decodeJWS(body []byte) (jws *jwsobj, header *jwsHeader, payload, sig []byte, err error) {
+	if err = json.Unmarshal(body, &jws); err != nil || jws == nil {
+		return nil, nil, nil, nil, malformed("Invalid JWS")
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, nil, nil, nil, malformed("Invalid JWS protected header encoding")
+	}
+	if err = json.Unmarshal(protected, &header); err != nil || header == nil {
+		return nil, nil, nil, nil, malformed("Invalid JWS protected header")
+	}
+	if payload, err = base64.RawURLEncoding.DecodeString(jws.Payload); err != nil {
+		return nil, nil, nil, nil, malformed("Invalid JWS payload encoding")
+	}
+	if sig, err = base64.RawURLEncoding.DecodeString(jws.Signature); err != nil {
+		return nil, nil, nil, nil, malformed("Invalid JWS signature encoding")
+	}
+	return jws, header, payload, sig, nil
+}
+
+// JSON web key of a protected header
+func headerJWK(header *jwsHeader) (*jsonWebKey, crypto.PublicKey, error) {
+	jwk, err := parseJWK(header.Jwk)
+	if err != nil {
+		return nil, nil, acme.ProblemDetails{Type: acme.BadPublicKeyErr, Detail: err.Error(), HTTPStatus: http.StatusBadRequest}
+	}
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		return nil, nil, acme.ProblemDetails{Type: acme.BadPublicKeyErr, Detail: err.Error(), HTTPStatus: http.StatusBadRequest}
+	}
+	return jwk, publicKey, nil
+}
+
+// Decode and verify the JWS of a request
+// The request must be signed by the JSON web key of the protected header (newAccount),
+// by the key of the existing account given by the key identifier, or by any of them.
+func verifyJWS(r *http.Request, body []byte, by signer) (payload []byte, header *jwsHeader, jwk *jsonWebKey, acct *account, err error) {
+	jws, header, payload, sig, err := decodeJWS(body)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if u, err := url.Parse(header.URL); err != nil || u.Path != r.URL.Path {
+		return nil, nil, nil, nil, acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "JWS url does not match the request URL", HTTPStatus: http.StatusUnauthorized}
+	}
+	if by == signedByAny {
+		if len(header.Jwk) > 0 {
+			by = signedByJWK
+		} else {
+			by = signedByAccount
+		}
+	}
+
+	var publicKey crypto.PublicKey
+	if by == signedByJWK {
+		if len(header.Jwk) == 0 || len(header.Kid) > 0 {
+			return nil, nil, nil, nil, malformed("JWS must be signed with a JWK")
+		}
+		if jwk, publicKey, err = headerJWK(header); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	} else {
+		if len(header.Kid) == 0 || len(header.Jwk) > 0 {
+			return nil, nil, nil, nil, malformed("JWS must be signed with a key identifier")
+		}
+		u, err := url.Parse(header.Kid)
+		if err != nil || !strings.HasPrefix(u.Path, accountPath) {
+			return nil, nil, nil, nil, malformed("Invalid key identifier")
+		}
+		if acct = getAccount(path.Base(u.Path)); acct == nil {
+			return nil, nil, nil, nil, acme.ProblemDetails{Type: acme.AccountDoesNotExistErr, Detail: "Account does not exist", HTTPStatus: http.StatusBadRequest}
+		}
+		if acct.Status != acme.StatusValid {
+			return nil, nil, nil, nil, acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "Account is " + acct.Status, HTTPStatus: http.StatusUnauthorized}
+		}
+		if publicKey, err = acct.PublicKey(); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	if err = verifySignature(header.Alg, publicKey, []byte(jws.Protected+"."+jws.Payload), sig); err != nil {
+		if _, ok := err.(acme.ProblemDetails); !ok {
+			err = malformed(err.Error())
+		}
+		return nil, nil, nil, nil, err
+	}
+	if !useNonce(header.Nonce) {
+		return nil, nil, nil, nil, acme.ProblemDetails{Type: acme.BadNonceErr, Detail: "JWS has an invalid anti-replay nonce", HTTPStatus: http.StatusBadRequest}
+	}
+	return payload, header, jwk, acct, nil
+}
+
+// Account which signed the request
+func requestAccount(r *http.Request) *account {
+	a, _ := r.Context().Value(accountCtxKey).(*account)
+	return a
+}
+
+// JSON web key which signed the request (newAccount)
+func requestJWK(r *http.Request) *jsonWebKey {
+	k, _ := r.Context().Value(jwkCtxKey).(*jsonWebKey)
+	return k
+}
+
+// JWS protected header of the request
+func requestHeader(r *http.Request) *jwsHeader {
+	h, _ := r.Context().Value(headerCtxKey).(*jwsHeader)
+	return h
+}
+
+////
+// Middleware
+////
+
+func jwsMiddleware(h http.Handler, by signer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1024*1024))
+		if err != nil {
+			writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Can not read request", HTTPStatus: http.StatusBadRequest})
+			return
+		}
+		payload, header, jwk, acct, err := verifyJWS(r, body, by)
+		if err != nil {
+			if problem, ok := err.(acme.ProblemDetails); ok {
+				writeProblem(w, problem)
+			} else {
+				writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), accountCtxKey, acct)
+		ctx = context.WithValue(ctx, jwkCtxKey, jwk)
+		ctx = context.WithValue(ctx, headerCtxKey, header)
+		r = r.WithContext(ctx)
+		r.Body = io.NopCloser(bytes.NewReader(payload))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Requests signed by an existing account (key identifier)
+func jwtMiddleware(h http.Handler) http.Handler {
+	return jwsMiddleware(h, signedByAccount)
+}
+
+// Requests signed by a JSON web key (newAccount)
+func jwkMiddleware(h http.Handler) http.Handler {
+	return jwsMiddleware(h, signedByJWK)
+}
+
+// Requests signed by an existing account or by a JSON web key (revokeCert)
+func jwsAnyMiddleware(h http.Handler) http.Handler {
+	return jwsMiddleware(h, signedByAny)
+}
@@ -1,28 +1,28 @@
 package acmeca
 
 import (
-	"bytes"
-	"crypto/rand"
-	"io"
-
-	//	"crypto/ecdsa"
-	//	"crypto/elliptic"
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math/big"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"simpleca/flags"
 	"simpleca/internal/acme"
+	"simpleca/internal/ca"
 	"simpleca/internal/cert"
 	"simpleca/internal/key"
+	"simpleca/internal/policy"
 	"simpleca/tools"
 	"strconv"
 	"strings"
@@ -41,15 +41,21 @@ Usage:  simpleca acme COMMAND
 Start an ACME certificate authority web server
 
 Commands:
-  
+  eab              Manage external account binding keys
+
+Run "simpleca acme [OPTIONS]" to start the server.
 
 `)
 }
 
 var (
-	CaKey  *rsa.PrivateKey   = nil
-	CaCert *x509.Certificate = nil
-	days   int               = 90
+	CaKey   crypto.Signer       = nil
+	CaCert  *x509.Certificate   = nil
+	CaChain []*x509.Certificate = nil
+	CaDb    *ca.Database        = nil
+	Profile *ca.Profile         = nil
+	Policy  *policy.Policy      = nil
+	days    int                 = 90
 )
 
 ////
@@ -67,26 +73,47 @@ const (
 	finalizePath    = "/finalize/"
 	certificatePath = "/certificate/"
 	orderPath       = "/order/"
+	accountPath     = "/account/"
 )
 
 func Main(args []string) {
+	if len(args) > 1 && args[1] == "eab" {
+		EAB(args[1:])
+		return
+	}
 	port := f.String("port", ":8080", "Port server")
 
 	caKeyFile := f.String("ca-key", "ca.key", "Private key of the certificate authority")
 	caPassphrase := f.String("ca-pass", "", "Private key passphrase of the certificate authority")
 	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificate authority")
+	dbFile := f.String("db", "", "Issuance database file (default index.json next to the CA certificate)")
+	profilesFile := f.String("profiles", "", "Issuance profiles file (YAML)")
+	profile := f.String("profile", "server", "Issuance profile")
+	policyFile := f.String("policy", "", "Issuance policy file (YAML)")
+	dataDirectory := f.String("data", "", "ACME data directory (default acme next to the CA certificate)")
+	httpPort := f.Int("http01-port", http01Port, "Port used to validate http-01 challenges")
+	tlsPort := f.Int("tls-alpn01-port", tlsALPN01Port, "Port used to validate tls-alpn-01 challenges")
+	resolverAddress := f.String("dns-resolver", "", "DNS server address (host:port) used to validate dns-01 challenges (default system resolver)")
 
 	ssl := f.Bool("ssl", false, "Enable SSL server mode")
 	keyFile := f.String("key", "", "Private key of the ACME web server (if ssl enabled)")
 	certFile := f.String("cert", "", "Certificate of the ACME web server (if ssl enabled)")
 
 	nbDays := f.Int("days", 0, "Not valid after days")
+	ttl := f.Duration("nonce-ttl", nonceTTL, "Validity of replay nonces")
+	altChains := f.String("alt-chains", "", "Alternate issuing chain files, comma separated (each starting with a certificate of the CA key, e.g. cross-signed)")
+	eab := f.Bool("eab-required", false, "Require an external account binding to create accounts (keys created with simpleca acme eab add)")
 
 	f.Parse(args[1:])
 
 	if *nbDays != 0 {
 		days = *nbDays
 	}
+	nonceTTL = *ttl
+	eabRequired = *eab
+	http01Port = *httpPort
+	tlsALPN01Port = *tlsPort
+	dnsResolver = *resolverAddress
 	if b, _ := tools.Exists(*caKeyFile); !b {
 		fmt.Fprintln(os.Stderr, "Certificate authority private key does not exist")
 		os.Exit(1)
@@ -112,25 +139,78 @@ func Main(args []string) {
 	}
 
 	var err error
-	CaKey, err = key.LoadRSAKeyFile(*caKeyFile, *caPassphrase)
+	CaKey, err = key.LoadSignerFile(*caKeyFile, *caPassphrase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	CaCert, CaChain, err = ca.LoadCAChainFile(*caCertFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	altChainFiles := []string{}
+	if len(*altChains) > 0 {
+		altChainFiles = strings.Split(*altChains, ",")
+	}
+	if err = loadAltChains(altChainFiles); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(*dbFile) == 0 {
+		*dbFile = ca.DatabaseFilename(*caCertFile)
+	}
+	CaDb, err = ca.LoadDatabase(*dbFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	CaCert, err = cert.LoadCertFile(*caCertFile)
+	profiles, err := ca.LoadProfiles(*profilesFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
+	if Profile = profiles[*profile]; Profile == nil {
+		fmt.Fprintln(os.Stderr, "Unknown profile "+*profile)
+		os.Exit(1)
+	}
+	Policy, err = policy.Load(*policyFile)
+	if err == nil {
+		err = Policy.CheckProfile(Profile.Name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(*dataDirectory) == 0 {
+		*dataDirectory = filepath.Join(filepath.Dir(*caCertFile), "acme")
+	}
+	storage, err := NewFileStorage(*dataDirectory)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if err = loadState(storage); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	go cleanupLoop()
 
 	mux := http.NewServeMux()
 	mux.Handle(directoryPath, jsonMiddleware(directoryHandler))
 	mux.HandleFunc(newNoncePath, nonceHandler)
-	mux.Handle(newAccountPath, jsonMiddleware(accountHandler))
+	mux.Handle(newAccountPath, jwkMiddleware(jsonMiddleware(accountHandler)))
+	mux.Handle(accountPath, jwtMiddleware(jsonMiddleware(updateAccountHandler)))
+	mux.Handle(keyChangePath, jwtMiddleware(jsonMiddleware(keyChangeHandler)))
 	mux.Handle(newOrderPath, jwtMiddleware(jsonMiddleware(newOrderHandler)))
+	mux.Handle(revokeCertPath, jwsAnyMiddleware(jsonMiddleware(revokeCertHandler)))
 	mux.Handle(finalizePath, jwtMiddleware(jsonMiddleware(finalizeHandler)))
-	mux.HandleFunc(certificatePath, certHandler)
-	mux.Handle(orderPath, jsonMiddleware(orderHandler))
+	mux.Handle(certificatePath, jwtMiddleware(http.HandlerFunc(certHandler)))
+	mux.Handle(orderPath, jwtMiddleware(jsonMiddleware(orderHandler)))
+	mux.Handle(authzPath, jwtMiddleware(jsonMiddleware(authzHandler)))
+	mux.Handle(challPath, jwtMiddleware(jsonMiddleware(challHandler)))
+	mux.HandleFunc(issuerPath, issuerHandler)
+	mux.Handle(renewalInfoPath, jsonMiddleware(renewalInfoHandler))
 
 	if !strings.Contains(*port, ":") {
 		*port = ":" + *port
@@ -138,9 +218,9 @@ func Main(args []string) {
 	fmt.Println("Starting ACME web server on port " + *port + " ...")
 
 	if *ssl {
-		log.Fatal(http.ListenAndServeTLS(*port, *certFile, *keyFile, mux))
+		log.Fatal(http.ListenAndServeTLS(*port, *certFile, *keyFile, nonceMiddleware(indexMiddleware(mux))))
 	} else {
-		log.Fatal(http.ListenAndServe(*port, mux))
+		log.Fatal(http.ListenAndServe(*port, nonceMiddleware(indexMiddleware(mux))))
 	}
 
 }
@@ -152,28 +232,27 @@ func Main(args []string) {
 type acmeFn func(http.ResponseWriter, *http.Request) interface{}
 
 type orderCtx struct {
-	obj *acme.Order
-	crt []byte
-}
-
-type jwsobj struct {
-	Protected string `json:"protected"`
-	Payload   string `json:"payload"`
-	Signature string `json:"signature"`
+	id      string
+	obj     *acme.Order
+	crt     []byte
+	account string
+	authzs  []string
+	created time.Time
 }
 
 ////
 // Variables & Constants
 ////
 
-var orders []*orderCtx
+// Orders by ID, authorizations and challenges are protected by ordersMtx
+var orders = map[string]*orderCtx{}
 var ordersMtx sync.Mutex
 
 ////
 // Utility functions
 ////
 
-func createCrt(csrMsg *acme.CSRMessage) ([]byte, error) {
+func parseCSR(csrMsg *acme.CSRMessage) (*x509.CertificateRequest, error) {
 	data, err := base64.RawURLEncoding.DecodeString(csrMsg.Csr)
 	if err != nil {
 		fmt.Println("Can not read ACME message")
@@ -185,67 +264,137 @@ func createCrt(csrMsg *acme.CSRMessage) ([]byte, error) {
 		fmt.Println("Can not parse CSR")
 		return nil, err
 	}
+	return csr, nil
+}
 
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+// Sign a certificate with the validated identifiers only: the subject is reduced
+// to the common name (one of the identifiers) and the alternate names to the DNS names and IP addresses
+func createCrt(csr *x509.CertificateRequest) ([]byte, error) {
+	validated := *csr
+	validated.Subject = pkix.Name{CommonName: csr.Subject.CommonName}
+	validated.EmailAddresses = nil
+	validated.URIs = nil
+	crt, err := ca.CASign(&validated, Policy.Days(Profile.Name, days), CaCert, CaKey, "", "", "", Profile)
 	if err != nil {
 		return nil, err
 	}
-
-	temp := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject:      csr.Subject,
-		//	SignatureAlgorithm: csr.SignatureAlgorithm,
-		//	Signature:          csr.Signature,
-		NotBefore: time.Now().AddDate(0, 0, -1),
-		NotAfter:  time.Now().AddDate(0, 0, days),
-		//	KeyUsage:           x509.KeyUsageDigitalSignature,
-		//	ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		//PublicKey:          csr.PublicKey.(*rsa.PublicKey),
-		DNSNames:       csr.DNSNames,
-		EmailAddresses: csr.EmailAddresses,
-		IPAddresses:    csr.IPAddresses,
-	}
-	/*
-			switch pub:=csr.PublicKey.(type) {
-			case *rsa.PublicKey:
-			    temp.PublicKey=csr.PublicKey.(*rsa.PublicKey)
-			case *ecdsa.PublicKey:
-			    temp.PublicKey=csr.PublicKey.(*ecdsa.PublicKey)
-			    switch pub.Curve {
-		 	    case elliptic.P224(), elliptic.P256():
-				temp.SignatureAlgorithm = x509.ECDSAWithSHA256
-			    case elliptic.P384():
-				temp.SignatureAlgorithm = x509.ECDSAWithSHA384
-			    case elliptic.P521():
-				temp.SignatureAlgorithm = x509.ECDSAWithSHA512
-			    default:
-				err = errors.New("x509: unknown elliptic curve")
-				return nil, err
-			    }
-			}*/
-
-	//return x509.CreateCertificate(rand.Reader, &temp, &temp, &key.PublicKey, key)
-	//	return x509.CreateCertificate(rand.Reader, &temp, CaCert, csr.PublicKey, CaKey)
-
-	return x509.CreateCertificate(rand.Reader, &temp, CaCert, csr.PublicKey, CaKey)
+	return crt.Raw, nil
 }
 
-func getOrder(r *http.Request) (*orderCtx, error) {
-	id, err := strconv.Atoi(path.Base(r.URL.Path))
+func recordCrt(der []byte, r *http.Request) error {
+	crt, err := x509.ParseCertificate(der)
 	if err != nil {
-		fmt.Println("Can not get id")
-		return nil, err
+		return err
+	}
+	requester := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(requester); err == nil {
+		requester = host
 	}
+	return CaDb.Add(crt, "acme:"+requester)
+}
 
+func getOrder(r *http.Request, id string) (*orderCtx, error) {
 	ordersMtx.Lock()
 	defer ordersMtx.Unlock()
 
-	if id < len(orders) {
-		return orders[id], nil
-	} else {
+	if orders[id] == nil {
 		return nil, errors.New("order not found")
+	} else if a := requestAccount(r); a == nil || orders[id].account != a.ID {
+		return nil, errors.New("order of another account")
+	}
+	return orders[id], nil
+}
+
+// Test if the names of a CSR are exactly the identifiers of an order
+// (email and URI alternate names can not be validated by ACME)
+func csrMatchesOrder(csr *x509.CertificateRequest, identifiers []acme.Identifier) bool {
+	if len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return false
+	}
+	names := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		names["dns:"+strings.ToLower(name)] = true
 	}
+	for _, ip := range csr.IPAddresses {
+		names["ip:"+ip.String()] = true
+	}
+	expected := map[string]bool{}
+	for _, id := range identifiers {
+		value := strings.ToLower(id.Value)
+		if id.Type == "ip" {
+			if ip := net.ParseIP(id.Value); ip != nil {
+				value = ip.String()
+			}
+		}
+		expected[id.Type+":"+value] = true
+	}
+	if cn := csr.Subject.CommonName; len(cn) > 0 && !names["dns:"+strings.ToLower(cn)] && !names["ip:"+cn] {
+		return false
+	}
+	if len(names) != len(expected) {
+		return false
+	}
+	for name := range names {
+		if !expected[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// Read the JWS payload of a request (empty for POST-as-GET)
+func readPayload(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	return io.ReadAll(r.Body)
+}
+
+// Write an ACME problem document
+func writeProblem(w http.ResponseWriter, problem acme.ProblemDetails) {
+	fmt.Println("Problem:", problem.Error())
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.HTTPStatus)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// Convert a policy violation to an ACME problem document
+func policyProblem(err error) acme.ProblemDetails {
+	problem := acme.ProblemDetails{Type: acme.RejectedIdentifierErr, Detail: err.Error(), HTTPStatus: http.StatusForbidden}
+	if v, ok := err.(*policy.Violation); ok {
+		switch v.Type {
+		case policy.TypeDNS, policy.TypeIP:
+			problem.SubProblems = []acme.SubProblem{{
+				Type:       acme.RejectedIdentifierErr,
+				Detail:     v.Reason,
+				Identifier: &acme.Identifier{Type: v.Type, Value: v.Value},
+			}}
+		default:
+			problem.Type = acme.BadCSRErr
+		}
+	}
+	return problem
+}
+
+// Check the identifiers of an order against the issuance policy
+func checkIdentifiers(identifiers []acme.Identifier) error {
+	for _, id := range identifiers {
+		var err error
+		switch id.Type {
+		case "dns":
+			err = Policy.CheckDNSName(id.Value)
+		case "ip":
+			if ip := net.ParseIP(id.Value); ip != nil {
+				err = Policy.CheckIP(ip)
+			} else {
+				err = &policy.Violation{Type: policy.TypeIP, Value: id.Value, Reason: "invalid address"}
+			}
+		default:
+			return acme.ProblemDetails{Type: acme.UnsupportedIdentifierErr, Detail: "Unsupported identifier type " + id.Type, HTTPStatus: http.StatusBadRequest}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func createURL(r *http.Request, path string) string {
@@ -262,26 +411,13 @@ func createURL(r *http.Request, path string) string {
 
 func directoryHandler(w http.ResponseWriter, r *http.Request) interface{} {
 	return acme.Directory{
-		NewNonceURL:   createURL(r, newNoncePath),
-		NewAccountURL: createURL(r, newAccountPath),
-		NewOrderURL:   createURL(r, newOrderPath),
-		RevokeCertURL: createURL(r, revokeCertPath),
-		KeyChangeURL:  createURL(r, keyChangePath),
-	}
-}
-
-func nonceHandler(w http.ResponseWriter, r *http.Request) {
-	// Hardcoded value copied from RFC 8555
-	w.Header().Add("Replay-Nonce", "oFvnlFP1wIhRlYS2jTaXbA")
-
-	w.Header().Add("Cache-Control", "no-store")
-	w.WriteHeader(http.StatusOK)
-}
-
-func accountHandler(w http.ResponseWriter, r *http.Request) interface{} {
-	return acme.Account{
-		Status: acme.StatusValid,
-		Orders: createURL(r, "orders"),
+		NewNonceURL:    createURL(r, newNoncePath),
+		NewAccountURL:  createURL(r, newAccountPath),
+		NewOrderURL:    createURL(r, newOrderPath),
+		RevokeCertURL:  createURL(r, revokeCertPath),
+		KeyChangeURL:   createURL(r, keyChangePath),
+		RenewalInfoURL: createURL(r, renewalInfoPath),
+		Meta:           acme.Meta{ExternalAccountRequired: eabRequired},
 	}
 }
 
@@ -293,31 +429,90 @@ func newOrderHandler(w http.ResponseWriter, r *http.Request) interface{} {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return nil
 	}
+	if len(order.Identifiers) == 0 {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Order without identifiers", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if err = checkIdentifiers(order.Identifiers); err != nil {
+		if problem, ok := err.(acme.ProblemDetails); ok {
+			writeProblem(w, problem)
+		} else {
+			writeProblem(w, policyProblem(err))
+		}
+		return nil
+	}
 
-	ordersMtx.Lock()
-	orderId := strconv.Itoa(len(orders))
-	orders = append(orders, &orderCtx{&order, nil})
-	ordersMtx.Unlock()
+	for _, identifier := range order.Identifiers {
+		if len(challengeTypes(identifier)) == 0 {
+			writeProblem(w, acme.ProblemDetails{Type: acme.RejectedIdentifierErr, Detail: "No challenge type available for " + identifier.Value, HTTPStatus: http.StatusForbidden})
+			return nil
+		}
+	}
 
-	order.Finalize = createURL(r, path.Join(finalizePath, orderId))
+	if len(order.Replaces) > 0 {
+		if err = checkReplaces(requestAccount(r), order.Replaces); err != nil {
+			if problem, ok := err.(acme.ProblemDetails); ok {
+				writeProblem(w, problem)
+			} else {
+				writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+			}
+			return nil
+		}
+	}
+
+	account := requestAccount(r).ID
+	order.Status = acme.StatusPending
+	order.Expires = time.Now().Add(orderLifetime).UTC().Format(time.RFC3339)
 	order.Authorizations = []string{}
-	order.Status = "ready"
+
+	ordersMtx.Lock()
+	orderId := randomID()
+	ctx := &orderCtx{orderId, &order, nil, account, []string{}, time.Now()}
+	created := []*authzCtx{}
+	for _, identifier := range order.Identifiers {
+		a := createAuthz(r, account, identifier)
+		if err = store.SaveAuthz(a); err != nil {
+			break
+		}
+		created = append(created, a)
+		ctx.authzs = append(ctx.authzs, a.id)
+		order.Authorizations = append(order.Authorizations, createURL(r, path.Join(authzPath, a.id)))
+	}
+	order.Finalize = createURL(r, path.Join(finalizePath, orderId))
+	if err == nil {
+		err = store.SaveOrder(ctx)
+	}
+	if err != nil {
+		ordersMtx.Unlock()
+		writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+		return nil
+	}
+	for _, a := range created {
+		authzs[a.id] = a
+	}
+	orders[orderId] = ctx
+	obj := order
+	ordersMtx.Unlock()
 
 	orderURL := createURL(r, path.Join(orderPath, orderId))
 	w.Header().Add("Location", orderURL)
 
 	w.WriteHeader(http.StatusCreated)
-	return order
+	return obj
 }
 
 func finalizeHandler(w http.ResponseWriter, r *http.Request) interface{} {
 	id := path.Base(r.URL.Path)
-	order, err := getOrder(r)
+	order, err := getOrder(r, id)
 	if err != nil {
 		fmt.Println("Not found")
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return nil
 	}
+	if status := orderStatus(order); status != acme.StatusReady {
+		writeProblem(w, acme.ProblemDetails{Type: acme.OrderNotReadyErr, Detail: "Order is " + status, HTTPStatus: http.StatusForbidden})
+		return nil
+	}
 
 	var csrMsg acme.CSRMessage
 	err = json.NewDecoder(r.Body).Decode(&csrMsg)
@@ -327,53 +522,130 @@ func finalizeHandler(w http.ResponseWriter, r *http.Request) interface{} {
 		return nil
 	}
 
-	order.crt, err = createCrt(&csrMsg)
+	csr, err := parseCSR(&csrMsg)
 	if err != nil {
-		fmt.Println("CreateCrt failed: ", err)
-		http.Error(w, "createCrt failed", http.StatusInternalServerError)
+		writeProblem(w, acme.ProblemDetails{Type: acme.BadCSRErr, Detail: "Can not parse CSR", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if !csrMatchesOrder(csr, order.obj.Identifiers) {
+		writeProblem(w, acme.ProblemDetails{Type: acme.BadCSRErr, Detail: "CSR names do not match the order identifiers", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if err = Policy.CheckCSR(csr, Profile.Name); err != nil {
+		writeProblem(w, policyProblem(err))
+		return nil
+	}
+
+	ordersMtx.Lock()
+	refreshOrder(order)
+	if order.obj.Status != acme.StatusReady {
+		ordersMtx.Unlock()
+		writeProblem(w, acme.ProblemDetails{Type: acme.OrderNotReadyErr, Detail: "Order is " + order.obj.Status, HTTPStatus: http.StatusForbidden})
 		return nil
 	}
+	order.obj.Status = acme.StatusProcessing
+	saveOrder(order)
+	ordersMtx.Unlock()
+
+	crt, err := createCrt(csr)
+	if err != nil {
+		fmt.Println("CreateCrt failed: ", err)
+	} else if err = recordCrt(crt, r); err != nil {
+		fmt.Println("Can not record certificate: ", err)
+	}
 
-	order.obj.Status = acme.StatusValid
-	order.obj.Certificate = createURL(r, path.Join(certificatePath, id))
+	certificateURL := createURL(r, path.Join(certificatePath, id))
+	ordersMtx.Lock()
+	if err == nil {
+		order.crt = crt
+		order.obj.Status = acme.StatusValid
+		order.obj.Certificate = certificateURL
+	} else {
+		order.obj.Status = acme.StatusInvalid
+		order.obj.Error = &acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: "Can not issue certificate"}
+	}
+	saveOrder(order)
+	obj := *order.obj
+	ordersMtx.Unlock()
 
 	orderURL := createURL(r, path.Join(orderPath, id))
 	w.Header().Add("Location", orderURL)
 
-	return order.obj
+	return obj
 }
 
 func orderHandler(w http.ResponseWriter, r *http.Request) interface{} {
-	order, err := getOrder(r)
+	order, err := getOrder(r, path.Base(r.URL.Path))
 	if err != nil {
 		fmt.Println("Not found")
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return nil
 	}
 
-	return order.obj
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	refreshOrder(order)
+	return *order.obj
 }
 
+// Certificate with its default issuing chain (/certificate/{order}) or an alternate one (/certificate/{order}/{n})
+// The leaf certificate alone is returned in DER if application/pkix-cert is requested.
 func certHandler(w http.ResponseWriter, r *http.Request) {
-	order, err := getOrder(r)
-	if err != nil {
+	fmt.Println(r.Method, r.URL.String())
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, certificatePath), "/")
+	n := 0
+	if len(parts) == 2 {
+		n, _ = strconv.Atoi(parts[1])
+		if n <= 0 || n >= len(chains) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+	}
+	order, err := getOrder(r, parts[0])
+	if err != nil || len(parts) > 2 {
 		fmt.Println("Not found")
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
+	ordersMtx.Lock()
+	crt := order.crt
+	ordersMtx.Unlock()
+	if crt == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
 
-	err = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: order.crt})
+	addChainLinks(w, r, order.id, n)
+	if accepts(r, "application/pkix-cert") && !accepts(r, "application/pem-certificate-chain") {
+		w.Header().Add("Content-Type", "application/pkix-cert")
+		w.Write(crt)
+		return
+	}
+	w.Header().Add("Content-Type", "application/pem-certificate-chain")
+	err = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: crt})
 	if err != nil {
 		fmt.Println("PEM encoding failed")
 		http.Error(w, "PEM encoding failed", http.StatusInternalServerError)
 		return
 	}
+	w.Write(cert.EncodeCertsToPEM(chains[n]))
 }
 
 ////
 // Middleware
 ////
 
+// Link every resource but the directory to the directory
+func indexMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != directoryPath {
+			u := url.URL{Scheme: "https", Host: r.Host, Path: directoryPath}
+			w.Header().Add("Link", "<"+u.String()+">;rel=\"index\"")
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 func jsonMiddleware(fn acmeFn) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println(r.Method, r.URL.String())
@@ -394,25 +666,3 @@ func jsonMiddleware(fn acmeFn) http.Handler {
 		}
 	})
 }
-
-func jwtMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var jws jwsobj
-		err := json.NewDecoder(r.Body).Decode(&jws)
-		if err != nil {
-			fmt.Println("Invalid JSON")
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
-
-		payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
-		if err != nil {
-			fmt.Println("Invalid Base64")
-			http.Error(w, "Invalid Base64", http.StatusBadRequest)
-			return
-		}
-
-		r.Body = io.NopCloser(bytes.NewReader(payload))
-		h.ServeHTTP(w, r)
-	})
-}
@@ -0,0 +1,74 @@
+package acmeca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"simpleca/internal/acme"
+	"testing"
+)
+
+// Certificate signing request with a common name and alternate names
+func testCSR(t *testing.T, cn string, dnsNames []string, ips []string, emails []string, uris []string) *x509.CertificateRequest {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}, DNSNames: dnsNames, EmailAddresses: emails}
+	for _, ip := range ips {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP(ip))
+	}
+	for _, u := range uris {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestCSRMatchesOrder(t *testing.T) {
+	identifiers := []acme.Identifier{
+		{Type: "dns", Value: "example.com"},
+		{Type: "dns", Value: "www.example.com"},
+		{Type: "ip", Value: "2001:db8:0::1"},
+	}
+	tests := []struct {
+		name     string
+		cn       string
+		dnsNames []string
+		ips      []string
+		emails   []string
+		uris     []string
+		match    bool
+	}{
+		{"same names", "", []string{"example.com", "www.example.com"}, []string{"2001:db8::1"}, nil, nil, true},
+		{"case and order", "", []string{"WWW.example.com", "Example.com"}, []string{"2001:db8::1"}, nil, nil, true},
+		{"common name among the names", "example.com", []string{"example.com", "www.example.com"}, []string{"2001:db8::1"}, nil, nil, true},
+		{"common name not among the names", "other.com", []string{"example.com", "www.example.com"}, []string{"2001:db8::1"}, nil, nil, false},
+		{"missing name", "", []string{"example.com"}, []string{"2001:db8::1"}, nil, nil, false},
+		{"extra name", "", []string{"example.com", "www.example.com", "other.com"}, []string{"2001:db8::1"}, nil, nil, false},
+		{"missing address", "", []string{"example.com", "www.example.com"}, nil, nil, nil, false},
+		{"email", "", []string{"example.com", "www.example.com"}, []string{"2001:db8::1"}, []string{"admin@example.com"}, nil, false},
+		{"uri", "", []string{"example.com", "www.example.com"}, []string{"2001:db8::1"}, nil, []string{"spiffe://example.com/service"}, false},
+	}
+	for _, test := range tests {
+		csr := testCSR(t, test.cn, test.dnsNames, test.ips, test.emails, test.uris)
+		if got := csrMatchesOrder(csr, identifiers); got != test.match {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.match)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package acmeca
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+////
+// Variables & Constants
+////
+
+const nonceMACSize = 16
+
+var (
+	nonceTTL     time.Duration = 10 * time.Minute
+	nonceKey                   = newNonceKey()
+	nonceCounter uint64
+	usedNonces   = map[uint64]time.Time{} // counters of the used nonces until they expire
+	nextPurge    time.Time
+	noncesMtx    sync.Mutex
+)
+
+////
+// Utility functions
+////
+
+// Random HMAC key of the nonces, nonces of a previous run are not valid anymore
+func newNonceKey() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// HMAC of the counter and creation time of a nonce
+func nonceMAC(data []byte) []byte {
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write(data)
+	return mac.Sum(nil)[:nonceMACSize]
+}
+
+// Create a new nonce valid for nonceTTL: a counter and the creation time authenticated
+// by an HMAC, nothing is stored until the nonce is used
+func newNonce() string {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, atomic.AddUint64(&nonceCounter, 1))
+	binary.BigEndian.PutUint64(b[8:], uint64(time.Now().Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(b, nonceMAC(b)...))
+}
+
+// Consume a nonce, return false if it is invalid, already used or expired
+// Used nonces are remembered until they expire.
+func useNonce(nonce string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 16+nonceMACSize || !hmac.Equal(b[16:], nonceMAC(b[:16])) {
+		return false
+	}
+	counter := binary.BigEndian.Uint64(b)
+	expires := time.Unix(int64(binary.BigEndian.Uint64(b[8:])), 0).Add(nonceTTL)
+	now := time.Now()
+	if !now.Before(expires) {
+		return false
+	}
+
+	noncesMtx.Lock()
+	defer noncesMtx.Unlock()
+	if now.After(nextPurge) {
+		for c, e := range usedNonces {
+			if !now.Before(e) {
+				delete(usedNonces, c)
+			}
+		}
+		nextPurge = now.Add(nonceTTL)
+	}
+	if _, used := usedNonces[counter]; used {
+		return false
+	}
+	usedNonces[counter] = expires
+	return true
+}
+
+////
+// Handlers
+////
+
+func nonceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Cache-Control", "no-store")
+	switch r.Method {
+	case "HEAD":
+		w.WriteHeader(http.StatusOK)
+	case "GET", "POST":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+////
+// Middleware
+////
+
+// Add a fresh nonce to every response
+func nonceMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", newNonce())
+		h.ServeHTTP(w, r)
+	})
+}
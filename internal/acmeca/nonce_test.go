@@ -0,0 +1,40 @@
+package acmeca
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUseNonce(t *testing.T) {
+	nonce := newNonce()
+	for i := 0; i < 20000; i++ {
+		newNonce()
+	}
+	if !useNonce(nonce) {
+		t.Errorf("first use after newer nonces: got false, expected true")
+	}
+	if useNonce(nonce) {
+		t.Errorf("second use: got true, expected false")
+	}
+
+	tampered := []byte(newNonce())
+	tampered[len(tampered)-1] ^= 1
+	tests := map[string]string{
+		"unknown":  "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+		"tampered": string(tampered),
+		"invalid":  "not a nonce",
+		"empty":    "",
+	}
+	for name, nonce := range tests {
+		if useNonce(nonce) {
+			t.Errorf("%s: got true, expected false", name)
+		}
+	}
+
+	defer func(ttl time.Duration) { nonceTTL = ttl }(nonceTTL)
+	nonce = newNonce()
+	nonceTTL = -time.Second
+	if useNonce(nonce) {
+		t.Errorf("expired: got true, expected false")
+	}
+}
@@ -0,0 +1,112 @@
+package acmeca
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"simpleca/internal/acme"
+	"simpleca/internal/ca"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////
+// Variables & Constants
+////
+
+const (
+	renewalInfoPath = "/renewal-info/"
+
+	// Delay after which clients should poll the renewal information again
+	renewalInfoRetryAfter = 6 * time.Hour
+)
+
+////
+// Utility functions
+////
+
+// Find the issuance record of an ARI certificate identifier:
+// base64url(authority key identifier) "." base64url(serial number)
+func findRenewalRecord(certID string) (*ca.Record, error) {
+	parts := strings.Split(certID, ".")
+	if len(parts) != 2 {
+		return nil, malformed("Invalid certificate identifier " + certID)
+	}
+	keyID, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(keyID) == 0 {
+		return nil, malformed("Invalid authority key identifier in " + certID)
+	}
+	serial, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(serial) == 0 {
+		return nil, malformed("Invalid serial number in " + certID)
+	}
+	record, err := CaDb.Find(new(big.Int).SetBytes(serial))
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.IssuerKeyID != hex.EncodeToString(keyID) {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// Check the certificate replaced by a new order: it must be known and not already replaced
+func checkReplaces(acct *account, certID string) error {
+	record, err := findRenewalRecord(certID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return malformed("Replaced certificate " + certID + " not found")
+	}
+	crt, err := record.Cert()
+	if err != nil {
+		return err
+	}
+	if !canRevoke(acct, crt) {
+		return acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "Account is not authorized to replace this certificate", HTTPStatus: http.StatusForbidden}
+	}
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	for _, o := range orders {
+		if o.obj.Replaces == certID && o.obj.Status != acme.StatusInvalid {
+			return acme.ProblemDetails{Type: acme.AlreadyReplacedErr, Detail: "Certificate " + certID + " is already replaced by another order", HTTPStatus: http.StatusConflict}
+		}
+	}
+	return nil
+}
+
+////
+// Handlers
+////
+
+// Renewal information of a certificate (unauthenticated GET)
+func renewalInfoHandler(w http.ResponseWriter, r *http.Request) interface{} {
+	if r.Method != http.MethodGet {
+		w.Header().Add("Allow", http.MethodGet)
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Renewal information must be fetched with GET", HTTPStatus: http.StatusMethodNotAllowed})
+		return nil
+	}
+	record, err := findRenewalRecord(strings.TrimPrefix(r.URL.Path, renewalInfoPath))
+	if problem, ok := err.(acme.ProblemDetails); ok {
+		writeProblem(w, problem)
+		return nil
+	}
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+		return nil
+	}
+	if record == nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Certificate not found", HTTPStatus: http.StatusNotFound})
+		return nil
+	}
+
+	window := record.SuggestedRenewal(time.Now())
+	w.Header().Add("Retry-After", strconv.Itoa(int(renewalInfoRetryAfter.Seconds())))
+	return acme.RenewalInfo{
+		SuggestedWindow: acme.RenewalWindow{Start: window.Start.UTC().Truncate(time.Second), End: window.End.UTC().Truncate(time.Second)},
+		ExplanationURL:  window.ExplanationURL,
+	}
+}
@@ -0,0 +1,148 @@
+package acmeca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"simpleca/internal/acme"
+	"simpleca/internal/ca"
+	"strings"
+	"time"
+)
+
+////
+// Variables & Constants
+////
+
+// Revocation reasons which can be requested by ACME clients
+var revocationReasons = []string{"unspecified", "keyCompromise", "affiliationChanged", "superseded", "cessationOfOperation", "privilegeWithdrawn"}
+
+////
+// Utility functions
+////
+
+// Test if an account can revoke a certificate: it ordered it or holds valid authorizations for all its names
+func canRevoke(acct *account, crt *x509.Certificate) bool {
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	for _, o := range orders {
+		if o.account == acct.ID && bytes.Equal(o.crt, crt.Raw) {
+			return true
+		}
+	}
+
+	names := []acme.Identifier{}
+	for _, name := range crt.DNSNames {
+		names = append(names, acme.Identifier{Type: "dns", Value: strings.ToLower(name)})
+	}
+	for _, ip := range crt.IPAddresses {
+		names = append(names, acme.Identifier{Type: "ip", Value: ip.String()})
+	}
+	if len(names) == 0 {
+		return false
+	}
+	for _, name := range names {
+		if !authorized(acct, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare two public keys
+func keysEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// Test if an account holds a valid authorization for an identifier (ordersMtx locked)
+func authorized(acct *account, identifier acme.Identifier) bool {
+	for _, a := range authzs {
+		if a.account != acct.ID {
+			continue
+		}
+		refreshAuthz(a)
+		if a.obj.Status != acme.StatusValid || a.obj.Identifier.Type != identifier.Type {
+			continue
+		}
+		value := strings.ToLower(a.obj.Identifier.Value)
+		if a.obj.Wildcard {
+			value = "*." + value
+		}
+		if value == identifier.Value {
+			return true
+		}
+	}
+	return false
+}
+
+////
+// Handlers
+////
+
+// Certificate revocation, signed by the account key or by the certificate key
+func revokeCertHandler(w http.ResponseWriter, r *http.Request) interface{} {
+	var req acme.RevokeCertMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Invalid revocation request", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.Certificate)
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Invalid certificate encoding", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil || crt.CheckSignatureFrom(CaCert) != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Certificate was not issued by this certificate authority", HTTPStatus: http.StatusNotFound})
+		return nil
+	}
+
+	reason := 0
+	if req.Reason != nil {
+		reason = int(*req.Reason)
+		allowed := false
+		for _, name := range revocationReasons {
+			allowed = allowed || ca.Reasons[name] == reason
+		}
+		if !allowed {
+			writeProblem(w, acme.ProblemDetails{Type: acme.BadRevocationReasonErr, Detail: "Allowed reasons are " + strings.Join(revocationReasons, ", "), HTTPStatus: http.StatusBadRequest})
+			return nil
+		}
+	}
+
+	if acct := requestAccount(r); acct != nil {
+		if !canRevoke(acct, crt) {
+			writeProblem(w, acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "Account is not authorized to revoke this certificate", HTTPStatus: http.StatusForbidden})
+			return nil
+		}
+	} else if publicKey, err := requestJWK(r).PublicKey(); err != nil || !keysEqual(publicKey, crt.PublicKey) {
+		writeProblem(w, acme.ProblemDetails{Type: acme.UnauthorizedErr, Detail: "JWK is not the key of the certificate", HTTPStatus: http.StatusForbidden})
+		return nil
+	}
+
+	record, err := CaDb.Find(crt.SerialNumber)
+	if err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+		return nil
+	}
+	if record == nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Certificate not found", HTTPStatus: http.StatusNotFound})
+		return nil
+	}
+	if record.Status == ca.StatusRevoked {
+		writeProblem(w, acme.ProblemDetails{Type: acme.AlreadyRevokedErr, Detail: "Certificate already revoked", HTTPStatus: http.StatusBadRequest})
+		return nil
+	}
+	if err = CaDb.Revoke(crt.SerialNumber, reason, time.Now()); err != nil {
+		writeProblem(w, acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: err.Error(), HTTPStatus: http.StatusInternalServerError})
+		return nil
+	}
+	fmt.Println("Certificate", ca.FormatSerial(crt.SerialNumber), "revoked:", ca.ReasonName(reason))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,332 @@
+package acmeca
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simpleca/internal/acme"
+	"time"
+)
+
+////
+// Types
+////
+
+// Storage persists the state of the ACME server: accounts, orders with their
+// certificates and authorizations with their challenges
+type Storage interface {
+	LoadAccounts() ([]*account, error)
+	SaveAccount(a *account) error
+
+	LoadEABKeys() ([]*eabKey, error)
+	SaveEABKey(k *eabKey) error
+
+	LoadOrders() ([]*orderCtx, error)
+	SaveOrder(o *orderCtx) error
+	DeleteOrder(id string) error
+
+	LoadAuthzs() ([]*authzCtx, error)
+	SaveAuthz(a *authzCtx) error
+	DeleteAuthz(id string) error
+}
+
+// FileStorage stores each object in a JSON file of a data directory
+// (accounts, eab, orders and authzs sub-directories, certificates in PEM next to their order)
+type FileStorage struct {
+	dir string
+}
+
+type orderRecord struct {
+	ID      string     `json:"id"`
+	Account string     `json:"account"`
+	Created time.Time  `json:"created"`
+	Authzs  []string   `json:"authzs"`
+	Order   acme.Order `json:"order"`
+}
+
+type authzRecord struct {
+	ID            string             `json:"id"`
+	Account       string             `json:"account"`
+	Authorization acme.Authorization `json:"authorization"`
+}
+
+////
+// Variables & Constants
+////
+
+const cleanupInterval = time.Hour
+
+var store Storage
+
+////
+// File storage
+////
+
+// Create a file storage in a data directory
+func NewFileStorage(dir string) (*FileStorage, error) {
+	for _, sub := range []string{"accounts", "eab", "orders", "authzs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, errors.New("Can not create ACME data directory " + dir)
+		}
+	}
+	return &FileStorage{dir}, nil
+}
+
+// Read all JSON files of a sub-directory
+func (s *FileStorage) readAll(sub string, fn func(content []byte) error) error {
+	files, err := filepath.Glob(filepath.Join(s.dir, sub, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return errors.New("Can not read ACME file " + file)
+		}
+		if err = fn(content); err != nil {
+			return errors.New("Can not parse ACME file " + file)
+		}
+	}
+	return nil
+}
+
+// Write a file atomically
+func (s *FileStorage) write(filename string, content []byte) error {
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return errors.New("Can not write ACME file " + filename)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return errors.New("Can not write ACME file " + filename)
+	}
+	return nil
+}
+
+func (s *FileStorage) writeJSON(sub, id string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.write(filepath.Join(s.dir, sub, id+".json"), content)
+}
+
+func (s *FileStorage) remove(filename string) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return errors.New("Can not delete ACME file " + filename)
+	}
+	return nil
+}
+
+func (s *FileStorage) LoadAccounts() ([]*account, error) {
+	list := []*account{}
+	err := s.readAll("accounts", func(content []byte) error {
+		var a account
+		if err := json.Unmarshal(content, &a); err != nil {
+			return err
+		}
+		list = append(list, &a)
+		return nil
+	})
+	return list, err
+}
+
+func (s *FileStorage) SaveAccount(a *account) error {
+	return s.writeJSON("accounts", a.ID, a)
+}
+
+func (s *FileStorage) LoadEABKeys() ([]*eabKey, error) {
+	list := []*eabKey{}
+	err := s.readAll("eab", func(content []byte) error {
+		var k eabKey
+		if err := json.Unmarshal(content, &k); err != nil {
+			return err
+		}
+		list = append(list, &k)
+		return nil
+	})
+	return list, err
+}
+
+func (s *FileStorage) SaveEABKey(k *eabKey) error {
+	return s.writeJSON("eab", k.ID, k)
+}
+
+func (s *FileStorage) LoadOrders() ([]*orderCtx, error) {
+	list := []*orderCtx{}
+	err := s.readAll("orders", func(content []byte) error {
+		var r orderRecord
+		if err := json.Unmarshal(content, &r); err != nil {
+			return err
+		}
+		o := &orderCtx{id: r.ID, obj: &r.Order, account: r.Account, authzs: r.Authzs, created: r.Created}
+		if content, err := os.ReadFile(filepath.Join(s.dir, "orders", r.ID+".pem")); err == nil {
+			if block, _ := pem.Decode(content); block != nil {
+				o.crt = block.Bytes
+			}
+		}
+		list = append(list, o)
+		return nil
+	})
+	return list, err
+}
+
+func (s *FileStorage) SaveOrder(o *orderCtx) error {
+	if o.crt != nil {
+		filename := filepath.Join(s.dir, "orders", o.id+".pem")
+		if err := s.write(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: o.crt})); err != nil {
+			return err
+		}
+	}
+	return s.writeJSON("orders", o.id, orderRecord{o.id, o.account, o.created, o.authzs, *o.obj})
+}
+
+func (s *FileStorage) DeleteOrder(id string) error {
+	if err := s.remove(filepath.Join(s.dir, "orders", id+".pem")); err != nil {
+		return err
+	}
+	return s.remove(filepath.Join(s.dir, "orders", id+".json"))
+}
+
+func (s *FileStorage) LoadAuthzs() ([]*authzCtx, error) {
+	list := []*authzCtx{}
+	err := s.readAll("authzs", func(content []byte) error {
+		var r authzRecord
+		if err := json.Unmarshal(content, &r); err != nil {
+			return err
+		}
+		list = append(list, &authzCtx{r.ID, &r.Authorization, r.Account})
+		return nil
+	})
+	return list, err
+}
+
+func (s *FileStorage) SaveAuthz(a *authzCtx) error {
+	return s.writeJSON("authzs", a.id, authzRecord{a.id, a.account, *a.obj})
+}
+
+func (s *FileStorage) DeleteAuthz(id string) error {
+	return s.remove(filepath.Join(s.dir, "authzs", id+".json"))
+}
+
+////
+// Utility functions
+////
+
+// Load the state of the ACME server from a storage
+func loadState(s Storage) error {
+	store = s
+	list, err := s.LoadAccounts()
+	if err != nil {
+		return err
+	}
+	keys, err := s.LoadEABKeys()
+	if err != nil {
+		return err
+	}
+	accountsMtx.Lock()
+	for _, a := range list {
+		accounts[a.ID] = a
+	}
+	for _, k := range keys {
+		eabKeys[k.ID] = k
+	}
+	accountsMtx.Unlock()
+
+	loadedOrders, err := s.LoadOrders()
+	if err != nil {
+		return err
+	}
+	loadedAuthzs, err := s.LoadAuthzs()
+	if err != nil {
+		return err
+	}
+
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	for _, o := range loadedOrders {
+		// The certificate of an order interrupted while processing was never issued
+		if o.obj.Status == acme.StatusProcessing {
+			o.obj.Status = acme.StatusInvalid
+			o.obj.Error = &acme.ProblemDetails{Type: acme.ServerInternalErr, Detail: "Server restarted while issuing the certificate"}
+			saveOrder(o)
+		}
+		orders[o.id] = o
+	}
+	for _, a := range loadedAuthzs {
+		authzs[a.id] = a
+		// Validations interrupted by a restart are started again
+		for i, c := range a.obj.Challenges {
+			if c.Status == acme.StatusProcessing {
+				go validateChallenge(a, i)
+			}
+		}
+	}
+	return nil
+}
+
+// Persist an order, errors are logged (ordersMtx locked)
+func saveOrder(o *orderCtx) {
+	if err := store.SaveOrder(o); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Persist an authorization, errors are logged (ordersMtx locked)
+func saveAuthz(a *authzCtx) {
+	if err := store.SaveAuthz(a); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Delete expired orders and authorizations
+// Orders without certificate are deleted once expired, the others once their certificate expired.
+// Authorizations are deleted once expired and no longer used by an order.
+func cleanup() {
+	ordersMtx.Lock()
+	defer ordersMtx.Unlock()
+	now := time.Now()
+	used := map[string]bool{}
+	for id, o := range orders {
+		refreshOrder(o)
+		expires, _ := time.Parse(time.RFC3339, o.obj.Expires)
+		if o.crt != nil {
+			if crt, err := x509.ParseCertificate(o.crt); err == nil {
+				expires = crt.NotAfter
+			}
+		}
+		if now.After(expires) {
+			if err := store.DeleteOrder(id); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			delete(orders, id)
+			continue
+		}
+		for _, a := range o.authzs {
+			used[a] = true
+		}
+	}
+	for id, a := range authzs {
+		refreshAuthz(a)
+		if used[id] || !now.After(a.obj.Expires) {
+			continue
+		}
+		if err := store.DeleteAuthz(id); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		delete(authzs, id)
+	}
+}
+
+// Delete expired objects periodically
+func cleanupLoop() {
+	for {
+		cleanup()
+		time.Sleep(cleanupInterval)
+	}
+}
@@ -0,0 +1,182 @@
+package acmeca
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"simpleca/internal/acme"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////
+// Types
+////
+
+// Challenge validation function, returns an acme.ProblemDetails error on failure
+type validator func(identifier acme.Identifier, token, keyAuth string) error
+
+////
+// Variables & Constants
+////
+
+const validationTimeout = 10 * time.Second
+
+var (
+	http01Port    = 80
+	tlsALPN01Port = 443
+	dnsResolver   = ""
+
+	validators = map[string]validator{
+		"http-01":     validateHTTP01,
+		"dns-01":      validateDNS01,
+		"tls-alpn-01": validateTLSALPN01,
+	}
+
+	// id-pe-acmeIdentifier (RFC 8737 section 6.1)
+	oidAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+)
+
+// Resolver used to validate dns-01 challenges (system resolver if no address is configured)
+func resolver() *net.Resolver {
+	if len(dnsResolver) == 0 {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{Timeout: validationTimeout}
+			return d.DialContext(ctx, network, dnsResolver)
+		},
+	}
+}
+
+////
+// Validators
+////
+
+// http-01 challenge (RFC 8555 section 8.3)
+func validateHTTP01(identifier acme.Identifier, token, keyAuth string) error {
+	u := "http://" + net.JoinHostPort(identifier.Value, strconv.Itoa(http01Port)) + "/.well-known/acme-challenge/" + token
+	client := &http.Client{Timeout: validationTimeout}
+	resp, err := client.Get(u)
+	if err != nil {
+		return acme.ProblemDetails{Type: acme.ConnectionErr, Detail: "Can not fetch " + u + ": " + err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return acme.ProblemDetails{Type: acme.IncorrectResponseErr, Detail: "Invalid response from " + u + ": " + resp.Status}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return acme.ProblemDetails{Type: acme.ConnectionErr, Detail: "Can not read " + u + ": " + err.Error()}
+	}
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return acme.ProblemDetails{Type: acme.IncorrectResponseErr, Detail: "Key authorization from " + u + " does not match"}
+	}
+	return nil
+}
+
+// dns-01 challenge (RFC 8555 section 8.4)
+func validateDNS01(identifier acme.Identifier, token, keyAuth string) error {
+	name := "_acme-challenge." + identifier.Value
+	ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+	defer cancel()
+	records, err := resolver().LookupTXT(ctx, name)
+	if err != nil {
+		return acme.ProblemDetails{Type: acme.DNSErr, Detail: "Can not lookup TXT records of " + name + ": " + err.Error()}
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	for _, r := range records {
+		if r == expected {
+			return nil
+		}
+	}
+	return acme.ProblemDetails{Type: acme.IncorrectResponseErr, Detail: "No TXT record of " + name + " matches the key authorization"}
+}
+
+// tls-alpn-01 challenge (RFC 8737)
+func validateTLSALPN01(identifier acme.Identifier, token, keyAuth string) error {
+	serverName := identifier.Value
+	if identifier.Type == "ip" {
+		// Reverse mapping name used as server name for IP identifiers (RFC 8738 section 6)
+		ip := net.ParseIP(identifier.Value)
+		if ip == nil {
+			return acme.ProblemDetails{Type: acme.MalformedErr, Detail: "Invalid IP address " + identifier.Value}
+		}
+		serverName = reverseName(ip)
+	}
+	address := net.JoinHostPort(identifier.Value, strconv.Itoa(tlsALPN01Port))
+	dialer := &net.Dialer{Timeout: validationTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+		ServerName:         serverName,
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return acme.ProblemDetails{Type: acme.TLSErr, Detail: "Can not connect to " + address + ": " + err.Error()}
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != "acme-tls/1" {
+		return acme.ProblemDetails{Type: acme.TLSErr, Detail: "Server " + address + " did not negotiate acme-tls/1"}
+	}
+	if len(state.PeerCertificates) == 0 {
+		return acme.ProblemDetails{Type: acme.TLSErr, Detail: "Server " + address + " presented no certificate"}
+	}
+	crt := state.PeerCertificates[0]
+	if !certificateNames(crt, identifier) {
+		return acme.ProblemDetails{Type: acme.IncorrectResponseErr, Detail: "Certificate of " + address + " is not for " + identifier.Value}
+	}
+
+	sum := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range crt.Extensions {
+		if !ext.Id.Equal(oidAcmeIdentifier) {
+			continue
+		}
+		var value []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &value); err != nil || len(rest) > 0 {
+			return acme.ProblemDetails{Type: acme.IncorrectResponseErr, Detail: "Invalid acmeIdentifier extension"}
+		}
+		if !ext.Critical {
+			return acme.ProblemDetails{Type: acme.IncorrectResponseErr, Detail: "acmeIdentifier extension is not critical"}
+		}
+		if !bytes.Equal(value, sum[:]) {
+			return acme.ProblemDetails{Type: acme.IncorrectResponseErr, Detail: "acmeIdentifier extension does not match the key authorization"}
+		}
+		return nil
+	}
+	return acme.ProblemDetails{Type: acme.IncorrectResponseErr, Detail: "Certificate of " + address + " has no acmeIdentifier extension"}
+}
+
+// Reverse mapping domain name of an IP address (in-addr.arpa or ip6.arpa)
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.Itoa(int(ip4[3])) + "." + strconv.Itoa(int(ip4[2])) + "." + strconv.Itoa(int(ip4[1])) + "." + strconv.Itoa(int(ip4[0])) + ".in-addr.arpa"
+	}
+	const hex = "0123456789abcdef"
+	name := []byte{}
+	for i := len(ip) - 1; i >= 0; i-- {
+		name = append(name, hex[ip[i]&0xf], '.', hex[ip[i]>>4], '.')
+	}
+	return string(name) + "ip6.arpa"
+}
+
+// Test if the certificate is only for the identifier
+func certificateNames(crt *x509.Certificate, identifier acme.Identifier) bool {
+	if identifier.Type == "ip" {
+		ip := net.ParseIP(identifier.Value)
+		return len(crt.DNSNames) == 0 && len(crt.IPAddresses) == 1 && crt.IPAddresses[0].Equal(ip)
+	}
+	return len(crt.IPAddresses) == 0 && len(crt.DNSNames) == 1 && strings.EqualFold(crt.DNSNames[0], identifier.Value)
+}
@@ -0,0 +1,259 @@
+package acmeca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"simpleca/internal/acme"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testToken   = "token"
+	testKeyAuth = "token.thumbprint"
+)
+
+var localhost = acme.Identifier{Type: "ip", Value: "127.0.0.1"}
+
+// Type of the problem document returned by a validator ("" if valid)
+func problemType(err error) string {
+	if err == nil {
+		return ""
+	}
+	if p, ok := err.(acme.ProblemDetails); ok {
+		return p.Type
+	}
+	return err.Error()
+}
+
+// Port of a listener address
+func port(t *testing.T, addr net.Addr) int {
+	_, p, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(p)
+	return n
+}
+
+func TestValidateHTTP01(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/acme-challenge/"+testToken {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testKeyAuth + "\n"))
+	}))
+	defer srv.Close()
+	defer func(p int) { http01Port = p }(http01Port)
+	http01Port = port(t, srv.Listener.Addr())
+
+	tests := []struct {
+		name    string
+		token   string
+		keyAuth string
+		problem string
+	}{
+		{"valid", testToken, testKeyAuth, ""},
+		{"wrong key authorization", testToken, "token.other", acme.IncorrectResponseErr},
+		{"unknown token", "other", "other.thumbprint", acme.IncorrectResponseErr},
+	}
+	for _, test := range tests {
+		if got := problemType(validateHTTP01(localhost, test.token, test.keyAuth)); got != test.problem {
+			t.Errorf("%s: got problem %q, expected %q", test.name, got, test.problem)
+		}
+	}
+
+	srv.Close()
+	if got := problemType(validateHTTP01(localhost, testToken, testKeyAuth)); got != acme.ConnectionErr {
+		t.Errorf("server down: got problem %q, expected %q", got, acme.ConnectionErr)
+	}
+}
+
+// Stub DNS server answering TXT queries over UDP from a table of records
+func dnsServer(t *testing.T, records map[string]string) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := dnsAnswer(buf[:n], records); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn
+}
+
+// Answer a DNS query (RFC 1035 section 4): the TXT record of the question name or NXDOMAIN
+func dnsAnswer(query []byte, records map[string]string) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// Question: labels, then type and class
+	labels := []string{}
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		l := int(query[i])
+		if i+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+l]))
+		i += 1 + l
+	}
+	end := i + 5
+	if end > len(query) {
+		return nil
+	}
+	question := query[12:end]
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	binary.BigEndian.PutUint16(resp[2:], 0x8180) // response, recursion desired and available
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	resp = append(resp, question...)
+	txt, found := records[strings.ToLower(strings.Join(labels, "."))]
+	if !found || qtype != 16 {
+		if !found {
+			resp[3] |= 3 // NXDOMAIN
+		}
+		return resp
+	}
+	binary.BigEndian.PutUint16(resp[6:], 1)
+	rdata := append([]byte{byte(len(txt))}, txt...)
+	answer := []byte{0xc0, 12, 0, 16, 0, 1, 0, 0, 0, 60, 0, 0}
+	binary.BigEndian.PutUint16(answer[10:], uint16(len(rdata)))
+	return append(append(resp, answer...), rdata...)
+}
+
+func TestValidateDNS01(t *testing.T) {
+	sum := sha256.Sum256([]byte(testKeyAuth))
+	conn := dnsServer(t, map[string]string{
+		"_acme-challenge.example.com": base64.RawURLEncoding.EncodeToString(sum[:]),
+		"_acme-challenge.other.com":   "invalid",
+	})
+	defer conn.Close()
+	defer func(r string) { dnsResolver = r }(dnsResolver)
+	dnsResolver = conn.LocalAddr().String()
+
+	tests := []struct {
+		domain  string
+		problem string
+	}{
+		{"example.com", ""},
+		{"other.com", acme.IncorrectResponseErr},
+		{"unknown.com", acme.DNSErr},
+	}
+	for _, test := range tests {
+		identifier := acme.Identifier{Type: "dns", Value: test.domain}
+		if got := problemType(validateDNS01(identifier, testToken, testKeyAuth)); got != test.problem {
+			t.Errorf("%s: got problem %q, expected %q", test.domain, got, test.problem)
+		}
+	}
+}
+
+// tls-alpn-01 challenge certificate for an IP address, with an acmeIdentifier extension of a key authorization
+func alpnCertificate(t *testing.T, ip net.IP, keyAuth string, critical bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(keyAuth))
+	value, _ := asn1.Marshal(sum[:])
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "acme-tls"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		IPAddresses:     []net.IP{ip},
+		ExtraExtensions: []pkix.Extension{{Id: oidAcmeIdentifier, Critical: critical, Value: value}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TLS server negotiating the given protocols, handshakes are completed in the background
+func alpnServer(t *testing.T, crt tls.Certificate, protos []string) net.Listener {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{crt}, NextProtos: protos})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestValidateTLSALPN01(t *testing.T) {
+	defer func(p int) { tlsALPN01Port = p }(tlsALPN01Port)
+	ip := net.ParseIP(localhost.Value)
+
+	tests := []struct {
+		name    string
+		keyAuth string
+		protos  []string
+		problem string
+	}{
+		{"valid", testKeyAuth, []string{"acme-tls/1"}, ""},
+		{"wrong key authorization", "token.other", []string{"acme-tls/1"}, acme.IncorrectResponseErr},
+		{"no acme-tls/1", testKeyAuth, []string{"h2"}, acme.TLSErr},
+	}
+	for _, test := range tests {
+		l := alpnServer(t, alpnCertificate(t, ip, test.keyAuth, true), test.protos)
+		tlsALPN01Port = port(t, l.Addr())
+		if got := problemType(validateTLSALPN01(localhost, testToken, testKeyAuth)); got != test.problem {
+			t.Errorf("%s: got problem %q, expected %q", test.name, got, test.problem)
+		}
+		l.Close()
+	}
+
+	l := alpnServer(t, alpnCertificate(t, ip, testKeyAuth, false), []string{"acme-tls/1"})
+	defer l.Close()
+	tlsALPN01Port = port(t, l.Addr())
+	if got := problemType(validateTLSALPN01(localhost, testToken, testKeyAuth)); got != acme.IncorrectResponseErr {
+		t.Errorf("non critical extension: got problem %q, expected %q", got, acme.IncorrectResponseErr)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":   "1.2.0.192.in-addr.arpa",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+	}
+	for ip, expected := range tests {
+		if got := reverseName(net.ParseIP(ip)); got != expected {
+			t.Errorf("reverseName(%s) = %s, expected %s", ip, got, expected)
+		}
+	}
+}
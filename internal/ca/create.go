@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"simpleca/internal/cert"
 	"simpleca/internal/key"
 	"simpleca/tools"
 	"time"
@@ -29,7 +30,9 @@ Options:`)
 func Create(args []string) {
 
 	privKey := f.StringP("key", "k", "-", "Private key file")
-	size := f.IntP("size", "s", 2048, "Private key size")
+	keyType := f.String("type", "rsa", "Private key type (rsa, ecdsa or ed25519)")
+	size := f.IntP("size", "s", 2048, "Private key size (rsa)")
+	curveName := f.String("curve", "P384", "Elliptic curve (P256, P384 or P521, ecdsa)")
 	passphrase := f.String("passphrase", "", "Private key passphrase")
 	days := f.Int("days", 3650, "Not valid after days")
 	Country := f.String("C", "FR", "Country name")
@@ -39,6 +42,12 @@ func Create(args []string) {
 	OrganizationalUnit := f.String("OU", "MyUnit", "Unit")
 	CommonName := f.String("CN", "MyCA", "Common name")
 
+	parentKeyFile := f.String("parent-key", "", "Private key of the parent certificate authority (to create an intermediate certificate authority)")
+	parentPassphrase := f.String("parent-pass", "", "Private key passphrase of the parent certificate authority")
+	parentCertFile := f.String("parent-cert", "", "Certificate of the parent certificate authority (to create an intermediate certificate authority)")
+	pathLen := f.Int("path-len", -1, "Maximum number of intermediate certificate authorities below an intermediate certificate authority (-1 for no constraint)")
+	pss := f.Bool("pss", false, "Sign with RSA-PSS instead of PKCS#1 v1.5 (rsa)")
+
 	out := f.StringP("out", "c", "-", "Output file (- for standard output)")
 
 	f.SetUsage(CreateUsage)
@@ -54,6 +63,10 @@ func Create(args []string) {
 			fmt.Fprintf(os.Stderr, "Certificate file must be set\n")
 			os.Exit(1)
 		}
+		if *pathLen != -1 && len(*parentKeyFile) == 0 && len(*parentCertFile) == 0 {
+			fmt.Fprintln(os.Stderr, "Path length constraint is only set on intermediate certificate authorities (-parent-key and -parent-cert)")
+			os.Exit(1)
+		}
 
 		C := *Country
 		ST := *State
@@ -64,6 +77,45 @@ func Create(args []string) {
 		SA := ""
 		PC := ""
 
+		generate := func(privateKey any) error {
+			return GenerateCACertFile(CN, C, ST, L, O, OU, SA, PC, privateKey, *days, *pss, *out)
+		}
+		if len(*parentKeyFile) > 0 || len(*parentCertFile) > 0 {
+			if b, _ := tools.Exists(*parentKeyFile); !b {
+				fmt.Fprintln(os.Stderr, "Parent certificate authority private key does not exist")
+				os.Exit(1)
+			}
+			if b, _ := tools.Exists(*parentCertFile); !b {
+				fmt.Fprintln(os.Stderr, "Parent certificate authority certificate does not exist")
+				os.Exit(1)
+			}
+			parentKey, err := key.LoadPrivateKeyFile(*parentKeyFile, *parentPassphrase)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			parentCert, parentChain, err := LoadCAChainFile(*parentCertFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			db, err := LoadDatabase(DatabaseFilename(*parentCertFile))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			generate = func(privateKey any) error {
+				crt, err := GenerateSubCACert(CN, C, ST, L, O, OU, SA, PC, privateKey, *days, *pathLen, *pss, parentCert, parentKey)
+				if err != nil {
+					return err
+				}
+				if err = db.Add(crt, currentUser()); err != nil {
+					return err
+				}
+				return cert.WriteCertsFile(append([]*x509.Certificate{crt}, parentChain...), *out)
+			}
+		}
+
 		if b, _ := tools.Exists(*privKey); b {
 			fmt.Fprintln(os.Stderr, "Loading CA private key")
 			privateKey, err := key.LoadPrivateKeyFile(*privKey, *passphrase)
@@ -72,25 +124,30 @@ func Create(args []string) {
 				os.Exit(1)
 			}
 			fmt.Fprintln(os.Stderr, "Generating CA certificate")
-			err = GenerateCACertFile(CN, C, ST, L, O, OU, SA, PC, privateKey, *days, *out)
+			err = generate(privateKey)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to generate CA certificate: "+err.Error())
 				os.Exit(1)
 			}
 		} else {
 			fmt.Fprintln(os.Stderr, "Generating CA private key")
-			privateKey, err := key.GenerateRSAKey(*size)
+			curve, err := key.ParseCurve(*curveName)
 			if err != nil {
 				fmt.Fprint(os.Stderr, err.Error())
 				os.Exit(1)
 			}
-			err = key.WriteRSAKeyFile(privateKey, *passphrase, *privKey)
+			privateKey, err := key.GenerateKey(*keyType, *size, curve)
+			if err != nil {
+				fmt.Fprint(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			err = key.WriteKeyFile(privateKey, *passphrase, *privKey)
 			if err != nil {
 				fmt.Fprint(os.Stderr, err.Error())
 				os.Exit(1)
 			}
 			fmt.Fprintln(os.Stderr, "Generating CA certificate")
-			err = GenerateCACertFile(CN, C, ST, L, O, OU, SA, PC, privateKey, *days, *out)
+			err = generate(privateKey)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Unable to generate CA certificate: "+err.Error())
 				os.Exit(1)
@@ -99,8 +156,8 @@ func Create(args []string) {
 	}
 }
 
-func GenerateCACert(CN, C, ST, L, O, OU, SA, PC string, key any, days int) (*x509.Certificate, error) {
-	if certBytes, err := GenerateCACertBytes(CN, C, ST, L, O, OU, SA, PC, key, days); err != nil {
+func GenerateCACert(CN, C, ST, L, O, OU, SA, PC string, key any, days int, pss bool) (*x509.Certificate, error) {
+	if certBytes, err := GenerateCACertBytes(CN, C, ST, L, O, OU, SA, PC, key, days, pss); err != nil {
 		return nil, err
 	} else {
 		return x509.ParseCertificate(certBytes)
@@ -111,7 +168,16 @@ func ConvertCACertBytes(caBytes []byte) (*x509.Certificate, error) {
 	return x509.ParseCertificate(caBytes)
 }
 
-func GenerateCACertBytes(CN, C, ST, L, O, OU, SA, PC string, key any, days int) ([]byte, error) {
+func GenerateCACertBytes(CN, C, ST, L, O, OU, SA, PC string, key any, days int, pss bool) ([]byte, error) {
+	ca := GenerateCACertTemplate(CN, C, ST, L, O, OU, SA, PC, days)
+	publicKey := key.(crypto.Signer).Public()
+	ca.PublicKey = publicKey
+	ca.SignatureAlgorithm = SignatureAlgorithm(publicKey, pss)
+
+	return x509.CreateCertificate(rand.Reader, ca, ca, publicKey, key)
+}
+
+func GenerateCACertTemplate(CN, C, ST, L, O, OU, SA, PC string, days int) *x509.Certificate {
 	ca := &x509.Certificate{
 		SerialNumber: big.NewInt(2019),
 		Subject: pkix.Name{
@@ -124,10 +190,9 @@ func GenerateCACertBytes(CN, C, ST, L, O, OU, SA, PC string, key any, days int)
 			PostalCode:         []string{},
 			CommonName:         CN,
 		},
-		SignatureAlgorithm: x509.SHA256WithRSA,
-		NotBefore:          time.Now(),
-		NotAfter:           time.Now().AddDate(0, 0, days),
-		KeyUsage:           x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment | x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment | x509.KeyUsageKeyAgreement | x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageEncipherOnly | x509.KeyUsageDecipherOnly,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().AddDate(0, 0, days),
+		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment | x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment | x509.KeyUsageKeyAgreement | x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageEncipherOnly | x509.KeyUsageDecipherOnly,
 		ExtKeyUsage: []x509.ExtKeyUsage{
 			x509.ExtKeyUsageAny,
 			x509.ExtKeyUsageServerAuth,
@@ -168,10 +233,7 @@ func GenerateCACertBytes(CN, C, ST, L, O, OU, SA, PC string, key any, days int)
 	if len(PC) > 0 {
 		ca.Subject.PostalCode = []string{PC}
 	}
-	publicKey := key.(crypto.Signer).Public()
-	ca.PublicKey = publicKey
-
-	return x509.CreateCertificate(rand.Reader, ca, ca, publicKey, key)
+	return ca
 }
 
 func ConvertCACertBytesToBlock(caBytes []byte) *pem.Block {
@@ -181,16 +243,16 @@ func ConvertCACertBytesToBlock(caBytes []byte) *pem.Block {
 	}
 }
 
-func GenerateCACertBlock(CN, C, ST, L, O, OU, SA, PC string, key any, days int) (*pem.Block, error) {
-	if certBytes, err := GenerateCACertBytes(CN, C, ST, L, O, OU, SA, PC, key, days); err != nil {
+func GenerateCACertBlock(CN, C, ST, L, O, OU, SA, PC string, key any, days int, pss bool) (*pem.Block, error) {
+	if certBytes, err := GenerateCACertBytes(CN, C, ST, L, O, OU, SA, PC, key, days, pss); err != nil {
 		return nil, err
 	} else {
 		return ConvertCACertBytesToBlock(certBytes), nil
 	}
 }
 
-func GenerateCACertStream(CN, C, ST, L, O, OU, SA, PC string, key any, days int, file *os.File) error {
-	if certBlock, err := GenerateCACertBlock(CN, C, ST, L, O, OU, SA, PC, key, days); err != nil {
+func GenerateCACertStream(CN, C, ST, L, O, OU, SA, PC string, key any, days int, pss bool, file *os.File) error {
+	if certBlock, err := GenerateCACertBlock(CN, C, ST, L, O, OU, SA, PC, key, days, pss); err != nil {
 		return err
 	} else {
 		err = pem.Encode(file, certBlock)
@@ -201,7 +263,7 @@ func GenerateCACertStream(CN, C, ST, L, O, OU, SA, PC string, key any, days int,
 	}
 }
 
-func GenerateCACertFile(CN, C, ST, L, O, OU, SA, PC string, key any, days int, filename string) error {
+func GenerateCACertFile(CN, C, ST, L, O, OU, SA, PC string, key any, days int, pss bool, filename string) error {
 	var certPem *os.File
 	var err error
 	if filename == "-" {
@@ -213,7 +275,7 @@ func GenerateCACertFile(CN, C, ST, L, O, OU, SA, PC string, key any, days int, f
 		}
 		defer certPem.Close()
 	}
-	return GenerateCACertStream(CN, C, ST, L, O, OU, SA, PC, key, days, certPem)
+	return GenerateCACertStream(CN, C, ST, L, O, OU, SA, PC, key, days, pss, certPem)
 }
 
 func GenerateCAPrivateKeyFile(keyfile, passphrase string, size int) error {
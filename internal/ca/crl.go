@@ -0,0 +1,196 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"simpleca/internal/cert"
+	"simpleca/internal/key"
+	"simpleca/tools"
+	"sync"
+	"time"
+)
+
+// CRLCache keeps the last generated CRL of a server: it is regenerated when a certificate
+// is revoked or when half of its validity has elapsed, not on every request
+type CRLCache struct {
+	mtx        sync.Mutex
+	crlBytes   []byte
+	revoked    int
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+func CRLUsage() {
+	fmt.Println(`
+Usage:  simpleca ca crl [OPTIONS]
+
+Generate the certificate revocation list of a certificate authority
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func CRL(args []string) {
+
+	caKeyFile := f.String("ca-key", "ca.key", "Private key of the certificates authority")
+	caPassphrase := f.String("ca-pass", "", "Private key passphrase of the certificates authority")
+	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificates authority")
+	dbFile := f.String("db", "", "Issuance database file (default index.json next to the CA certificate)")
+
+	days := f.Int("days", 7, "Number of days until the next CRL update")
+	out := f.StringP("out", "c", "-", "Output file (- for standard output)")
+
+	f.SetUsage(CRLUsage)
+	f.Parse(args[1:])
+	if f.NArg() != 0 {
+		CRLUsage()
+	} else {
+		if b, _ := tools.Exists(*caKeyFile); !b {
+			fmt.Fprintln(os.Stderr, "Certificate authority private key does not exist")
+			os.Exit(1)
+		}
+		if b, _ := tools.Exists(*caCertFile); !b {
+			fmt.Fprintln(os.Stderr, "Certificate authority certificate does not exist")
+			os.Exit(1)
+		}
+		caKey, err := key.LoadPrivateKeyFile(*caKeyFile, *caPassphrase)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		caCert, err := cert.LoadCertFile(*caCertFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if len(*dbFile) == 0 {
+			*dbFile = DatabaseFilename(*caCertFile)
+		}
+		db, err := LoadDatabase(*dbFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Fprintln(os.Stderr, "Generating certificate revocation list")
+		crlBytes, err := GenerateCRLBytes(db, caCert, caKey, *days)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to generate certificate revocation list: "+err.Error())
+			os.Exit(1)
+		}
+		if err = WriteCRLFile(crlBytes, *out); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+}
+
+// Generate a DER encoded CRL with all revoked certificates of the database
+func GenerateCRLBytes(db *Database, ca *x509.Certificate, caPrivKey any, days int) ([]byte, error) {
+	signer, ok := caPrivKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("Certificate authority private key can not sign")
+	}
+	entries, err := revokedEntries(db, ca)
+	if err != nil {
+		return nil, err
+	}
+	number, err := db.NextCRLNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.RevocationList{
+		SignatureAlgorithm:        signerAlgorithm(ca, signer),
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.AddDate(0, 0, days),
+		RevokedCertificateEntries: entries,
+	}
+	return x509.CreateRevocationList(rand.Reader, tmpl, ca, signer)
+}
+
+// Return the cached CRL, or generate a new one if a certificate was revoked since
+// or if its next update is near
+func (c *CRLCache) Get(db *Database, ca *x509.Certificate, caPrivKey any, days int) ([]byte, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entries, err := revokedEntries(db, ca)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if c.crlBytes != nil && len(entries) == c.revoked && now.Before(c.thisUpdate.Add(c.nextUpdate.Sub(c.thisUpdate)/2)) {
+		return c.crlBytes, nil
+	}
+	crlBytes, err := GenerateCRLBytes(db, ca, caPrivKey, days)
+	if err != nil {
+		return nil, err
+	}
+	c.crlBytes = crlBytes
+	c.revoked = len(entries)
+	c.thisUpdate = now
+	c.nextUpdate = now.AddDate(0, 0, days)
+	return crlBytes, nil
+}
+
+// Revoked certificates of the database issued by a certificate authority
+func revokedEntries(db *Database, ca *x509.Certificate) ([]x509.RevocationListEntry, error) {
+	records, err := db.List()
+	if err != nil {
+		return nil, err
+	}
+	entries := []x509.RevocationListEntry{}
+	issuerKeyID := hex.EncodeToString(ca.SubjectKeyId)
+	for _, r := range records {
+		if r.Status != StatusRevoked || r.IssuerKeyID != issuerKeyID {
+			continue
+		}
+		serial, err := ParseSerial(r.Serial, false)
+		if err != nil {
+			return nil, err
+		}
+		entry := x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: *r.RevokedAt,
+			ReasonCode:     r.Reason,
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func ConvertCRLBytesToBlock(crlBytes []byte) *pem.Block {
+	return &pem.Block{Type: "X509 CRL", Bytes: crlBytes}
+}
+
+func WriteCRLStream(crlBytes []byte, file *os.File) error {
+	err := pem.Encode(file, ConvertCRLBytesToBlock(crlBytes))
+	if err != nil {
+		return errors.New("Error when encode certificate revocation list to pem: " + err.Error())
+	}
+	return nil
+}
+
+func WriteCRLFile(crlBytes []byte, filename string) error {
+	var file *os.File
+	var err error
+	if filename == "-" {
+		file = os.Stdout
+	} else {
+		file, err = os.Create(filename)
+		if err != nil {
+			return errors.New("Error when creating file")
+		}
+		defer file.Close()
+	}
+	return WriteCRLStream(crlBytes, file)
+}
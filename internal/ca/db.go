@@ -0,0 +1,285 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate statuses stored in the issuance database
+const (
+	StatusValid   = "valid"
+	StatusRevoked = "revoked"
+	StatusExpired = "expired"
+)
+
+// Default issuance database file name, stored next to the CA certificate
+const DatabaseName = "index.json"
+
+// Record is an issued certificate entry of the issuance database
+type Record struct {
+	Serial         string     `json:"serial"`
+	Subject        string     `json:"subject"`
+	CommonName     string     `json:"commonName,omitempty"`
+	DNSNames       []string   `json:"dnsNames,omitempty"`
+	IPAddresses    []string   `json:"ipAddresses,omitempty"`
+	EmailAddresses []string   `json:"emailAddresses,omitempty"`
+	URIs           []string   `json:"uris,omitempty"`
+	NotBefore      time.Time  `json:"notBefore"`
+	NotAfter       time.Time  `json:"notAfter"`
+	IssuerKeyID    string     `json:"issuerKeyId,omitempty"`
+	Requester      string     `json:"requester,omitempty"`
+	Status         string     `json:"status"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	Reason         int        `json:"reason,omitempty"`
+	Certificate    string     `json:"certificate"`
+
+	// Early renewal requested by an operator
+	Renewal *RenewalWindow `json:"renewal,omitempty"`
+}
+
+// Database is a file-backed store of every certificate issued by the CA
+// Certificate authorities sharing the same directory share the same database,
+// records are told apart with the issuer key identifier.
+type Database struct {
+	filename string
+	modTime  time.Time
+	mtx      sync.Mutex
+
+	CRLNumber int64     `json:"crlNumber"`
+	Records   []*Record `json:"records"`
+}
+
+// Compute the default issuance database file from the CA certificate file
+func DatabaseFilename(caCertFile string) string {
+	return filepath.Join(filepath.Dir(caCertFile), DatabaseName)
+}
+
+// Load the issuance database (an empty one is returned if the file does not exist yet)
+func LoadDatabase(filename string) (*Database, error) {
+	db := &Database{filename: filename, Records: []*Record{}}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *Database) load() error {
+	fi, err := os.Stat(db.filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("Can not stat database file " + db.filename)
+	}
+	content, err := os.ReadFile(db.filename)
+	if err != nil {
+		return errors.New("Can not read database file " + db.filename)
+	}
+	records := struct {
+		CRLNumber int64     `json:"crlNumber"`
+		Records   []*Record `json:"records"`
+	}{}
+	if err := json.Unmarshal(content, &records); err != nil {
+		return errors.New("Can not parse database file " + db.filename + ": " + err.Error())
+	}
+	db.CRLNumber = records.CRLNumber
+	db.Records = records.Records
+	db.modTime = fi.ModTime()
+	return nil
+}
+
+// Reload the database if the file has been modified by another process
+func (db *Database) refresh() error {
+	if fi, err := os.Stat(db.filename); err == nil && !fi.ModTime().Equal(db.modTime) {
+		return db.load()
+	}
+	return nil
+}
+
+func (db *Database) save() error {
+	content, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return errors.New("Can not encode database: " + err.Error())
+	}
+	tmp := db.filename + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return errors.New("Can not write database file " + tmp)
+	}
+	if err := os.Rename(tmp, db.filename); err != nil {
+		return errors.New("Can not replace database file " + db.filename)
+	}
+	if fi, err := os.Stat(db.filename); err == nil {
+		db.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// Update the database: changes made by fn are written back to the file
+// The database is reloaded and saved under a lock of the database file (index.json.lock)
+// so that other processes can not update it in between.
+func (db *Database) Update(fn func() error) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	unlock, err := lockFile(db.filename + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := db.load(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return db.save()
+}
+
+// Record a newly issued certificate
+func (db *Database) Add(crt *x509.Certificate, requester string) error {
+	return db.Update(func() error {
+		db.Records = append(db.Records, NewRecord(crt, requester))
+		return nil
+	})
+}
+
+// Revoke a certificate
+func (db *Database) Revoke(serial *big.Int, reason int, at time.Time) error {
+	return db.Update(func() error {
+		r := db.find(serial)
+		if r == nil {
+			return errors.New("Certificate " + FormatSerial(serial) + " not found in database")
+		}
+		if r.Status == StatusRevoked {
+			return errors.New("Certificate " + r.Serial + " already revoked")
+		}
+		r.Status = StatusRevoked
+		r.RevokedAt = &at
+		r.Reason = reason
+		return nil
+	})
+}
+
+// Increment and return the CRL number
+func (db *Database) NextCRLNumber() (*big.Int, error) {
+	var n int64
+	err := db.Update(func() error {
+		db.CRLNumber++
+		n = db.CRLNumber
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(n), nil
+}
+
+// Return a snapshot of all records
+func (db *Database) List() ([]*Record, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if err := db.refresh(); err != nil {
+		return nil, err
+	}
+	return append([]*Record{}, db.Records...), nil
+}
+
+// Find a record by serial number
+func (db *Database) Find(serial *big.Int) (*Record, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if err := db.refresh(); err != nil {
+		return nil, err
+	}
+	return db.find(serial), nil
+}
+
+func (db *Database) find(serial *big.Int) *Record {
+	s := FormatSerial(serial)
+	for _, r := range db.Records {
+		if r.Serial == s {
+			return r
+		}
+	}
+	return nil
+}
+
+// Build a database record from a certificate
+func NewRecord(crt *x509.Certificate, requester string) *Record {
+	r := &Record{
+		Serial:         FormatSerial(crt.SerialNumber),
+		Subject:        crt.Subject.String(),
+		CommonName:     crt.Subject.CommonName,
+		DNSNames:       crt.DNSNames,
+		EmailAddresses: crt.EmailAddresses,
+		NotBefore:      crt.NotBefore,
+		NotAfter:       crt.NotAfter,
+		IssuerKeyID:    hex.EncodeToString(crt.AuthorityKeyId),
+		Requester:      requester,
+		Status:         StatusValid,
+		Certificate:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})),
+	}
+	for _, ip := range crt.IPAddresses {
+		r.IPAddresses = append(r.IPAddresses, ip.String())
+	}
+	for _, u := range crt.URIs {
+		r.URIs = append(r.URIs, u.String())
+	}
+	return r
+}
+
+// Return the status of the record at a given time (valid, revoked or expired)
+func (r *Record) State(at time.Time) string {
+	if r.Status == StatusValid && at.After(r.NotAfter) {
+		return StatusExpired
+	}
+	return r.Status
+}
+
+// Return all subject alternative names of the record
+func (r *Record) SANs() []string {
+	sans := append([]string{}, r.DNSNames...)
+	sans = append(sans, r.IPAddresses...)
+	sans = append(sans, r.EmailAddresses...)
+	return append(sans, r.URIs...)
+}
+
+// Decode the certificate stored in the record
+func (r *Record) Cert() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(r.Certificate))
+	if block == nil {
+		return nil, errors.New("Unable to decode certificate of serial " + r.Serial)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Format a serial number as stored in the database (hexadecimal)
+func FormatSerial(serial *big.Int) string {
+	return fmt.Sprintf("%x", serial)
+}
+
+// Parse a serial number: hexadecimal as printed by FormatSerial (optionally 0x prefixed
+// or colon separated), or decimal when asked explicitly
+func ParseSerial(s string, decimal bool) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	base := 16
+	if decimal {
+		base = 10
+	} else if strings.HasPrefix(strings.ToLower(s), "0x") {
+		s = s[2:]
+	} else {
+		s = strings.ReplaceAll(s, ":", "")
+	}
+	if n, ok := new(big.Int).SetString(s, base); ok {
+		return n, nil
+	}
+	return nil, errors.New("Invalid serial number " + s)
+}
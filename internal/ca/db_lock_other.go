@@ -0,0 +1,8 @@
+//go:build !(darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || windows)
+
+package ca
+
+// File locks are not supported: a single process must write the database
+func lockFile(filename string) (func(), error) {
+	return func() {}, nil
+}
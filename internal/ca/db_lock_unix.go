@@ -0,0 +1,25 @@
+//go:build darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd
+
+package ca
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Take an exclusive advisory lock (flock) on a lock file, released by the returned function
+func lockFile(filename string) (func(), error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.New("Can not open lock file " + filename)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, errors.New("Can not lock file " + filename)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package ca
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// Take an exclusive lock on a lock file, released by the returned function
+// The file is opened without sharing: other processes wait until it is closed.
+func lockFile(filename string) (func(), error) {
+	name, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return nil, errors.New("Invalid lock file name " + filename)
+	}
+	for {
+		h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+		if err == nil {
+			return func() { syscall.CloseHandle(h) }, nil
+		}
+		if err != errorSharingViolation {
+			return nil, errors.New("Can not lock file " + filename)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
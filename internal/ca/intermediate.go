@@ -0,0 +1,85 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"simpleca/internal/cert"
+)
+
+// Generate an intermediate (subordinate) CA certificate signed by a parent CA
+// A negative path length means no path length constraint. The certificate is signed with
+// RSA-PSS if pss is set or if the parent CA signs with RSA-PSS.
+func GenerateSubCACert(CN, C, ST, L, O, OU, SA, PC string, key any, days, pathLen int, pss bool, parent *x509.Certificate, parentKey any) (*x509.Certificate, error) {
+	if !parent.IsCA {
+		return nil, errors.New("Parent certificate is not a certificate authority")
+	}
+	if parent.MaxPathLen == 0 && parent.MaxPathLenZero {
+		return nil, errors.New("Parent certificate authority is not allowed to issue intermediate certificate authorities")
+	}
+	if parent.MaxPathLen > 0 {
+		if pathLen >= parent.MaxPathLen {
+			return nil, errors.New("Path length must be lower than parent certificate authority path length")
+		} else if pathLen < 0 {
+			pathLen = parent.MaxPathLen - 1
+		}
+	}
+
+	tmpl := GenerateCACertTemplate(CN, C, ST, L, O, OU, SA, PC, days)
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.SerialNumber = serialNumber
+	if pathLen >= 0 {
+		tmpl.MaxPathLen = pathLen
+		tmpl.MaxPathLenZero = pathLen == 0
+	} else {
+		tmpl.MaxPathLen = -1
+	}
+	if tmpl.NotAfter.After(parent.NotAfter) {
+		tmpl.NotAfter = parent.NotAfter
+	}
+
+	publicKey := key.(crypto.Signer).Public()
+	tmpl.PublicKey = publicKey
+	tmpl.SignatureAlgorithm = signerAlgorithm(parent, parentKey)
+	if signer, ok := parentKey.(crypto.Signer); ok && pss {
+		tmpl.SignatureAlgorithm = SignatureAlgorithm(signer.Public(), true)
+	}
+	crtBytes, err := x509.CreateCertificate(rand.Reader, tmpl, parent, publicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(crtBytes)
+}
+
+// Test if a certificate is a self-signed (root) certificate
+func IsSelfSigned(crt *x509.Certificate) bool {
+	return bytes.Equal(crt.RawIssuer, crt.RawSubject) && crt.CheckSignatureFrom(crt) == nil
+}
+
+// Return the certificates to send along with issued certificates (intermediate CAs, no root)
+func IssuingChain(certs []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{}
+	for _, c := range certs {
+		if !IsSelfSigned(c) {
+			chain = append(chain, c)
+		}
+	}
+	return chain
+}
+
+// Load a CA certificate file: the issuing CA certificate first, followed by its parents
+// Return the issuing CA certificate and the intermediate chain to send along with issued certificates.
+func LoadCAChainFile(filename string) (*x509.Certificate, []*x509.Certificate, error) {
+	certs, err := cert.LoadCertsFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certs[0], IssuingChain(certs), nil
+}
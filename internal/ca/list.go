@@ -0,0 +1,86 @@
+package ca
+
+import (
+	"fmt"
+	"os"
+	"simpleca/tools"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func ListUsage() {
+	fmt.Println(`
+Usage:  simpleca ca list [OPTIONS]
+
+List certificates issued by a certificate authority
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func List(args []string) {
+
+	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificates authority")
+	dbFile := f.String("db", "", "Issuance database file (default index.json next to the CA certificate)")
+
+	expired := f.Bool("expired", false, "Only list expired certificates")
+	expiring := f.Int("expiring", 0, "Only list valid certificates expiring within this number of days")
+	status := f.String("status", "", "Only list certificates with this status (valid, revoked, or expired)")
+	cn := f.String("cn", "", "Only list certificates whose common name matches this pattern (*, ? allowed)")
+	san := f.String("san", "", "Only list certificates with an alternate name matching this pattern (*, ? allowed)")
+
+	f.SetUsage(ListUsage)
+	f.Parse(args[1:])
+	if f.NArg() != 0 {
+		ListUsage()
+	} else {
+		if len(*dbFile) == 0 {
+			*dbFile = DatabaseFilename(*caCertFile)
+		}
+		db, err := LoadDatabase(*dbFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		records, err := db.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERIAL\tSTATUS\tNOT AFTER\tCOMMON NAME\tALTERNATE NAMES\tREQUESTER")
+		for _, r := range records {
+			state := r.State(now)
+			if *expired && state != StatusExpired {
+				continue
+			}
+			if *expiring > 0 && (state != StatusValid || r.NotAfter.After(now.AddDate(0, 0, *expiring))) {
+				continue
+			}
+			if len(*status) > 0 && state != *status {
+				continue
+			}
+			if len(*cn) > 0 && !tools.IsMatch(r.CommonName, *cn) {
+				continue
+			}
+			if len(*san) > 0 && !matchAny(r.SANs(), *san) {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Serial, state, r.NotAfter.Format(time.RFC3339), r.CommonName, strings.Join(r.SANs(), ","), r.Requester)
+		}
+		w.Flush()
+	}
+}
+
+func matchAny(values []string, pattern string) bool {
+	for _, v := range values {
+		if tools.IsMatch(v, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -18,6 +18,10 @@ Manage certificate authority
 
 Commands:
   create           Create or renew a certficate authority
+  crl              Generate the certificate revocation list
+  list             List certificates issued by a certificate authority
+  renewal          Ask ACME clients to renew certificates early
+  revoke           Revoke a certificate
   sign             Sign a certificate with a certificate authority previously created
 
 `)
@@ -31,6 +35,14 @@ func Main(args []string) {
 		switch cmd := argsWithoutProg[0]; cmd {
 		case "create":
 			Create(argsWithoutProg)
+		case "crl":
+			CRL(argsWithoutProg)
+		case "list":
+			List(argsWithoutProg)
+		case "renewal":
+			Renewal(argsWithoutProg)
+		case "revoke":
+			Revoke(argsWithoutProg)
 		case "sign":
 			Sign(argsWithoutProg)
 		default:
@@ -0,0 +1,274 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the profile used when none is given
+const DefaultProfileName = "default"
+
+// Extension is an extra X509v3 extension added to issued certificates
+type Extension struct {
+	OID      string `yaml:"oid" json:"oid"`
+	Critical bool   `yaml:"critical" json:"critical"`
+	Value    string `yaml:"value" json:"value"` // base64 encoded DER value
+}
+
+// Profile is a named set of issuance parameters
+type Profile struct {
+	Name        string      `yaml:"-" json:"-"`
+	KeyUsage    []string    `yaml:"keyUsage" json:"keyUsage"`
+	ExtKeyUsage []string    `yaml:"extKeyUsage" json:"extKeyUsage"`
+	MaxDays     int         `yaml:"maxDays" json:"maxDays"`
+	KeyTypes    []string    `yaml:"keyTypes" json:"keyTypes"`
+	IsCA        bool        `yaml:"isCA" json:"isCA"`
+	PathLen     int         `yaml:"pathLen" json:"pathLen"`
+	Extensions  []Extension `yaml:"extensions" json:"extensions"`
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"nonRepudiation":    x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"keyCertSign":       x509.KeyUsageCertSign,
+	"cRLSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"ipsecEndSystem":  x509.ExtKeyUsageIPSECEndSystem,
+	"ipsecTunnel":     x509.ExtKeyUsageIPSECTunnel,
+	"ipsecUser":       x509.ExtKeyUsageIPSECUser,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// Return the built-in issuance profiles
+func DefaultProfiles() map[string]*Profile {
+	profiles := map[string]*Profile{
+		DefaultProfileName: {
+			KeyUsage:    []string{"digitalSignature", "keyEncipherment"},
+			ExtKeyUsage: []string{"serverAuth", "clientAuth"},
+		},
+		"server": {
+			KeyUsage:    []string{"digitalSignature", "keyEncipherment"},
+			ExtKeyUsage: []string{"serverAuth"},
+		},
+		"client": {
+			KeyUsage:    []string{"digitalSignature", "keyEncipherment"},
+			ExtKeyUsage: []string{"clientAuth"},
+		},
+		"code-signing": {
+			KeyUsage:    []string{"digitalSignature"},
+			ExtKeyUsage: []string{"codeSigning"},
+		},
+		"email": {
+			KeyUsage:    []string{"digitalSignature", "keyEncipherment", "contentCommitment"},
+			ExtKeyUsage: []string{"emailProtection"},
+		},
+		"ocsp-signing": {
+			KeyUsage:    []string{"digitalSignature"},
+			ExtKeyUsage: []string{"OCSPSigning"},
+			Extensions: []Extension{
+				// id-pkix-ocsp-nocheck (RFC 6960 section 4.2.2.2.1)
+				{OID: "1.3.6.1.5.5.7.48.1.5", Value: "BQA="},
+			},
+		},
+		"sub-ca": {
+			KeyUsage: []string{"digitalSignature", "keyCertSign", "cRLSign"},
+			IsCA:     true,
+		},
+	}
+	for name, p := range profiles {
+		p.Name = name
+	}
+	return profiles
+}
+
+// Load issuance profiles from a YAML file, added to (or replacing) the built-in ones
+func LoadProfiles(filename string) (map[string]*Profile, error) {
+	profiles := DefaultProfiles()
+	if len(filename) == 0 {
+		return profiles, nil
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.New("Can not read profiles file " + filename)
+	}
+	loaded := map[string]*Profile{}
+	if err = yaml.Unmarshal(content, &loaded); err != nil {
+		return nil, errors.New("Can not parse profiles file " + filename + ": " + err.Error())
+	}
+	for name, p := range loaded {
+		p.Name = name
+		if err := p.Check(); err != nil {
+			return nil, err
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// Return the names of a set of profiles
+func ProfileNames(profiles map[string]*Profile) []string {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check the profile is valid
+func (p *Profile) Check() error {
+	if _, err := p.keyUsage(); err != nil {
+		return err
+	}
+	if _, err := p.extKeyUsage(); err != nil {
+		return err
+	}
+	if _, err := p.extensions(); err != nil {
+		return err
+	}
+	for _, t := range p.KeyTypes {
+		if t != "rsa" && t != "ecdsa" && t != "ed25519" {
+			return errors.New("Profile " + p.Name + ": unknown key type " + t)
+		}
+	}
+	return nil
+}
+
+// Check whether the profile issues certificate authorities (CA basic constraint or certificate signing usage)
+func (p *Profile) IssuesCA() bool {
+	if p.IsCA {
+		return true
+	}
+	for _, u := range p.KeyUsage {
+		if u == "keyCertSign" {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the public key type is allowed by the profile
+func (p *Profile) AllowsKey(publicKey any) bool {
+	if len(p.KeyTypes) == 0 {
+		return true
+	}
+	for _, t := range p.KeyTypes {
+		if t == KeyTypeName(publicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cap a number of days to the profile maximum validity
+func (p *Profile) Days(days int) int {
+	if p.MaxDays > 0 && days > p.MaxDays {
+		return p.MaxDays
+	}
+	return days
+}
+
+// Apply the profile to a certificate template
+func (p *Profile) Apply(tmpl *x509.Certificate) error {
+	var err error
+	if tmpl.KeyUsage, err = p.keyUsage(); err != nil {
+		return err
+	}
+	if tmpl.ExtKeyUsage, err = p.extKeyUsage(); err != nil {
+		return err
+	}
+	if tmpl.ExtraExtensions, err = p.extensions(); err != nil {
+		return err
+	}
+	if p.IsCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.MaxPathLen = p.PathLen
+		tmpl.MaxPathLenZero = p.PathLen == 0
+	}
+	return nil
+}
+
+func (p *Profile) keyUsage() (x509.KeyUsage, error) {
+	var usage x509.KeyUsage
+	for _, u := range p.KeyUsage {
+		if v, found := keyUsages[u]; found {
+			usage |= v
+		} else {
+			return 0, errors.New("Profile " + p.Name + ": unknown key usage " + u)
+		}
+	}
+	return usage, nil
+}
+
+func (p *Profile) extKeyUsage() ([]x509.ExtKeyUsage, error) {
+	usages := []x509.ExtKeyUsage{}
+	for _, u := range p.ExtKeyUsage {
+		if v, found := extKeyUsages[u]; found {
+			usages = append(usages, v)
+		} else {
+			return nil, errors.New("Profile " + p.Name + ": unknown extended key usage " + u)
+		}
+	}
+	return usages, nil
+}
+
+func (p *Profile) extensions() ([]pkix.Extension, error) {
+	extensions := []pkix.Extension{}
+	for _, e := range p.Extensions {
+		oid := asn1.ObjectIdentifier{}
+		for _, n := range strings.Split(e.OID, ".") {
+			if i, err := strconv.Atoi(n); err == nil && i >= 0 {
+				oid = append(oid, i)
+			} else {
+				return nil, errors.New("Profile " + p.Name + ": invalid extension OID " + e.OID)
+			}
+		}
+		value, err := base64.StdEncoding.DecodeString(e.Value)
+		if err != nil {
+			return nil, errors.New("Profile " + p.Name + ": invalid extension value for " + e.OID)
+		}
+		extensions = append(extensions, pkix.Extension{Id: oid, Critical: e.Critical, Value: value})
+	}
+	return extensions, nil
+}
+
+// Return the type name of a public key (rsa, ecdsa, or ed25519)
+func KeyTypeName(publicKey any) string {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return "rsa"
+	case *ecdsa.PublicKey:
+		return "ecdsa"
+	case ed25519.PublicKey:
+		return "ed25519"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,141 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"simpleca/tools"
+	"strconv"
+	"time"
+)
+
+// Window in which a certificate should be renewed (ACME renewal information)
+type RenewalWindow struct {
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	ExplanationURL string    `json:"explanationURL,omitempty"`
+}
+
+func RenewalUsage() {
+	fmt.Println(`
+Usage:  simpleca ca renewal [OPTIONS] [SERIAL...]
+
+Ask ACME clients to renew certificates early, e.g. after an incident.
+Certificates are selected by serial number or by filters (-all for every valid certificate).
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func Renewal(args []string) {
+	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificates authority")
+	dbFile := f.String("db", "", "Issuance database file (default index.json next to the CA certificate)")
+
+	all := f.Bool("all", false, "Select all valid certificates")
+	cn := f.String("cn", "", "Select certificates whose common name matches this pattern (*, ? allowed)")
+	san := f.String("san", "", "Select certificates with an alternate name matching this pattern (*, ? allowed)")
+	issuedBefore := f.String("issued-before", "", "Select certificates issued before this date (RFC 3339 or YYYY-MM-DD)")
+	within := f.Duration("within", 24*time.Hour, "Renewal window length, starting now")
+	explanation := f.String("explanation", "", "URL explaining the early renewal to the certificate owners")
+	cancel := f.Bool("cancel", false, "Cancel the early renewal of the selected certificates")
+	decimal := f.Bool("decimal", false, "Serial numbers in decimal (default hexadecimal, as printed by ca list)")
+
+	f.SetUsage(RenewalUsage)
+	f.Parse(args[1:])
+	if f.NArg() == 0 && !*all && len(*cn) == 0 && len(*san) == 0 && len(*issuedBefore) == 0 {
+		RenewalUsage()
+	}
+
+	serials := map[string]bool{}
+	for _, arg := range f.Args() {
+		serial, err := ParseSerial(arg, *decimal)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		serials[FormatSerial(serial)] = true
+	}
+	var before time.Time
+	if len(*issuedBefore) > 0 {
+		var err error
+		if before, err = parseDate(*issuedBefore); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if len(*dbFile) == 0 {
+		*dbFile = DatabaseFilename(*caCertFile)
+	}
+	db, err := LoadDatabase(*dbFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	count := 0
+	err = db.Update(func() error {
+		for _, r := range db.Records {
+			if r.State(now) != StatusValid {
+				continue
+			}
+			if len(serials) > 0 && !serials[r.Serial] {
+				continue
+			}
+			if len(*cn) > 0 && !tools.IsMatch(r.CommonName, *cn) {
+				continue
+			}
+			if len(*san) > 0 && !matchAny(r.SANs(), *san) {
+				continue
+			}
+			if !before.IsZero() && !r.NotBefore.Before(before) {
+				continue
+			}
+			if *cancel {
+				r.Renewal = nil
+			} else {
+				r.Renewal = &RenewalWindow{Start: now, End: now.Add(*within), ExplanationURL: *explanation}
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if *cancel {
+		fmt.Println("Early renewal cancelled for " + strconv.Itoa(count) + " certificates")
+	} else {
+		fmt.Println(strconv.Itoa(count) + " certificates to renew before " + now.Add(*within).Format(time.RFC3339))
+	}
+}
+
+// Window in which the certificate of a record should be renewed
+// Revoked certificates should be renewed immediately (window in the past), certificates
+// marked for early renewal in their window, others between 2/3 and 5/6 of their validity.
+func (r *Record) SuggestedRenewal(now time.Time) RenewalWindow {
+	if r.Status == StatusRevoked {
+		return RenewalWindow{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)}
+	}
+	if r.Renewal != nil {
+		return *r.Renewal
+	}
+	lifetime := r.NotAfter.Sub(r.NotBefore)
+	return RenewalWindow{
+		Start: r.NotBefore.Add(lifetime * 2 / 3),
+		End:   r.NotBefore.Add(lifetime * 5 / 6),
+	}
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("Invalid date " + s)
+}
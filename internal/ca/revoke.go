@@ -0,0 +1,120 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"simpleca/internal/cert"
+	"simpleca/tools"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Revocation reason codes (RFC 5280 section 5.3.1)
+var Reasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+func RevokeUsage() {
+	fmt.Println(`
+Usage:  simpleca ca revoke [OPTIONS] SERIAL|CERTFILE
+
+Revoke a certificate issued by a certificate authority
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func Revoke(args []string) {
+
+	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificates authority")
+	dbFile := f.String("db", "", "Issuance database file (default index.json next to the CA certificate)")
+	reason := f.String("reason", "unspecified", "Revocation reason ("+strings.Join(ReasonNames(), ", ")+")")
+	decimal := f.Bool("decimal", false, "Serial number in decimal (default hexadecimal, as printed by ca list)")
+
+	f.SetUsage(RevokeUsage)
+	f.Parse(args[1:])
+	if f.NArg() != 1 {
+		RevokeUsage()
+	} else {
+		code, err := ParseReason(*reason)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		var serial *big.Int
+		if arg := f.Arg(0); arg == "-" || tools.Existsfile(arg) {
+			crt, err := cert.LoadCertFile(arg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			serial = crt.SerialNumber
+		} else if serial, err = ParseSerial(arg, *decimal); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		if len(*dbFile) == 0 {
+			*dbFile = DatabaseFilename(*caCertFile)
+		}
+		db, err := LoadDatabase(*dbFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if err = db.Revoke(serial, code, time.Now()); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "Certificate "+FormatSerial(serial)+" revoked")
+	}
+}
+
+// Parse a revocation reason, by name or by code
+func ParseReason(reason string) (int, error) {
+	if code, found := Reasons[reason]; found {
+		return code, nil
+	}
+	if code, err := strconv.Atoi(reason); err == nil {
+		for _, v := range Reasons {
+			if v == code {
+				return code, nil
+			}
+		}
+	}
+	return 0, errors.New("Unknown revocation reason " + reason)
+}
+
+// Return the revocation reason name of a code
+func ReasonName(code int) string {
+	for k, v := range Reasons {
+		if v == code {
+			return k
+		}
+	}
+	return strconv.Itoa(code)
+}
+
+// Return all revocation reason names, ordered by code
+func ReasonNames() []string {
+	names := []string{}
+	for k := range Reasons {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool { return Reasons[names[i]] < Reasons[names[j]] })
+	return names
+}
@@ -3,13 +3,16 @@ package ca
 import (
 	"crypto/rand"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
+	"os/user"
 	"simpleca/internal/cert"
 	"simpleca/internal/csr"
 	"simpleca/internal/key"
 	"simpleca/tools"
+	"strings"
 	"time"
 )
 
@@ -30,6 +33,12 @@ func Sign(args []string) {
 	caPassphrase := f.String("ca-pass", "", "Private key passphrase of the certificates authority")
 	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificates authority")
 	caCertURL := f.String("issuer-cert-url", "", "URL of the certificates authority's certificate")
+	crlURL := f.String("crl-url", "", "URL of the certificates authority's revocation list")
+	ocspURL := f.String("ocsp-url", "", "URL of the certificates authority's OCSP responder")
+	dbFile := f.String("db", "", "Issuance database file (default index.json next to the CA certificate)")
+	requester := f.String("requester", currentUser(), "Requester recorded in the issuance database")
+	profilesFile := f.String("profiles", "", "Issuance profiles file (YAML)")
+	profileName := f.String("profile", DefaultProfileName, "Issuance profile")
 
 	days := f.Int("days", 3650, "Not valid after days")
 	out := f.StringP("out", "c", "-", "Output file (- for standard output)")
@@ -61,7 +70,7 @@ func Sign(args []string) {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
 		}
-		caCert, err := cert.LoadCertFile(*caCertFile)
+		caCert, caChain, err := LoadCAChainFile(*caCertFile)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
@@ -72,47 +81,92 @@ func Sign(args []string) {
 			os.Exit(1)
 		}
 
-		crt, err := CASign(csr, *days, caCert, caKey, *caCertURL)
+		profiles, err := LoadProfiles(*profilesFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		profile, found := profiles[*profileName]
+		if !found {
+			fmt.Fprintln(os.Stderr, "Unknown profile "+*profileName+" (available: "+strings.Join(ProfileNames(profiles), ", ")+")")
+			os.Exit(1)
+		}
+
+		crt, err := CASign(csr, *days, caCert, caKey, *caCertURL, *crlURL, *ocspURL, profile)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Unable to sign certificate "+err.Error())
 			os.Exit(1)
 		}
 
+		if len(*dbFile) == 0 {
+			*dbFile = DatabaseFilename(*caCertFile)
+		}
+		db, err := LoadDatabase(*dbFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if err = db.Add(crt, *requester); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to record certificate "+err.Error())
+			os.Exit(1)
+		}
+
 		fmt.Fprintln(os.Stderr, "Generating certificate")
-		cert.WriteCertFile(crt, *out)
+		cert.WriteCertsFile(append([]*x509.Certificate{crt}, caChain...), *out)
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
 	}
+	return ""
 }
 
 // Sign CSR with CA
-func CASign(csr *x509.CertificateRequest, days int, ca *x509.Certificate, caPrivKey any, caCertURL string) (*x509.Certificate, error) {
+// The profile sets key usages, validity cap and extensions (default profile if nil).
+func CASign(csr *x509.CertificateRequest, days int, ca *x509.Certificate, caPrivKey any, caCertURL, crlURL, ocspURL string, profile *Profile) (*x509.Certificate, error) {
 	var err error
 	if err = csr.CheckSignature(); err != nil {
 		return nil, err
 	}
+	if profile == nil {
+		profile = DefaultProfiles()[DefaultProfileName]
+	}
+	if !profile.AllowsKey(csr.PublicKey) {
+		return nil, errors.New("Key type " + KeyTypeName(csr.PublicKey) + " is not allowed by profile " + profile.Name)
+	}
+	days = profile.Days(days)
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
 		return nil, err
 	}
 	certTemplate := x509.Certificate{
-		SerialNumber:       serialNumber,
-		Subject:            csr.Subject,
-		SignatureAlgorithm: csr.SignatureAlgorithm,
-		Signature:          csr.Signature,
-		NotBefore:          time.Now(),
-		NotAfter:           time.Now().AddDate(0, 0, days),
-		KeyUsage:           x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		PublicKey:          csr.PublicKey,
-		DNSNames:           csr.DNSNames,
-		EmailAddresses:     csr.EmailAddresses,
-		IPAddresses:        csr.IPAddresses,
-		URIs:               csr.URIs,
+		SerialNumber:   serialNumber,
+		Subject:        csr.Subject,
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().AddDate(0, 0, days),
+		PublicKey:      csr.PublicKey,
+		DNSNames:       csr.DNSNames,
+		EmailAddresses: csr.EmailAddresses,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           csr.URIs,
 	}
 	if len(caCertURL) > 0 {
 		certTemplate.IssuingCertificateURL = []string{caCertURL}
 	}
+	if len(ocspURL) > 0 {
+		certTemplate.OCSPServer = []string{ocspURL}
+	}
+	if len(crlURL) > 0 {
+		certTemplate.CRLDistributionPoints = []string{crlURL}
+	}
+	if err = profile.Apply(&certTemplate); err != nil {
+		return nil, err
+	}
 	//certTemplate.PublicKeyAlgorithm = csr.PublicKeyAlgorithm
+	certTemplate.SignatureAlgorithm = signerAlgorithm(ca, caPrivKey)
 	crtBytes, err := x509.CreateCertificate(rand.Reader, &certTemplate, ca, csr.PublicKey, caPrivKey)
 	if err != nil {
 		return nil, err
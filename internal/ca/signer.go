@@ -0,0 +1,49 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+)
+
+// Signature algorithm of the certificates and CRLs signed by a certificate authority key:
+// SHA-256 with RSA (RSA-PSS if pss is set) for RSA keys, ECDSA with SHA-384 (SHA-512 for P-521) and Ed25519
+func SignatureAlgorithm(publicKey crypto.PublicKey, pss bool) x509.SignatureAlgorithm {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		if pss {
+			return x509.SHA256WithRSAPSS
+		}
+		return x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P521() {
+			return x509.ECDSAWithSHA512
+		}
+		return x509.ECDSAWithSHA384
+	case ed25519.PublicKey:
+		return x509.PureEd25519
+	default:
+		return x509.UnknownSignatureAlgorithm
+	}
+}
+
+// Signature algorithm of a certificate authority private key, unknown if it can not sign.
+// A certificate authority whose certificate is signed with RSA-PSS keeps signing with RSA-PSS.
+func signerAlgorithm(ca *x509.Certificate, privateKey any) x509.SignatureAlgorithm {
+	if signer, ok := privateKey.(crypto.Signer); ok {
+		return SignatureAlgorithm(signer.Public(), isPSS(ca.SignatureAlgorithm))
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// Test if a signature algorithm is RSA-PSS
+func isPSS(algorithm x509.SignatureAlgorithm) bool {
+	switch algorithm {
+	case x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package cert
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"simpleca/internal/key"
+	"simpleca/tools"
+	"strconv"
+	"strings"
+)
+
+// Formats of key stores and trust stores
+var KeyStoreFormats = []string{"pkcs12", "jks"}
+
+func TrustStoreUsage() {
+	fmt.Println(`
+Usage:  simpleca cert truststore [OPTIONS] [FILENAME...]
+
+Build a Java trust store (PKCS#12 or JKS) with the certificates of the files
+(default ca.crt, the certificate authority and its intermediates)
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func TrustStore(args []string) {
+	format := f.String("format", "pkcs12", "Trust store format ("+strings.Join(KeyStoreFormats, ", ")+")")
+	password := f.String("password", "changeit", "Trust store password")
+	alias := f.String("alias", "", "Alias of the certificates, numbered if several (default certificate common names)")
+	legacy := f.Bool("legacy", false, "Encrypt PKCS#12 trust stores with 3DES and SHA-1 for older Java versions")
+	out := f.StringP("out", "c", "-", "Output file (- for standard output)")
+
+	f.SetUsage(TrustStoreUsage)
+	f.Parse(args[1:])
+	filenames := f.Args()
+	if len(filenames) == 0 {
+		filenames = []string{"ca.crt"}
+	}
+
+	var certs []*x509.Certificate
+	for _, filename := range filenames {
+		if filename != "-" {
+			if b, _ := tools.Exists(filename); !b {
+				fmt.Fprintln(os.Stderr, "Certificate file "+filename+" does not exist")
+				os.Exit(1)
+			}
+		}
+		c, err := LoadCertsFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		certs = append(certs, c...)
+	}
+
+	bytes, err := EncodeKeyStore(TrustStoreEntries(certs, *alias), *format, *password, *legacy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	writeStore(bytes, *out)
+}
+
+func KeyStoreUsage() {
+	fmt.Println(`
+Usage:  simpleca cert keystore [OPTIONS]
+
+Build a Java key store (PKCS#12 or JKS) with a private key and its certificate chain under an alias
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func KeyStore(args []string) {
+	privKey := f.StringP("key", "k", "", "Private key file")
+	passphrase := f.String("passphrase", "", "Private key passphrase")
+	certFile := f.String("cert", "", "Certificate file (the following certificates of the file are added to the chain)")
+	chainFile := f.String("chain", "", "Certificate chain file (intermediate and root certificate authorities)")
+	alias := f.String("alias", "", "Alias of the private key (default certificate common name)")
+	format := f.String("format", "pkcs12", "Key store format ("+strings.Join(KeyStoreFormats, ", ")+")")
+	password := f.String("password", "", "Key store password (also protects the private key)")
+	legacy := f.Bool("legacy", false, "Encrypt PKCS#12 key stores with 3DES and SHA-1 for older Java versions")
+	out := f.StringP("out", "c", "-", "Output file (- for standard output)")
+
+	f.SetUsage(KeyStoreUsage)
+	f.Parse(args[1:])
+	if len(*privKey) == 0 || len(*certFile) == 0 || f.NArg() != 0 {
+		KeyStoreUsage()
+	}
+	if len(*password) == 0 {
+		fmt.Fprintln(os.Stderr, "Key store password can not be empty")
+		os.Exit(1)
+	}
+	for _, filename := range []string{*privKey, *certFile, *chainFile} {
+		if len(filename) > 0 && filename != "-" {
+			if b, _ := tools.Exists(filename); !b {
+				fmt.Fprintln(os.Stderr, "File "+filename+" does not exist")
+				os.Exit(1)
+			}
+		}
+	}
+
+	certs, err := LoadCertsFile(*certFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(*chainFile) > 0 {
+		chain, err := LoadCertsFile(*chainFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		certs = append(certs, chain...)
+	}
+	privatekey, err := key.LoadPrivateKeyFile(*privKey, *passphrase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if err = checkKeyPair(privatekey, certs[0]); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(*alias) == 0 {
+		*alias = aliasOf(certs[0])
+	}
+
+	entries := []key.KeyStoreEntry{{Alias: *alias, PrivateKey: privatekey, Certs: certs}}
+	bytes, err := EncodeKeyStore(entries, *format, *password, *legacy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	writeStore(bytes, *out)
+}
+
+// Encode a key store or a trust store in the format pkcs12 or jks
+func EncodeKeyStore(entries []key.KeyStoreEntry, format, password string, legacy bool) ([]byte, error) {
+	switch format {
+	case "pkcs12":
+		return key.EncodePKCS12Store(entries, password, legacy)
+	case "jks":
+		return key.EncodeJKS(entries, password)
+	default:
+		return nil, errors.New("Unknown key store format " + format)
+	}
+}
+
+// Trust store entries of certificates, with the alias (numbered if several) or their common names
+func TrustStoreEntries(certs []*x509.Certificate, alias string) []key.KeyStoreEntry {
+	entries := []key.KeyStoreEntry{}
+	aliases := map[string]bool{}
+	for i, c := range certs {
+		name := alias
+		if len(alias) == 0 {
+			name = aliasOf(c)
+		} else if len(certs) > 1 {
+			name = alias + "-" + strconv.Itoa(i+1)
+		}
+		// Same common name for several certificates (e.g. renewed certificate authorities)
+		for n := 2; aliases[name]; n++ {
+			name = aliasOf(c) + "-" + strconv.Itoa(n)
+		}
+		aliases[name] = true
+		entries = append(entries, key.KeyStoreEntry{Alias: name, Certs: []*x509.Certificate{c}})
+	}
+	return entries
+}
+
+// Default alias of a certificate: its common name, in lower case as keytool does
+func aliasOf(crt *x509.Certificate) string {
+	if len(crt.Subject.CommonName) == 0 {
+		return "certificate"
+	}
+	return strings.ToLower(crt.Subject.CommonName)
+}
+
+func writeStore(bytes []byte, filename string) {
+	var err error
+	if filename == "-" {
+		_, err = os.Stdout.Write(bytes)
+	} else {
+		err = os.WriteFile(filename, bytes, 0600)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can not write key store: "+err.Error())
+		os.Exit(1)
+	}
+}
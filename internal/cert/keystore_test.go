@@ -0,0 +1,62 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"simpleca/internal/key"
+	"testing"
+)
+
+func TestTrustStoreEntries(t *testing.T) {
+	_, root := testKeyPair(t, "Root CA")
+	_, intermediate := testKeyPair(t, "Intermediate CA")
+	_, renewed := testKeyPair(t, "Root CA")
+	certs := []*x509.Certificate{root, intermediate, renewed}
+
+	tests := []struct {
+		alias    string
+		certs    []*x509.Certificate
+		expected []string
+	}{
+		{"", certs, []string{"root ca", "intermediate ca", "root ca-2"}},
+		{"ca", certs, []string{"ca-1", "ca-2", "ca-3"}},
+		{"ca", certs[:1], []string{"ca"}},
+	}
+	for _, test := range tests {
+		entries := TrustStoreEntries(test.certs, test.alias)
+		if len(entries) != len(test.expected) {
+			t.Errorf("alias %q: got %d entries, expected %d", test.alias, len(entries), len(test.expected))
+			continue
+		}
+		for i, e := range entries {
+			if e.Alias != test.expected[i] || e.PrivateKey != nil || len(e.Certs) != 1 || !e.Certs[0].Equal(test.certs[i]) {
+				t.Errorf("alias %q: got entry %s, expected %s", test.alias, e.Alias, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestEncodeKeyStore(t *testing.T) {
+	privatekey, crt := testKeyPair(t, "server")
+	entries := []key.KeyStoreEntry{{Alias: "server", PrivateKey: privatekey, Certs: []*x509.Certificate{crt}}}
+
+	data, err := EncodeKeyStore(entries, "pkcs12", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certs, err := LoadPKCS12(data, "secret"); err != nil || len(certs) != 1 || !certs[0].Equal(crt) {
+		t.Errorf("pkcs12: got %d certificates, %v", len(certs), err)
+	}
+
+	data, err = EncodeKeyStore(entries, "jks", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte{0xfe, 0xed, 0xfe, 0xed}) {
+		t.Errorf("jks: got %x..., expected the JKS magic number", data[:4])
+	}
+
+	if _, err := EncodeKeyStore(entries, "jceks", "secret", false); err == nil {
+		t.Errorf("unknown format: got no error")
+	}
+}
@@ -17,8 +17,12 @@ Usage:  simpleca cert COMMAND
 Manage server certificates
 
 Commands:
+  keystore         Build a Java key store with a private key and its certificates
+  pkcs12           Bundle a private key and its certificates in a PKCS#12 file
   read             Read a certficate
   self             Create a self-signed certificate
+  truststore       Build a Java trust store with certificate authorities
+  verify           Verify a certificate and its chain
 
 `)
 }
@@ -29,10 +33,18 @@ func Main(args []string) {
 	} else {
 		argsWithoutProg := args[1:]
 		switch cmd := argsWithoutProg[0]; cmd {
+		case "keystore":
+			KeyStore(argsWithoutProg)
+		case "pkcs12":
+			PKCS12(argsWithoutProg)
 		case "read":
 			Read(argsWithoutProg)
 		case "self":
 			Self(argsWithoutProg)
+		case "truststore":
+			TrustStore(argsWithoutProg)
+		case "verify":
+			Verify(argsWithoutProg)
 		default:
 			fmt.Fprintln(os.Stderr, "Unknown command "+cmd)
 			usage()
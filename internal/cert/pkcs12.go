@@ -0,0 +1,111 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"simpleca/internal/key"
+	"simpleca/tools"
+)
+
+func PKCS12Usage() {
+	fmt.Println(`
+Usage:  simpleca cert pkcs12 [OPTIONS]
+
+Bundle a private key, its certificate and the certificate chain in a PKCS#12 (.p12, .pfx) file
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func PKCS12(args []string) {
+	privKey := f.StringP("key", "k", "", "Private key file")
+	passphrase := f.String("passphrase", "", "Private key passphrase")
+	certFile := f.String("cert", "", "Certificate file (the following certificates of the file are added to the chain)")
+	chainFile := f.String("chain", "", "Certificate chain file (intermediate and root certificate authorities)")
+	password := f.String("password", "", "PKCS#12 bundle password")
+	name := f.String("name", "", "Friendly name (alias) of the private key (default certificate common name)")
+	legacy := f.Bool("legacy", false, "Encrypt with 3DES and SHA-1 for older Windows and Java versions")
+	out := f.StringP("out", "c", "-", "Output file (- for standard output)")
+
+	f.SetUsage(PKCS12Usage)
+	f.Parse(args[1:])
+	if len(*certFile) == 0 || f.NArg() != 0 {
+		PKCS12Usage()
+	}
+	for _, filename := range []string{*privKey, *certFile, *chainFile} {
+		if len(filename) > 0 && filename != "-" {
+			if b, _ := tools.Exists(filename); !b {
+				fmt.Fprintln(os.Stderr, "File "+filename+" does not exist")
+				os.Exit(1)
+			}
+		}
+	}
+
+	certs, err := LoadCertsFile(*certFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(*chainFile) > 0 {
+		chain, err := LoadCertsFile(*chainFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		certs = append(certs, chain...)
+	}
+	var privatekey any
+	if len(*privKey) > 0 {
+		if privatekey, err = key.LoadPrivateKeyFile(*privKey, *passphrase); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if err = checkKeyPair(privatekey, certs[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+	if len(*name) == 0 {
+		*name = certs[0].Subject.CommonName
+	}
+
+	bytes, err := key.EncodePKCS12(privatekey, certs, *password, *name, *legacy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(bytes)
+	} else {
+		err = os.WriteFile(*out, bytes, 0600)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can not write PKCS#12 bundle: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// Load the certificates of a PKCS#12 bundle, the certificate of its private key first
+func LoadPKCS12(bytes []byte, password string) ([]*x509.Certificate, error) {
+	_, certs, err := key.DecodePKCS12(bytes, password)
+	if err == nil && len(certs) == 0 {
+		err = errors.New("No certificate in PKCS#12 bundle")
+	}
+	return certs, err
+}
+
+// Check that a private key is the key of a certificate
+func checkKeyPair(privatekey any, crt *x509.Certificate) error {
+	signer, ok := privatekey.(crypto.Signer)
+	if !ok {
+		return errors.New("Unknown private key type")
+	}
+	if pub, ok := crt.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(signer.Public()) {
+		return errors.New("Private key does not match certificate " + crt.Subject.CommonName)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"simpleca/internal/key"
+	"testing"
+	"time"
+)
+
+// Self-signed certificate of a new ECDSA key
+func testKeyPair(t *testing.T, cn string) (crypto.Signer, *x509.Certificate) {
+	curve, _ := key.ParseCurve("P256")
+	privatekey, err := key.GenerateKey("ecdsa", 0, curve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, privatekey.Public(), privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privatekey, crt
+}
+
+func TestLoadPKCS12(t *testing.T) {
+	privatekey, crt := testKeyPair(t, "leaf")
+	_, caCert := testKeyPair(t, "ca")
+	for _, legacy := range []bool{false, true} {
+		data, err := key.EncodePKCS12(privatekey, []*x509.Certificate{crt, caCert}, "secret", "leaf", legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs, err := LoadPKCS12(data, "secret")
+		if err != nil {
+			t.Errorf("legacy %v: %s", legacy, err)
+		} else if len(certs) != 2 || !certs[0].Equal(crt) || !certs[1].Equal(caCert) {
+			t.Errorf("legacy %v: got %d certificates, expected the certificate and its chain", legacy, len(certs))
+		}
+		if _, err := LoadPKCS12(data, "wrong"); err == nil {
+			t.Errorf("legacy %v: wrong password: got no error", legacy)
+		}
+	}
+}
+
+func TestCheckKeyPair(t *testing.T) {
+	privatekey, crt := testKeyPair(t, "leaf")
+	other, _ := testKeyPair(t, "other")
+	if err := checkKeyPair(privatekey, crt); err != nil {
+		t.Errorf("matching key: got %s", err)
+	}
+	if err := checkKeyPair(other, crt); err == nil {
+		t.Errorf("other key: got no error")
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"io"
 	"net"
 	"os"
+	"simpleca/internal/info"
+	"simpleca/internal/key"
+	"simpleca/internal/pkcs7"
 	"simpleca/tools"
 	"strconv"
 	"strings"
@@ -22,7 +25,7 @@ func ReadUsage() {
 	fmt.Println(`
 Usage:  simpleca cert read [OPTIONS] FILENAME
 
-Read a certificate
+Read a certificate (PEM, DER, PKCS#7 or PKCS#12 file, or https://host:port)
 
 Options:`)
 	f.PrintDefaults()
@@ -30,6 +33,8 @@ Options:`)
 }
 
 func Read(args []string) {
+	password := f.String("password", "", "PKCS#12 bundle password")
+	format := f.String("format", "text", "Output format ("+strings.Join(info.Formats, ", ")+"), a list when there are several certificates")
 
 	f.SetUsage(ReadUsage)
 	f.Parse(args[1:])
@@ -37,16 +42,17 @@ func Read(args []string) {
 		ReadUsage()
 	} else {
 		filename := f.Arg(0)
+		if err := info.CheckFormat(*format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 
 		if strings.HasPrefix(filename, "https://") {
 			if certs, err := LoadCertsServer(filename); err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			} else {
-				for _, c := range certs {
-					fmt.Print(c.Subject.CommonName + " - ")
-					PrintCert(c)
-				}
+				printCerts(certs, *format)
 			}
 		} else {
 			if filename != "-" {
@@ -55,16 +61,58 @@ func Read(args []string) {
 					os.Exit(1)
 				}
 			}
-			if crt, err := LoadCertFile(filename); err == nil {
-				PrintCert(crt)
+			var bytes []byte
+			var err error
+			if filename == "-" {
+				bytes, err = io.ReadAll(os.Stdin)
 			} else {
+				bytes, err = os.ReadFile(filename)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Can not read certificate file")
+				os.Exit(1)
+			}
+			var certs []*x509.Certificate
+			if key.IsPKCS12(bytes) {
+				certs, err = LoadPKCS12(bytes, *password)
+			} else {
+				certs, err = LoadCerts(bytes)
+			}
+			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
+			printCerts(certs, *format)
 		}
 	}
 }
 
+// Print certificates in a format (text, json or yaml)
+func printCerts(certs []*x509.Certificate, format string) {
+	if format == "text" {
+		if len(certs) == 1 {
+			PrintCert(certs[0])
+		} else {
+			for _, c := range certs {
+				fmt.Print(c.Subject.CommonName + " - ")
+				PrintCert(c)
+			}
+		}
+		return
+	}
+	// Always a list, whatever the number of certificates of the file
+	list := []*info.Certificate{}
+	for _, c := range certs {
+		list = append(list, info.FromCertificate(c))
+	}
+	bytes, err := info.Marshal(list, format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(bytes)
+}
+
 func PrintCert(cert *x509.Certificate) {
 	fmt.Println("Certificate:")
 	fmt.Println("    Data:")
@@ -228,14 +276,95 @@ func LoadCertStream(file *os.File) (*x509.Certificate, error) {
 	}
 }
 
+// Load the first certificate of PEM, DER, PKCS#7 or PKCS#12 (without password) data
 func LoadCert(bytes []byte) (*x509.Certificate, error) {
-	if certBlock, _ := pem.Decode(bytes); certBlock == nil {
-		return nil, errors.New("Unable to decode certificate file")
+	if certBlock, _ := pem.Decode(bytes); certBlock == nil || certBlock.Type == "PKCS7" {
+		certs, err := LoadCerts(bytes)
+		if err != nil {
+			return nil, err
+		}
+		return certs[0], nil
 	} else {
 		return ConvertCertBytes(certBlock.Bytes)
 	}
 }
 
+func LoadCertsFile(filename string) ([]*x509.Certificate, error) {
+	if filename == "-" {
+		return LoadCertsStream(os.Stdin)
+	} else if strings.HasPrefix(filename, "https://") {
+		return LoadCertsServer(filename)
+	} else {
+		if file, err := os.Open(filename); err == nil {
+			defer file.Close()
+			return LoadCertsStream(file)
+		} else {
+			return nil, errors.New("Can not open filename " + filename)
+		}
+	}
+}
+
+func LoadCertsStream(file *os.File) ([]*x509.Certificate, error) {
+	reader := bufio.NewReader(file)
+	if bytes, err := io.ReadAll(reader); err != nil {
+		return nil, errors.New("Can not read certificate file")
+	} else {
+		return LoadCerts(bytes)
+	}
+}
+
+// Load all certificates of a PEM bundle (or of DER, PKCS#7 or PKCS#12 data)
+func LoadCerts(bytes []byte) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(bytes); block == nil {
+		return LoadCertsDER(bytes)
+	}
+	certs := []*x509.Certificate{}
+	for {
+		var certBlock *pem.Block
+		if certBlock, bytes = pem.Decode(bytes); certBlock == nil {
+			break
+		}
+		if certBlock.Type == "PKCS7" {
+			if p7certs, err := pkcs7.ParseCertificates(certBlock.Bytes); err != nil {
+				return nil, err
+			} else {
+				certs = append(certs, p7certs...)
+			}
+			continue
+		}
+		if certBlock.Type != "CERTIFICATE" {
+			continue
+		}
+		if crt, err := ConvertCertBytes(certBlock.Bytes); err != nil {
+			return nil, err
+		} else {
+			certs = append(certs, crt)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("Unable to decode certificate file")
+	}
+	return certs, nil
+}
+
+// Load the certificates of DER data (or base64 without PEM headers): one or several certificates,
+// PKCS#7 bundle or PKCS#12 bundle without password
+func LoadCertsDER(der []byte) ([]*x509.Certificate, error) {
+	if decoded, ok := tools.DecodeBase64(der); ok {
+		der = decoded
+	}
+	if pkcs7.IsPKCS7(der) {
+		return pkcs7.ParseCertificates(der)
+	}
+	if key.IsPKCS12(der) {
+		return LoadPKCS12(der, "")
+	}
+	if certs, err := x509.ParseCertificates(der); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	return nil, errors.New("Unable to decode certificate file")
+}
+
 func ConvertCertBytes(caBytes []byte) (*x509.Certificate, error) {
 	return x509.ParseCertificate(caBytes)
 }
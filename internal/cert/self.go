@@ -2,6 +2,7 @@ package cert
 
 import (
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
@@ -29,7 +30,9 @@ Options:`)
 
 func Self(args []string) {
 	privKey := f.StringP("key", "k", "-", "Server private key file")
-	size := f.IntP("size", "s", 2048, "Private key size")
+	keyType := f.String("type", "rsa", "Private key type (rsa, ecdsa or ed25519)")
+	size := f.IntP("size", "s", 2048, "Private key size (rsa)")
+	curveName := f.String("curve", "P384", "Elliptic curve (P256, P384 or P521, ecdsa)")
 	passphrase := f.String("passphrase", "", "Server private key passphrase")
 
 	AltNames := f.StringP("alt-names", "a", "", "Coma separated alternate names list")
@@ -88,12 +91,17 @@ func Self(args []string) {
 			}
 		} else {
 			fmt.Fprintln(os.Stderr, "Generating private key")
-			privateKey, err := key.GenerateRSAKey(*size)
+			curve, err := key.ParseCurve(*curveName)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, err.Error())
 				os.Exit(1)
 			}
-			err = key.WriteRSAKeyFile(privateKey, *passphrase, *privKey)
+			privateKey, err := key.GenerateKey(*keyType, *size, curve)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			err = key.WriteKeyFile(privateKey, *passphrase, *privKey)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, err.Error())
 				os.Exit(1)
@@ -124,10 +132,14 @@ func GenerateSelfCertBytes(name, C, ST, L, O, OU, SA, PC string, altNames []stri
 		NotAfter:              time.Now().Add(time.Hour * 24 * 180),
 		DNSNames:              altNames,
 		IPAddresses:           ips,
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 	}
+	// Only RSA keys can encipher keys
+	if _, ok := privKey.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
 	if len(C) > 0 {
 		tmpl.Subject.Country = []string{C}
 	}
@@ -222,6 +234,39 @@ func WriteCertStream(cert *x509.Certificate, file *os.File) error {
 	return nil
 }
 
+// Encode certificates to a PEM bundle
+func EncodeCertsToPEM(certs []*x509.Certificate) []byte {
+	bytes := []byte{}
+	for _, cert := range certs {
+		bytes = append(bytes, pem.EncodeToMemory(ConvertCertToBlock(cert))...)
+	}
+	return bytes
+}
+
+func WriteCertsStream(certs []*x509.Certificate, file *os.File) error {
+	for _, cert := range certs {
+		if err := WriteCertStream(cert, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteCertsFile(certs []*x509.Certificate, filename string) error {
+	var file *os.File
+	var err error
+	if filename == "-" {
+		file = os.Stdout
+	} else {
+		file, err = os.Create(filename)
+		if err != nil {
+			return errors.New("Error when creating file")
+		}
+		defer file.Close()
+	}
+	return WriteCertsStream(certs, file)
+}
+
 func WriteCertFile(cert *x509.Certificate, filename string) error {
 	var file *os.File
 	var err error
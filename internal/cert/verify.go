@@ -0,0 +1,287 @@
+package cert
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"simpleca/tools"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Result of one constraint of a certificate verification
+type Check struct {
+	Name   string `yaml:"name" json:"name"`
+	Ok     bool   `yaml:"ok" json:"ok"`
+	Reason string `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// Extended key usages accepted by -purpose
+var Purposes = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"ipsecEndSystem":  x509.ExtKeyUsageIPSECEndSystem,
+	"ipsecTunnel":     x509.ExtKeyUsageIPSECTunnel,
+	"ipsecUser":       x509.ExtKeyUsageIPSECUser,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+func VerifyUsage() {
+	fmt.Println(`
+Usage:  simpleca cert verify [OPTIONS] FILENAME
+
+Verify a certificate (file or https://host:port): build its chain up to a certificate authority,
+check its validity period, host name and purpose, and explain which constraint failed
+The following certificates of the file (or sent by the server) are used as intermediates
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func Verify(args []string) {
+	caFile := f.String("ca", "", "Trusted certificate authorities file (default system certificate authorities)")
+	intermediates := f.String("intermediate", "", "Intermediate certificate authorities files, comma separated")
+	dnsName := f.String("dns", "", "Host name or IP address to check (default host of https:// URLs)")
+	purpose := f.String("purpose", "serverAuth", "Extended key usage to check ("+strings.Join(PurposeNames(), ", ")+")")
+	at := f.String("at", "", "Verification time, RFC 3339 or YYYY-MM-DD (default now)")
+
+	f.SetUsage(VerifyUsage)
+	f.Parse(args[1:])
+	if f.NArg() != 1 {
+		VerifyUsage()
+	}
+	filename := f.Arg(0)
+
+	opts := x509.VerifyOptions{Intermediates: x509.NewCertPool()}
+	usage, found := Purposes[*purpose]
+	if !found {
+		fmt.Fprintln(os.Stderr, "Unknown purpose "+*purpose)
+		os.Exit(1)
+	}
+	opts.KeyUsages = []x509.ExtKeyUsage{usage}
+	if len(*at) > 0 {
+		t, err := ParseTime(*at)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		opts.CurrentTime = t
+	}
+
+	var certs []*x509.Certificate
+	var err error
+	if strings.HasPrefix(filename, "https://") {
+		certs, err = LoadCertsServer(filename)
+		if err == nil && len(*dnsName) == 0 {
+			*dnsName, _, err = SplitHostPort(strings.TrimPrefix(filename, "https://"))
+		}
+	} else {
+		if filename != "-" {
+			if b, _ := tools.Exists(filename); !b {
+				fmt.Fprintln(os.Stderr, "Certificate file does not exist")
+				os.Exit(1)
+			}
+		}
+		certs, err = LoadCertsFile(filename)
+	}
+	if err == nil && len(certs) == 0 {
+		err = errors.New("No certificate found")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	opts.DNSName = *dnsName
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	for _, filename := range strings.Split(*intermediates, ",") {
+		if len(filename) == 0 {
+			continue
+		}
+		chain, err := LoadCertsFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Can not read intermediate certificates "+filename+": "+err.Error())
+			os.Exit(1)
+		}
+		for _, c := range chain {
+			opts.Intermediates.AddCert(c)
+		}
+	}
+	if len(*caFile) > 0 {
+		roots, err := LoadCertsFile(*caFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Can not read certificate authorities "+*caFile+": "+err.Error())
+			os.Exit(1)
+		}
+		opts.Roots = x509.NewCertPool()
+		for _, c := range roots {
+			opts.Roots.AddCert(c)
+		}
+	}
+
+	chain, checks, err := VerifyCert(certs[0], opts)
+	if len(chain) > 0 {
+		fmt.Println("Chain:")
+		for i, c := range chain {
+			fmt.Printf("  %d: %s (serial 0x%x, valid from %s to %s)\n", i, c.Subject.String(), c.SerialNumber,
+				c.NotBefore.Format(time.RFC3339), c.NotAfter.Format(time.RFC3339))
+		}
+	}
+	fmt.Println("Checks:")
+	for _, c := range checks {
+		if c.Ok {
+			fmt.Printf("  %-10s OK\n", c.Name)
+		} else {
+			fmt.Printf("  %-10s FAILED: %s\n", c.Name, c.Reason)
+		}
+	}
+	if err != nil {
+		fmt.Println("Verification failed: " + ExplainVerifyError(err))
+		os.Exit(1)
+	}
+	fmt.Println("Verification OK")
+}
+
+// Verify a certificate with x509.Verify and check each constraint separately to explain a failure.
+// The chain is built without the host name, purpose and time constraints when the verification fails.
+func VerifyCert(crt *x509.Certificate, opts x509.VerifyOptions) ([]*x509.Certificate, []Check, error) {
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	chains, verifyErr := crt.Verify(opts)
+
+	// Chain to a trusted certificate authority, at the verification time or else
+	// in the middle of the validity period of the certificate
+	relaxed := opts
+	relaxed.DNSName = ""
+	relaxed.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	var chain []*x509.Certificate
+	var trustErr error
+	for _, t := range []time.Time{now, crt.NotBefore.Add(crt.NotAfter.Sub(crt.NotBefore) / 2)} {
+		relaxed.CurrentTime = t
+		c, err := crt.Verify(relaxed)
+		if err == nil {
+			chain = c[0]
+			break
+		}
+		if trustErr == nil {
+			trustErr = err
+		}
+	}
+	if len(chains) > 0 {
+		chain = chains[0]
+	}
+	checks := []Check{{Name: "trust", Ok: chain != nil}}
+	if chain == nil {
+		checks[0].Reason = ExplainVerifyError(trustErr)
+	}
+
+	validity := Check{Name: "validity", Ok: true}
+	certs := chain
+	if certs == nil {
+		certs = []*x509.Certificate{crt}
+	}
+	for _, c := range certs {
+		if now.Before(c.NotBefore) {
+			validity = Check{Name: "validity", Reason: "certificate " + c.Subject.CommonName + " is not valid before " + c.NotBefore.Format(time.RFC3339)}
+			break
+		}
+		if now.After(c.NotAfter) {
+			validity = Check{Name: "validity", Reason: "certificate " + c.Subject.CommonName + " expired on " + c.NotAfter.Format(time.RFC3339)}
+			break
+		}
+	}
+	checks = append(checks, validity)
+
+	if len(opts.DNSName) > 0 {
+		check := Check{Name: "hostname", Ok: true}
+		if err := crt.VerifyHostname(opts.DNSName); err != nil {
+			check = Check{Name: "hostname", Reason: ExplainVerifyError(err)}
+		}
+		checks = append(checks, check)
+	}
+
+	check := Check{Name: "purpose", Ok: true}
+	if chain != nil {
+		relaxed.KeyUsages = opts.KeyUsages
+		if _, err := crt.Verify(relaxed); err != nil {
+			check = Check{Name: "purpose", Reason: ExplainVerifyError(err)}
+		}
+	} else if !hasExtKeyUsage(crt, opts.KeyUsages) {
+		check = Check{Name: "purpose", Reason: "certificate " + crt.Subject.CommonName + " is not valid for " + purposeNames(opts.KeyUsages)}
+	}
+	checks = append(checks, check)
+
+	return chain, checks, verifyErr
+}
+
+// Explain an error of x509.Verify
+func ExplainVerifyError(err error) string {
+	msg := strings.TrimPrefix(err.Error(), "x509: ")
+	switch e := err.(type) {
+	case x509.UnknownAuthorityError:
+		if e.Cert != nil {
+			return msg + ": issuer " + e.Cert.Issuer.String() + " of " + e.Cert.Subject.CommonName + " is not a trusted certificate authority or a known intermediate"
+		}
+	case x509.CertificateInvalidError:
+		if e.Cert != nil {
+			return "certificate " + e.Cert.Subject.CommonName + ": " + msg
+		}
+	}
+	return msg
+}
+
+// Parse a time in RFC 3339 format or a date (YYYY-MM-DD)
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("Invalid time " + s + " (RFC 3339 or YYYY-MM-DD)")
+}
+
+// Sorted names of the purposes
+func PurposeNames() []string {
+	names := []string{}
+	for name := range Purposes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func purposeNames(usages []x509.ExtKeyUsage) string {
+	names := []string{}
+	for _, u := range usages {
+		for name, v := range Purposes {
+			if v == u {
+				names = append(names, name)
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Whether the extended key usages of a certificate allow one of the usages
+func hasExtKeyUsage(crt *x509.Certificate, usages []x509.ExtKeyUsage) bool {
+	if len(crt.ExtKeyUsage) == 0 && len(crt.UnknownExtKeyUsage) == 0 {
+		return true
+	}
+	for _, u := range usages {
+		if u == x509.ExtKeyUsageAny || tools.Contains(crt.ExtKeyUsage, u) || tools.Contains(crt.ExtKeyUsage, x509.ExtKeyUsageAny) {
+			return true
+		}
+	}
+	return false
+}
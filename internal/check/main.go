@@ -0,0 +1,237 @@
+package check
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"simpleca/flags"
+	"simpleca/internal/cert"
+	"simpleca/internal/csr"
+	"simpleca/internal/key"
+	"sort"
+	"strings"
+)
+
+var (
+	f = flags.NewFlag("simpleca")
+)
+
+// Consistency report of a private key, a certificate signing request, a certificate and its chain
+type Report struct {
+	Ok      bool              `json:"ok"`
+	Files   map[string]string `json:"files"`
+	Results []Result          `json:"results"`
+}
+
+// Result of one check
+type Result struct {
+	Name   string `json:"name"`
+	Ok     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func Usage() {
+	fmt.Println(`
+Usage:  simpleca check [OPTIONS]
+
+Check that a private key, a certificate signing request, a certificate and its chain belong together:
+the public keys match, the certificate was issued from the subject and the alternative names of the request,
+and each certificate of the chain is issued by the next one
+A JSON report is written, the exit code is 1 when a check fails
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func Main(args []string) {
+	privKey := f.StringP("key", "k", "", "Private key file")
+	passphrase := f.String("passphrase", "", "Private key passphrase")
+	csrFile := f.String("csr", "", "Certificate signing request file")
+	certFile := f.String("cert", "", "Certificate file")
+	chainFile := f.String("chain", "", "Certificate chain file (intermediate and root certificate authorities, in order)")
+
+	f.SetUsage(Usage)
+	f.Parse(args[1:])
+	given := 0
+	for _, filename := range []string{*privKey, *csrFile, *certFile, *chainFile} {
+		if len(filename) > 0 {
+			given++
+		}
+	}
+	if given < 2 || f.NArg() != 0 {
+		Usage()
+	}
+
+	report := Check(*privKey, *passphrase, *csrFile, *certFile, *chainFile)
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if !report.Ok {
+		os.Exit(1)
+	}
+}
+
+// Check the consistency of the given files (empty names are skipped)
+func Check(keyFile, passphrase, csrFile, certFile, chainFile string) *Report {
+	r := &Report{Ok: true, Files: map[string]string{}}
+
+	var privatekey any
+	var ccsr *x509.CertificateRequest
+	var crt *x509.Certificate
+	var chain []*x509.Certificate
+	var err error
+	if len(keyFile) > 0 {
+		r.Files["key"] = keyFile
+		if privatekey, err = key.LoadPrivateKeyFile(keyFile, passphrase); err != nil {
+			r.add("key-read", err)
+		}
+	}
+	if len(csrFile) > 0 {
+		r.Files["csr"] = csrFile
+		if ccsr, err = csr.LoadCSRFile(csrFile); err != nil {
+			r.add("csr-read", err)
+		} else {
+			r.add("csr-signature", ccsr.CheckSignature())
+		}
+	}
+	if len(certFile) > 0 {
+		r.Files["cert"] = certFile
+		if crt, err = cert.LoadCertFile(certFile); err != nil {
+			r.add("cert-read", err)
+		}
+	}
+	if len(chainFile) > 0 {
+		r.Files["chain"] = chainFile
+		if chain, err = cert.LoadCertsFile(chainFile); err != nil {
+			r.add("chain-read", err)
+		}
+		// Full chain file starting with the certificate
+		if crt != nil && len(chain) > 0 && crt.Equal(chain[0]) {
+			chain = chain[1:]
+		}
+	}
+
+	if privatekey != nil {
+		pub := key.PublicKey(privatekey)
+		if crt != nil {
+			r.add("key-cert", matchKeys(pub, crt.PublicKey, "private key", "certificate"))
+		}
+		if ccsr != nil {
+			r.add("key-csr", matchKeys(pub, ccsr.PublicKey, "private key", "certificate signing request"))
+		}
+	}
+	if ccsr != nil && crt != nil {
+		r.add("csr-cert", matchKeys(ccsr.PublicKey, crt.PublicKey, "certificate signing request", "certificate"))
+		r.add("csr-subject", matchSubject(ccsr, crt))
+		r.add("csr-sans", matchSANs(ccsr, crt))
+	}
+	if len(chain) > 0 {
+		certs := chain
+		if crt != nil {
+			certs = append([]*x509.Certificate{crt}, chain...)
+		}
+		r.add("chain-order", checkOrder(certs))
+	}
+	return r
+}
+
+func (r *Report) add(name string, err error) {
+	result := Result{Name: name, Ok: err == nil}
+	if err != nil {
+		result.Reason = err.Error()
+		r.Ok = false
+	}
+	r.Results = append(r.Results, result)
+}
+
+func matchKeys(a, b crypto.PublicKey, aName, bName string) error {
+	if k, ok := a.(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(b) {
+		return fmt.Errorf("The public key of the %s does not match the public key of the %s", aName, bName)
+	}
+	return nil
+}
+
+func matchSubject(ccsr *x509.CertificateRequest, crt *x509.Certificate) error {
+	if ccsr.Subject.String() != crt.Subject.String() {
+		return fmt.Errorf("Certificate subject %q differs from request subject %q", crt.Subject.String(), ccsr.Subject.String())
+	}
+	return nil
+}
+
+func matchSANs(ccsr *x509.CertificateRequest, crt *x509.Certificate) error {
+	requested := sans(ccsr.DNSNames, ccsr.EmailAddresses, ccsr.IPAddresses, ccsr.URIs)
+	issued := sans(crt.DNSNames, crt.EmailAddresses, crt.IPAddresses, crt.URIs)
+	var missing, extra []string
+	for name := range requested {
+		if !issued[name] {
+			missing = append(missing, name)
+		}
+	}
+	for name := range issued {
+		if !requested[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	var reasons []string
+	if len(missing) > 0 {
+		reasons = append(reasons, "missing from the certificate: "+strings.Join(missing, ", "))
+	}
+	if len(extra) > 0 {
+		reasons = append(reasons, "not requested: "+strings.Join(extra, ", "))
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("Subject alternative names differ, %s", strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+// Subject alternative names, prefixed by their type
+func sans(dnsNames, emails []string, ips []net.IP, uris []*url.URL) map[string]bool {
+	names := map[string]bool{}
+	for _, n := range dnsNames {
+		names["DNS:"+strings.ToLower(n)] = true
+	}
+	for _, n := range emails {
+		names["email:"+n] = true
+	}
+	for _, n := range ips {
+		names["IP:"+n.String()] = true
+	}
+	for _, n := range uris {
+		names["URI:"+n.String()] = true
+	}
+	return names
+}
+
+// Check that each certificate is issued by the next one
+func checkOrder(certs []*x509.Certificate) error {
+	for i := 0; i < len(certs)-1; i++ {
+		err := certs[i].CheckSignatureFrom(certs[i+1])
+		if err == nil {
+			continue
+		}
+		if certs[i].CheckSignatureFrom(certs[i]) == nil {
+			return fmt.Errorf("Certificate %d (%s) is a self-signed root certificate authority, it must be the last of the chain", i, certs[i].Subject.CommonName)
+		}
+		for j, c := range certs {
+			if j != i && j != i+1 && certs[i].CheckSignatureFrom(c) == nil {
+				return fmt.Errorf("Certificate %d (%s) is issued by certificate %d (%s), expected at position %d", i, certs[i].Subject.CommonName, j, c.Subject.CommonName, i+1)
+			}
+		}
+		if !bytes.Equal(certs[i].RawIssuer, certs[i+1].RawSubject) {
+			return fmt.Errorf("Certificate %d (%s) is issued by %q, not by certificate %d (%s)", i, certs[i].Subject.CommonName, certs[i].Issuer.String(), i+1, certs[i+1].Subject.String())
+		}
+		return fmt.Errorf("Certificate %d (%s) is not issued by certificate %d (%s): %s", i, certs[i].Subject.CommonName, i+1, certs[i+1].Subject.CommonName, strings.TrimPrefix(err.Error(), "x509: "))
+	}
+	if last := certs[len(certs)-1]; !last.IsCA {
+		return fmt.Errorf("Certificate %d (%s) is not a certificate authority", len(certs)-1, last.Subject.CommonName)
+	}
+	return nil
+}
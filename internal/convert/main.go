@@ -0,0 +1,289 @@
+package convert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"simpleca/flags"
+	"simpleca/internal/cert"
+	"simpleca/internal/csr"
+	"simpleca/internal/key"
+	"simpleca/internal/pkcs7"
+	"simpleca/tools"
+	"strconv"
+	"strings"
+)
+
+var (
+	f = flags.NewFlag("simpleca")
+
+	// Output formats
+	Formats = []string{"pem", "der", "base64", "pkcs7", "pkcs12"}
+)
+
+// Content of a file: private keys, certificate signing requests and certificates
+type Bundle struct {
+	Format string
+	Keys   []any
+	CSRs   []*x509.CertificateRequest
+	Certs  []*x509.Certificate
+}
+
+func Usage() {
+	fmt.Println(`
+Usage:  simpleca convert [OPTIONS] FILENAME
+
+Convert certificates, certificate signing requests and private keys between formats
+The input format is detected: PEM, DER, base64 without PEM headers, PKCS#7 (.p7b) or PKCS#12 (.p12, .pfx)
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func Main(args []string) {
+	to := f.String("to", "pem", "Output format ("+strings.Join(Formats, ", ")+")")
+	passphrase := f.String("passphrase", "", "Passphrase of the input private key or PKCS#12 bundle")
+	outpass := f.String("outpass", "", "Passphrase of the output private key or PKCS#12 bundle")
+	out := f.StringP("out", "c", "-", "Output file (- for standard output)")
+
+	f.SetUsage(Usage)
+	f.Parse(args[1:])
+	if f.NArg() != 1 {
+		Usage()
+	}
+	filename := f.Arg(0)
+	if filename != "-" {
+		if b, _ := tools.Exists(filename); !b {
+			fmt.Fprintln(os.Stderr, "File "+filename+" does not exist")
+			os.Exit(1)
+		}
+	}
+
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can not read file "+filename)
+		os.Exit(1)
+	}
+	bundle, err := Decode(data, *passphrase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "Read "+bundle.Format+": "+bundle.String())
+
+	if data, err = Encode(bundle, *to, *outpass); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(*out, data, 0600)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can not write file: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// Decode data in any supported format
+func Decode(data []byte, passphrase string) (*Bundle, error) {
+	b := &Bundle{Format: "pem"}
+	if block, _ := pem.Decode(data); block != nil {
+		for {
+			if block, data = pem.Decode(data); block == nil {
+				break
+			}
+			switch {
+			case block.Type == "CERTIFICATE":
+				crt, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				b.Certs = append(b.Certs, crt)
+			case block.Type == "PKCS7":
+				certs, err := pkcs7.ParseCertificates(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				b.Certs = append(b.Certs, certs...)
+			case block.Type == "CERTIFICATE REQUEST" || block.Type == "NEW CERTIFICATE REQUEST":
+				ccsr, err := x509.ParseCertificateRequest(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				b.CSRs = append(b.CSRs, ccsr)
+			case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+				mkey, err := key.LoadPrivateKey(pem.EncodeToMemory(block), passphrase)
+				if err != nil {
+					return nil, err
+				}
+				b.Keys = append(b.Keys, mkey)
+			}
+		}
+		if b.count() == 0 {
+			return nil, errors.New("No certificate, certificate signing request or private key found")
+		}
+		return b, nil
+	}
+
+	b.Format = "der"
+	if der, ok := tools.DecodeBase64(data); ok {
+		data = der
+		b.Format = "base64"
+	}
+	switch {
+	case key.IsPKCS12(data):
+		b.Format = "pkcs12"
+		mkey, certs, err := key.DecodePKCS12(data, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if mkey != nil {
+			b.Keys = append(b.Keys, mkey)
+		}
+		b.Certs = certs
+	case pkcs7.IsPKCS7(data):
+		b.Format = "pkcs7"
+		certs, err := pkcs7.ParseCertificates(data)
+		if err != nil {
+			return nil, err
+		}
+		b.Certs = certs
+	default:
+		if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+			b.Certs = certs
+		} else if ccsr, err := x509.ParseCertificateRequest(data); err == nil {
+			b.CSRs = append(b.CSRs, ccsr)
+		} else if mkey, err := key.ParsePrivateKeyDER(data, passphrase); err == nil {
+			b.Keys = append(b.Keys, mkey)
+		} else {
+			return nil, errors.New("Unknown file format")
+		}
+	}
+	return b, nil
+}
+
+// Encode a bundle in a format, private keys are encrypted with the passphrase
+func Encode(b *Bundle, format, passphrase string) ([]byte, error) {
+	switch format {
+	case "pem":
+		var buf bytes.Buffer
+		for _, k := range b.Keys {
+			block, err := key.ConvertKeyToBlock(k, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			pem.Encode(&buf, block)
+		}
+		for _, c := range b.CSRs {
+			pem.Encode(&buf, csr.ConvertCSRToBlock(c))
+		}
+		buf.Write(cert.EncodeCertsToPEM(b.Certs))
+		return buf.Bytes(), nil
+	case "der":
+		return b.der(passphrase)
+	case "base64":
+		der, err := b.der(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		s := base64.StdEncoding.EncodeToString(der)
+		var buf bytes.Buffer
+		for len(s) > 64 {
+			buf.WriteString(s[:64] + "\n")
+			s = s[64:]
+		}
+		buf.WriteString(s + "\n")
+		return buf.Bytes(), nil
+	case "pkcs7":
+		if len(b.Certs) == 0 {
+			return nil, errors.New("No certificate to write in PKCS#7 bundle")
+		}
+		return pkcs7.EncodeCertificates(b.Certs)
+	case "pkcs12":
+		if len(b.Certs) == 0 {
+			return nil, errors.New("No certificate to write in PKCS#12 bundle")
+		}
+		if len(b.Keys) > 1 {
+			return nil, errors.New("A PKCS#12 bundle holds only one private key")
+		}
+		var privatekey any
+		certs := b.Certs
+		if len(b.Keys) == 1 {
+			privatekey = b.Keys[0]
+			certs = keyCertFirst(privatekey, certs)
+		}
+		return key.EncodePKCS12(privatekey, certs, passphrase, certs[0].Subject.CommonName, false)
+	default:
+		return nil, errors.New("Unknown output format " + format)
+	}
+}
+
+// Description of the content of a bundle
+func (b *Bundle) String() string {
+	parts := []string{}
+	for _, p := range []struct {
+		count int
+		name  string
+	}{{len(b.Keys), "private key"}, {len(b.CSRs), "certificate signing request"}, {len(b.Certs), "certificate"}} {
+		if p.count == 1 {
+			parts = append(parts, "1 "+p.name)
+		} else if p.count > 1 {
+			parts = append(parts, strconv.Itoa(p.count)+" "+p.name+"s")
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (b *Bundle) count() int {
+	return len(b.Keys) + len(b.CSRs) + len(b.Certs)
+}
+
+// DER of the single object of a bundle (PKCS#8 for private keys)
+func (b *Bundle) der(passphrase string) ([]byte, error) {
+	if b.count() != 1 {
+		return nil, errors.New("DER holds a single object, found " + b.String() + " (use pkcs7 or pkcs12 for bundles)")
+	}
+	switch {
+	case len(b.Certs) == 1:
+		return b.Certs[0].Raw, nil
+	case len(b.CSRs) == 1:
+		return b.CSRs[0].Raw, nil
+	case passphrase == "":
+		return x509.MarshalPKCS8PrivateKey(b.Keys[0])
+	default:
+		block, err := key.EncryptPKCS8PrivateKey(b.Keys[0], passphrase, key.DefaultKDF, key.DefaultCipher)
+		if err != nil {
+			return nil, err
+		}
+		return block.Bytes, nil
+	}
+}
+
+// Certificates with the certificate of the private key first
+func keyCertFirst(privatekey any, certs []*x509.Certificate) []*x509.Certificate {
+	signer, ok := privatekey.(crypto.Signer)
+	if !ok {
+		return certs
+	}
+	for i, c := range certs {
+		if pub, ok := c.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(signer.Public()) {
+			return append(append([]*x509.Certificate{c}, certs[:i]...), certs[i+1:]...)
+		}
+	}
+	return certs
+}
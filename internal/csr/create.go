@@ -30,7 +30,9 @@ Options:`)
 func Create(args []string) {
 
 	privKey := f.StringP("key", "k", "-", "Server private key file")
-	size := f.IntP("size", "s", 2048, "Private key size")
+	keyType := f.String("type", "rsa", "Private key type (rsa, ecdsa or ed25519)")
+	size := f.IntP("size", "s", 2048, "Private key size (rsa)")
+	curveName := f.String("curve", "P384", "Elliptic curve (P256, P384 or P521, ecdsa)")
 	passphrase := f.String("passphrase", "", "Server private key passphrase")
 	AltNames := f.StringP("alt-names", "a", "", "Coma separated alternate names list")
 	IPs := f.StringP("ips", "i", "", "Coma separated IP addresses list")
@@ -87,12 +89,17 @@ func Create(args []string) {
 			}
 		} else {
 			fmt.Fprintln(os.Stderr, "Generating private key")
-			privateKey, err := key.GenerateRSAKey(*size)
+			curve, err := key.ParseCurve(*curveName)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, err.Error())
 				os.Exit(1)
 			}
-			err = key.WriteRSAKeyFile(privateKey, *passphrase, *privKey)
+			privateKey, err := key.GenerateKey(*keyType, *size, curve)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			err = key.WriteKeyFile(privateKey, *passphrase, *privKey)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, err.Error())
 				os.Exit(1)
@@ -219,12 +226,11 @@ func GenerateCSRTemplate(name, C, ST, L, O, OU, SA, PC string, altNames []string
 			PostalCode:         []string{},
 			CommonName:         name,
 		},
-		SignatureAlgorithm: x509.SHA256WithRSA,
-		ExtraExtensions:    []pkix.Extension{},
-		DNSNames:           altNames,
-		EmailAddresses:     []string{},
-		IPAddresses:        ips,
-		URIs:               []*url.URL{},
+		ExtraExtensions: []pkix.Extension{},
+		DNSNames:        altNames,
+		EmailAddresses:  []string{},
+		IPAddresses:     ips,
+		URIs:            []*url.URL{},
 	}
 	tmpl.PublicKey = key.(crypto.Signer).Public()
 	if len(C) > 0 {
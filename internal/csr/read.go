@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"simpleca/internal/info"
 	"simpleca/tools"
 	"strconv"
 	"strings"
@@ -28,6 +29,7 @@ Options:`)
 }
 
 func Read(args []string) {
+	format := f.String("format", "text", "Output format ("+strings.Join(info.Formats, ", ")+")")
 
 	f.SetUsage(ReadUsage)
 	f.Parse(args[1:])
@@ -35,6 +37,10 @@ func Read(args []string) {
 		ReadUsage()
 	} else {
 		filename := f.Arg(0)
+		if err := info.CheckFormat(*format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 
 		if filename != "-" {
 			if b, _ := tools.Exists(filename); !b {
@@ -43,8 +49,15 @@ func Read(args []string) {
 			}
 		}
 
-		if csr, err := LoadCSRFile(filename); err == nil {
+		if csr, err := LoadCSRFile(filename); err == nil && *format == "text" {
 			PrintCSR(csr)
+		} else if err == nil {
+			if bytes, err := info.Marshal(info.FromRequest(csr), *format); err == nil {
+				os.Stdout.Write(bytes)
+			} else {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
 		} else {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -74,8 +87,15 @@ func LoadCSRStream(file *os.File) (*x509.CertificateRequest, error) {
 	}
 }
 
+// Load a certificate signing request (PEM, DER or base64 without PEM headers)
 func LoadCSR(bytes []byte) (*x509.CertificateRequest, error) {
 	if csrBlock, _ := pem.Decode(bytes); csrBlock == nil {
+		if der, ok := tools.DecodeBase64(bytes); ok {
+			bytes = der
+		}
+		if csr, err := ConvertCSRBytes(bytes); err == nil {
+			return csr, nil
+		}
 		return nil, errors.New("Unable to decode certificate signin request")
 	} else {
 		return ConvertCSRBytes(csrBlock.Bytes)
@@ -114,13 +134,9 @@ func PrintCSR(csr *x509.CertificateRequest) {
 	}
 	fmt.Println("        Attributes:")
 	fmt.Println("        Requested Extensions:")
-	fmt.Println("            " + csr.Extensions[0].Id.String() + ":")
-	fmt.Print("                ")
-	for i, v := range csr.Extensions {
-		if i > 0 {
-			fmt.Println(", ")
-		}
-		fmt.Println(printable(string(v.Value)))
+	for _, v := range csr.Extensions {
+		fmt.Println("            " + v.Id.String() + ":")
+		fmt.Println("                " + printable(string(v.Value)))
 	}
 	if len(csr.DNSNames) > 0 {
 		fmt.Println("        DSNNames:")
@@ -0,0 +1,304 @@
+// Package info describes certificates, certificate signing requests and keys with a stable
+// schema, written in JSON or YAML by the read commands
+package info
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+////
+// Types
+////
+
+// Distinguished name
+type Name struct {
+	DN                 string   `yaml:"dn" json:"dn"`
+	CommonName         string   `yaml:"commonName,omitempty" json:"commonName,omitempty"`
+	Organization       []string `yaml:"organization,omitempty" json:"organization,omitempty"`
+	OrganizationalUnit []string `yaml:"organizationalUnit,omitempty" json:"organizationalUnit,omitempty"`
+	Locality           []string `yaml:"locality,omitempty" json:"locality,omitempty"`
+	Province           []string `yaml:"province,omitempty" json:"province,omitempty"`
+	Country            []string `yaml:"country,omitempty" json:"country,omitempty"`
+}
+
+type Validity struct {
+	NotBefore time.Time `yaml:"notBefore" json:"notBefore"`
+	NotAfter  time.Time `yaml:"notAfter" json:"notAfter"`
+}
+
+// Subject alternative names
+type SANs struct {
+	DNS   []string `yaml:"dns,omitempty" json:"dns,omitempty"`
+	Email []string `yaml:"email,omitempty" json:"email,omitempty"`
+	IP    []string `yaml:"ip,omitempty" json:"ip,omitempty"`
+	URI   []string `yaml:"uri,omitempty" json:"uri,omitempty"`
+}
+
+// Public key type (rsa, ecdsa or ed25519), size in bits and curve of ECDSA keys
+type PublicKey struct {
+	Type  string `yaml:"type" json:"type"`
+	Size  int    `yaml:"size" json:"size"`
+	Curve string `yaml:"curve,omitempty" json:"curve,omitempty"`
+}
+
+type Extension struct {
+	OID      string `yaml:"oid" json:"oid"`
+	Name     string `yaml:"name,omitempty" json:"name,omitempty"`
+	Critical bool   `yaml:"critical" json:"critical"`
+}
+
+// Fingerprints of the DER encoding, in colon separated hexadecimal
+type Fingerprints struct {
+	SHA1   string `yaml:"sha1" json:"sha1"`
+	SHA256 string `yaml:"sha256" json:"sha256"`
+}
+
+type Certificate struct {
+	Subject            Name         `yaml:"subject" json:"subject"`
+	Issuer             Name         `yaml:"issuer" json:"issuer"`
+	Serial             string       `yaml:"serial" json:"serial"`
+	Validity           Validity     `yaml:"validity" json:"validity"`
+	SANs               SANs         `yaml:"sans" json:"sans"`
+	PublicKey          PublicKey    `yaml:"publicKey" json:"publicKey"`
+	SignatureAlgorithm string       `yaml:"signatureAlgorithm" json:"signatureAlgorithm"`
+	IsCA               bool         `yaml:"isCA" json:"isCA"`
+	KeyUsage           []string     `yaml:"keyUsage,omitempty" json:"keyUsage,omitempty"`
+	ExtKeyUsage        []string     `yaml:"extKeyUsage,omitempty" json:"extKeyUsage,omitempty"`
+	Extensions         []Extension  `yaml:"extensions" json:"extensions"`
+	Fingerprints       Fingerprints `yaml:"fingerprints" json:"fingerprints"`
+	SPKIPin            string       `yaml:"spkiPin" json:"spkiPin"`
+}
+
+type Request struct {
+	Subject            Name         `yaml:"subject" json:"subject"`
+	SANs               SANs         `yaml:"sans" json:"sans"`
+	PublicKey          PublicKey    `yaml:"publicKey" json:"publicKey"`
+	SignatureAlgorithm string       `yaml:"signatureAlgorithm" json:"signatureAlgorithm"`
+	Extensions         []Extension  `yaml:"extensions" json:"extensions"`
+	Fingerprints       Fingerprints `yaml:"fingerprints" json:"fingerprints"`
+	SPKIPin            string       `yaml:"spkiPin" json:"spkiPin"`
+}
+
+// Private key, without secret: fingerprints are those of the public key
+type Key struct {
+	PublicKey    PublicKey    `yaml:"publicKey" json:"publicKey"`
+	Fingerprints Fingerprints `yaml:"fingerprints" json:"fingerprints"`
+	SPKIPin      string       `yaml:"spkiPin" json:"spkiPin"`
+}
+
+////
+// Variables & Constants
+////
+
+// Output formats of the read commands
+var Formats = []string{"text", "json", "yaml"}
+
+var extensionNames = map[string]string{
+	"2.5.29.14":               "subjectKeyIdentifier",
+	"2.5.29.15":               "keyUsage",
+	"2.5.29.17":               "subjectAltName",
+	"2.5.29.19":               "basicConstraints",
+	"2.5.29.30":               "nameConstraints",
+	"2.5.29.31":               "cRLDistributionPoints",
+	"2.5.29.32":               "certificatePolicies",
+	"2.5.29.35":               "authorityKeyIdentifier",
+	"2.5.29.37":               "extKeyUsage",
+	"1.3.6.1.5.5.7.1.1":       "authorityInfoAccess",
+	"1.3.6.1.5.5.7.1.24":      "tlsFeature",
+	"1.3.6.1.5.5.7.1.31":      "acmeIdentifier",
+	"1.3.6.1.4.1.11129.2.4.2": "signedCertificateTimestampList",
+	"1.3.6.1.4.1.11129.2.4.3": "precertificatePoison",
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:  "ipsecEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:     "ipsecTunnel",
+	x509.ExtKeyUsageIPSECUser:       "ipsecUser",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+////
+// Descriptions
+////
+
+func FromCertificate(crt *x509.Certificate) *Certificate {
+	c := &Certificate{
+		Subject:            fromName(crt.Subject),
+		Issuer:             fromName(crt.Issuer),
+		Serial:             fmt.Sprintf("%x", crt.SerialNumber),
+		Validity:           Validity{NotBefore: crt.NotBefore.UTC(), NotAfter: crt.NotAfter.UTC()},
+		SANs:               fromSANs(crt.DNSNames, crt.EmailAddresses, crt.IPAddresses, crt.URIs),
+		PublicKey:          FromPublicKey(crt.PublicKey),
+		SignatureAlgorithm: crt.SignatureAlgorithm.String(),
+		IsCA:               crt.IsCA,
+		Extensions:         fromExtensions(crt.Extensions),
+		Fingerprints:       fromDER(crt.Raw),
+		SPKIPin:            spkiPin(crt.RawSubjectPublicKeyInfo),
+	}
+	for _, u := range keyUsageNames {
+		if crt.KeyUsage&u.usage != 0 {
+			c.KeyUsage = append(c.KeyUsage, u.name)
+		}
+	}
+	for _, u := range crt.ExtKeyUsage {
+		if name, found := extKeyUsageNames[u]; found {
+			c.ExtKeyUsage = append(c.ExtKeyUsage, name)
+		}
+	}
+	for _, u := range crt.UnknownExtKeyUsage {
+		c.ExtKeyUsage = append(c.ExtKeyUsage, u.String())
+	}
+	return c
+}
+
+func FromRequest(csr *x509.CertificateRequest) *Request {
+	return &Request{
+		Subject:            fromName(csr.Subject),
+		SANs:               fromSANs(csr.DNSNames, csr.EmailAddresses, csr.IPAddresses, csr.URIs),
+		PublicKey:          FromPublicKey(csr.PublicKey),
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+		Extensions:         fromExtensions(csr.Extensions),
+		Fingerprints:       fromDER(csr.Raw),
+		SPKIPin:            spkiPin(csr.RawSubjectPublicKeyInfo),
+	}
+}
+
+// Description of the public key of a private key
+func FromKey(publicKey any) (*Key, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, errors.New("Can not encode public key: " + err.Error())
+	}
+	return &Key{
+		PublicKey:    FromPublicKey(publicKey),
+		Fingerprints: fromDER(der),
+		SPKIPin:      spkiPin(der),
+	}, nil
+}
+
+func FromPublicKey(publicKey any) PublicKey {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		return PublicKey{Type: "rsa", Size: k.N.BitLen()}
+	case *ecdsa.PublicKey:
+		return PublicKey{Type: "ecdsa", Size: k.Curve.Params().BitSize, Curve: k.Curve.Params().Name}
+	case ed25519.PublicKey:
+		return PublicKey{Type: "ed25519", Size: 256}
+	default:
+		return PublicKey{Type: "unknown"}
+	}
+}
+
+// Encode a description in json or yaml
+func Marshal(v any, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		bytes, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(bytes, '\n'), nil
+	case "yaml":
+		return yaml.Marshal(v)
+	default:
+		return nil, errors.New("Unknown output format " + format)
+	}
+}
+
+// Check an output format of the read commands
+func CheckFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return errors.New("Unknown output format " + format + " (" + strings.Join(Formats, ", ") + ")")
+}
+
+func fromName(name pkix.Name) Name {
+	return Name{
+		DN:                 name.String(),
+		CommonName:         name.CommonName,
+		Organization:       name.Organization,
+		OrganizationalUnit: name.OrganizationalUnit,
+		Locality:           name.Locality,
+		Province:           name.Province,
+		Country:            name.Country,
+	}
+}
+
+func fromSANs(dnsNames, emails []string, ips []net.IP, uris []*url.URL) SANs {
+	s := SANs{DNS: dnsNames, Email: emails}
+	for _, ip := range ips {
+		s.IP = append(s.IP, ip.String())
+	}
+	for _, uri := range uris {
+		s.URI = append(s.URI, uri.String())
+	}
+	return s
+}
+
+func fromExtensions(extensions []pkix.Extension) []Extension {
+	list := []Extension{}
+	for _, e := range extensions {
+		list = append(list, Extension{OID: e.Id.String(), Name: extensionNames[e.Id.String()], Critical: e.Critical})
+	}
+	return list
+}
+
+func fromDER(der []byte) Fingerprints {
+	s1 := sha1.Sum(der)
+	s256 := sha256.Sum256(der)
+	return Fingerprints{SHA1: hexColon(s1[:]), SHA256: hexColon(s256[:])}
+}
+
+// Public key pin (RFC 7469): base64 of the SHA-256 of the subject public key info
+func spkiPin(spki []byte) string {
+	sum := sha256.Sum256(spki)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func hexColon(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%02X", v)
+	}
+	return strings.Join(parts, ":")
+}
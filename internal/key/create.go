@@ -1,7 +1,9 @@
 package key
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -10,8 +12,16 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 )
 
+// Elliptic curves of ECDSA keys
+var Curves = map[string]elliptic.Curve{
+	"P256": elliptic.P256(),
+	"P384": elliptic.P384(),
+	"P521": elliptic.P521(),
+}
+
 func CreateUsage() {
 	fmt.Println(`
 Usage:  simpleca key create [OPTIONS]
@@ -25,16 +35,22 @@ Options:`)
 
 func Create(args []string) {
 
-	size := f.Int("size", 2048, "Private key size (in bits")
+	size := f.Int("size", 2048, "Private key size (in bits, rsa)")
 	out := f.String("out", "-", "Output file (- for standard output)")
 	passphrase := f.String("passphrase", "", "Private key passphrase")
-	ktype := f.String("type", "rsa", "Private key type (rsa, or ecdsa)")
+	ktype := f.String("type", "rsa", "Private key type (rsa, ecdsa or ed25519)")
+	curveName := f.String("curve", "P384", "Elliptic curve (P256, P384 or P521, ecdsa)")
 
 	f.SetUsage(CreateUsage)
 	f.Parse(args[1:])
 	if f.NArg() != 0 {
 		CreateUsage()
 	} else {
+		curve, err := ParseCurve(*curveName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
 		switch *ktype {
 		case "rsa":
 			if err := GenerateRSAKeyFile(*size, *passphrase, *out); err != nil {
@@ -42,7 +58,12 @@ func Create(args []string) {
 				os.Exit(1)
 			}
 		case "ecdsa":
-			if err := GenerateECDSAKeyFile(*passphrase, *out); err != nil {
+			if err := GenerateECDSAKeyFile(curve, *passphrase, *out); err != nil {
+				fmt.Fprintf(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+		case "ed25519":
+			if err := GenerateEd25519KeyFile(*passphrase, *out); err != nil {
 				fmt.Fprintf(os.Stderr, err.Error())
 				os.Exit(1)
 			}
@@ -53,6 +74,69 @@ func Create(args []string) {
 	}
 }
 
+// Parse an elliptic curve name (P256, P-256, p256...)
+func ParseCurve(name string) (elliptic.Curve, error) {
+	if curve, ok := Curves[strings.ToUpper(strings.ReplaceAll(name, "-", ""))]; ok {
+		return curve, nil
+	}
+	return nil, errors.New("Unknown elliptic curve " + name)
+}
+
+// Generate a private key of a type (size only applies to RSA keys, curve to ECDSA keys)
+func GenerateKey(keyType string, size int, curve elliptic.Curve) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa":
+		return GenerateRSAKey(size)
+	case "ecdsa":
+		return GenerateECDSAKey(curve)
+	case "ed25519":
+		return GenerateEd25519Key()
+	default:
+		return nil, errors.New("Unknown private key type " + keyType)
+	}
+}
+
+// Convert a private key of any type to a PEM block, encrypted when a passphrase is given
+func ConvertKeyToBlock(privatekey any, passphrase string) (*pem.Block, error) {
+	switch k := privatekey.(type) {
+	case *rsa.PrivateKey:
+		return ConvertRSAKeyToBlock(k, passphrase)
+	case *ecdsa.PrivateKey:
+		return ConvertECDSAKeyToBlock(k, passphrase)
+	case ed25519.PrivateKey:
+		return ConvertEd25519KeyToBlock(k, passphrase)
+	default:
+		return nil, errors.New("Unknown private key type")
+	}
+}
+
+// Convert a private key of any type to a PEM block encrypted with the legacy OpenSSL encryption
+// (DEK-Info header), kept for tools which do not read encrypted PKCS#8 keys
+func ConvertKeyToLegacyBlock(privatekey any, passphrase string) (*pem.Block, error) {
+	block, err := ConvertKeyToBlock(privatekey, "")
+	if err != nil || passphrase == "" {
+		return block, err
+	}
+	if block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256); err != nil {
+		return nil, errors.New("Unable to encrypt private key: " + err.Error())
+	}
+	return block, nil
+}
+
+// Write a private key of any type, encrypted when a passphrase is given
+func WriteKeyFile(privatekey any, passphrase, filename string) error {
+	switch k := privatekey.(type) {
+	case *rsa.PrivateKey:
+		return WriteRSAKeyFile(k, passphrase, filename)
+	case *ecdsa.PrivateKey:
+		return WriteECDSAKeyFile(k, passphrase, filename)
+	case ed25519.PrivateKey:
+		return WriteEd25519KeyFile(k, passphrase, filename)
+	default:
+		return errors.New("Unknown private key type")
+	}
+}
+
 func GenerateRSAKey(size int) (*rsa.PrivateKey, error) {
 	if privatekey, err := rsa.GenerateKey(rand.Reader, size); err != nil {
 		return nil, errors.New("Can not generate RSA private key")
@@ -61,14 +145,25 @@ func GenerateRSAKey(size int) (*rsa.PrivateKey, error) {
 	}
 }
 
-func GenerateECDSAKey() (*ecdsa.PrivateKey, error) {
-	if privatekey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader); err != nil {
+func GenerateECDSAKey(curve elliptic.Curve) (*ecdsa.PrivateKey, error) {
+	if curve == nil {
+		curve = elliptic.P384()
+	}
+	if privatekey, err := ecdsa.GenerateKey(curve, rand.Reader); err != nil {
 		return nil, errors.New("Can not generate ECDSA private key")
 	} else {
 		return privatekey, nil
 	}
 }
 
+func GenerateEd25519Key() (ed25519.PrivateKey, error) {
+	if _, privatekey, err := ed25519.GenerateKey(rand.Reader); err != nil {
+		return nil, errors.New("Can not generate Ed25519 private key")
+	} else {
+		return privatekey, nil
+	}
+}
+
 func WriteRSAKeyStream(privatekey *rsa.PrivateKey, passphrase string, file *os.File) error {
 	if privateKeyBlock, err := ConvertRSAKeyToBlock(privatekey, passphrase); err != nil {
 		return err
@@ -93,6 +188,18 @@ func WriteECDSAKeyStream(privatekey *ecdsa.PrivateKey, passphrase string, file *
 	}
 }
 
+func WriteEd25519KeyStream(privatekey ed25519.PrivateKey, passphrase string, file *os.File) error {
+	if privateKeyBlock, err := ConvertEd25519KeyToBlock(privatekey, passphrase); err != nil {
+		return err
+	} else {
+		err = pem.Encode(file, privateKeyBlock)
+		if err != nil {
+			return errors.New("Error when encode private key to pem: " + err.Error())
+		}
+		return nil
+	}
+}
+
 func WriteRSAKeyFile(privatekey *rsa.PrivateKey, passphrase, filename string) error {
 	var file *os.File
 	var err error
@@ -123,6 +230,21 @@ func WriteECDSAKeyFile(privatekey *ecdsa.PrivateKey, passphrase, filename string
 	return WriteECDSAKeyStream(privatekey, passphrase, file)
 }
 
+func WriteEd25519KeyFile(privatekey ed25519.PrivateKey, passphrase, filename string) error {
+	var file *os.File
+	var err error
+	if filename == "-" {
+		file = os.Stdout
+	} else {
+		file, err = os.Create(filename)
+		if err != nil {
+			return errors.New("Error when creating file")
+		}
+		defer file.Close()
+	}
+	return WriteEd25519KeyStream(privatekey, passphrase, file)
+}
+
 func ConvertRSAKeyToBlock(privatekey *rsa.PrivateKey, passphrase string) (*pem.Block, error) {
 	var privateKeyBytes []byte = x509.MarshalPKCS1PrivateKey(privatekey)
 	var privateKeyBlock *pem.Block
@@ -133,7 +255,7 @@ func ConvertRSAKeyToBlock(privatekey *rsa.PrivateKey, passphrase string) (*pem.B
 			Bytes: privateKeyBytes,
 		}
 	} else {
-		if privateKeyBlock, err = x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", privateKeyBytes, []byte(passphrase), x509.PEMCipherAES256); err != nil {
+		if privateKeyBlock, err = EncryptPKCS8PrivateKey(privatekey, passphrase, DefaultKDF, DefaultCipher); err != nil {
 			return nil, errors.New("Unable to encrypt private key: " + err.Error())
 		}
 	}
@@ -153,7 +275,27 @@ func ConvertECDSAKeyToBlock(privatekey *ecdsa.PrivateKey, passphrase string) (*p
 			Bytes: privateKeyBytes,
 		}
 	} else {
-		if privateKeyBlock, err = x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", privateKeyBytes, []byte(passphrase), x509.PEMCipherAES256); err != nil {
+		if privateKeyBlock, err = EncryptPKCS8PrivateKey(privatekey, passphrase, DefaultKDF, DefaultCipher); err != nil {
+			return nil, errors.New("Unable to encrypt private key: " + err.Error())
+		}
+	}
+	return privateKeyBlock, nil
+}
+
+func ConvertEd25519KeyToBlock(privatekey ed25519.PrivateKey, passphrase string) (*pem.Block, error) {
+	var privateKeyBytes []byte
+	var privateKeyBlock *pem.Block
+	var err error
+	if privateKeyBytes, err = x509.MarshalPKCS8PrivateKey(privatekey); err != nil {
+		return nil, errors.New("Can not convert Ed25519 to Block: " + err.Error())
+	}
+	if passphrase == "" {
+		privateKeyBlock = &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: privateKeyBytes,
+		}
+	} else {
+		if privateKeyBlock, err = EncryptPKCS8PrivateKey(privatekey, passphrase, DefaultKDF, DefaultCipher); err != nil {
 			return nil, errors.New("Unable to encrypt private key: " + err.Error())
 		}
 	}
@@ -173,8 +315,8 @@ func GenerateRSAKeyBlock(size int, passphrase string) (*pem.Block, error) {
 	}
 }
 
-func GenerateECDSAKeyBlock(passphrase string) (*pem.Block, error) {
-	privatekey, err := GenerateECDSAKey()
+func GenerateECDSAKeyBlock(curve elliptic.Curve, passphrase string) (*pem.Block, error) {
+	privatekey, err := GenerateECDSAKey(curve)
 	if err != nil {
 		return nil, err
 	} else {
@@ -198,8 +340,8 @@ func GenerateRSAKeyStream(size int, passphrase string, file *os.File) error {
 	}
 }
 
-func GenerateECDSAKeyStream(passphrase string, file *os.File) error {
-	if privateKeyBlock, err := GenerateECDSAKeyBlock(passphrase); err != nil {
+func GenerateECDSAKeyStream(curve elliptic.Curve, passphrase string, file *os.File) error {
+	if privateKeyBlock, err := GenerateECDSAKeyBlock(curve, passphrase); err != nil {
 		return err
 	} else {
 		err = pem.Encode(file, privateKeyBlock)
@@ -225,7 +367,47 @@ func GenerateRSAKeyFile(size int, passphrase string, filename string) error {
 	return GenerateRSAKeyStream(size, passphrase, privatePem)
 }
 
-func GenerateECDSAKeyFile(passphrase string, filename string) error {
+func GenerateECDSAKeyFile(curve elliptic.Curve, passphrase string, filename string) error {
+	var privatePem *os.File
+	var err error
+	if filename == "-" {
+		privatePem = os.Stdout
+	} else {
+		privatePem, err = os.Create(filename)
+		if err != nil {
+			return errors.New("Error when creating file")
+		}
+		defer privatePem.Close()
+	}
+	return GenerateECDSAKeyStream(curve, passphrase, privatePem)
+}
+
+func GenerateEd25519KeyBlock(passphrase string) (*pem.Block, error) {
+	privatekey, err := GenerateEd25519Key()
+	if err != nil {
+		return nil, err
+	} else {
+		if privateKeyBlock, err := ConvertEd25519KeyToBlock(privatekey, passphrase); err != nil {
+			return nil, err
+		} else {
+			return privateKeyBlock, nil
+		}
+	}
+}
+
+func GenerateEd25519KeyStream(passphrase string, file *os.File) error {
+	if privateKeyBlock, err := GenerateEd25519KeyBlock(passphrase); err != nil {
+		return err
+	} else {
+		err = pem.Encode(file, privateKeyBlock)
+		if err != nil {
+			return errors.New("Error when encode private pem: " + err.Error())
+		}
+		return nil
+	}
+}
+
+func GenerateEd25519KeyFile(passphrase string, filename string) error {
 	var privatePem *os.File
 	var err error
 	if filename == "-" {
@@ -237,5 +419,5 @@ func GenerateECDSAKeyFile(passphrase string, filename string) error {
 		}
 		defer privatePem.Close()
 	}
-	return GenerateECDSAKeyStream(passphrase, privatePem)
+	return GenerateEd25519KeyStream(passphrase, privatePem)
 }
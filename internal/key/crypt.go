@@ -1,12 +1,12 @@
 package key
 
 import (
-	"crypto/ecdsa"
-	"crypto/ed25519"
-	"crypto/rsa"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
 	"simpleca/tools"
+	"strings"
 )
 
 func CryptUsage() {
@@ -14,6 +14,8 @@ func CryptUsage() {
 Usage:  simpleca key crypt [OPTIONS] FILENAME
 
 Add/change a passphrase to an existing private key
+Keys are written as encrypted PKCS#8 (ENCRYPTED PRIVATE KEY) unless -format legacy is given,
+which keeps the deprecated OpenSSL encryption (Proc-Type/DEK-Info headers).
 
 Options:`)
 	f.PrintDefaults()
@@ -25,6 +27,9 @@ func Crypt(args []string) {
 	out := f.String("out", "-", "Output file (- for standard output)")
 	outpass := f.String("outpass", "", "New passphrase")
 	passphrase := f.String("passphrase", "", "Private key passphrase")
+	format := f.String("format", "pkcs8", "Encrypted key format (pkcs8, legacy)")
+	kdf := f.String("kdf", DefaultKDF, "Key derivation function for PKCS#8 ("+strings.Join(KDFs, ", ")+")")
+	cipherName := f.String("cipher", DefaultCipher, "Cipher for PKCS#8 ("+strings.Join(Ciphers, ", ")+")")
 
 	f.SetUsage(CryptUsage)
 	f.Parse(args[1:])
@@ -40,20 +45,8 @@ func Crypt(args []string) {
 		}
 
 		if key, err := LoadPrivateKeyFile(filename, *passphrase); err == nil {
-			switch key.(type) {
-			case *rsa.PrivateKey:
-				if err = WriteRSAKeyFile(key.(*rsa.PrivateKey), *outpass, *out); err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					os.Exit(1)
-				}
-			case *ecdsa.PrivateKey:
-				fmt.Fprintln(os.Stderr, "ECDSA private key not available")
-				os.Exit(1)
-			case *ed25519.PrivateKey:
-				fmt.Fprintln(os.Stderr, "ED25519 private key not available")
-				os.Exit(1)
-			default:
-				fmt.Fprintln(os.Stderr, "Unknown private key type")
+			if err = writeEncryptedKeyFile(key, *outpass, *format, *kdf, *cipherName, *out); err != nil {
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 		} else {
@@ -62,3 +55,33 @@ func Crypt(args []string) {
 		}
 	}
 }
+
+func writeEncryptedKeyFile(privatekey any, passphrase, format, kdf, cipherName, filename string) error {
+	var block *pem.Block
+	var err error
+	switch {
+	case format == "legacy":
+		block, err = ConvertKeyToLegacyBlock(privatekey, passphrase)
+	case format != "pkcs8":
+		return errors.New("Unknown key format " + format)
+	case passphrase == "":
+		block, err = ConvertKeyToBlock(privatekey, "")
+	default:
+		block, err = EncryptPKCS8PrivateKey(privatekey, passphrase, kdf, cipherName)
+	}
+	if err != nil {
+		return err
+	}
+
+	file := os.Stdout
+	if filename != "-" {
+		if file, err = os.Create(filename); err != nil {
+			return errors.New("Error when creating file")
+		}
+		defer file.Close()
+	}
+	if err = pem.Encode(file, block); err != nil {
+		return errors.New("Error when encode private key to : " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package key
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Java key stores (JKS), written without keytool
+// Private keys are protected with the Sun JKS key protector (SHA-1 based), the key store is
+// protected by a SHA-1 digest of the password, "Mighty Aphrodite" and the content.
+
+////
+// Types
+////
+
+// Key store entry: a private key with its certificate chain, or a trusted certificate (nil private key)
+type KeyStoreEntry struct {
+	Alias      string
+	PrivateKey any
+	Certs      []*x509.Certificate
+}
+
+////
+// Variables & Constants
+////
+
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+const (
+	jksMagic   = 0xfeedfeed
+	jksVersion = 2
+
+	jksPrivateKeyEntry  = 1
+	jksTrustedCertEntry = 2
+)
+
+////
+// Encoding
+////
+
+// Encode entries in a Java key store, private keys are protected with the key store password
+func EncodeJKS(entries []KeyStoreEntry, password string) ([]byte, error) {
+	var buf bytes.Buffer
+	writeInt := func(v uint32) { binary.Write(&buf, binary.BigEndian, v) }
+	writeCert := func(c *x509.Certificate) {
+		writeJavaUTF(&buf, "X.509")
+		writeInt(uint32(len(c.Raw)))
+		buf.Write(c.Raw)
+	}
+
+	writeInt(jksMagic)
+	writeInt(jksVersion)
+	writeInt(uint32(len(entries)))
+	now := uint64(time.Now().UnixMilli())
+	aliases := map[string]bool{}
+	for _, entry := range entries {
+		if len(entry.Certs) == 0 {
+			return nil, errors.New("No certificate for key store entry " + entry.Alias)
+		}
+		// JKS aliases are case insensitive
+		alias := strings.ToLower(entry.Alias)
+		if aliases[alias] {
+			return nil, errors.New("Duplicate key store alias " + alias)
+		}
+		aliases[alias] = true
+
+		if entry.PrivateKey == nil {
+			writeInt(jksTrustedCertEntry)
+			writeJavaUTF(&buf, alias)
+			binary.Write(&buf, binary.BigEndian, now)
+			writeCert(entry.Certs[0])
+			continue
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(entry.PrivateKey)
+		if err != nil {
+			return nil, errors.New("Can not convert private key to PKCS#8: " + err.Error())
+		}
+		protected, err := jksProtectKey(der, password)
+		if err != nil {
+			return nil, err
+		}
+		writeInt(jksPrivateKeyEntry)
+		writeJavaUTF(&buf, alias)
+		binary.Write(&buf, binary.BigEndian, now)
+		writeInt(uint32(len(protected)))
+		buf.Write(protected)
+		writeInt(uint32(len(entry.Certs)))
+		for _, c := range entry.Certs {
+			writeCert(c)
+		}
+	}
+
+	h := sha1.New()
+	h.Write(javaPassword(password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(buf.Bytes())
+	return h.Sum(buf.Bytes()), nil
+}
+
+// Protect a PKCS#8 private key with the Sun JKS key protector
+func jksProtectKey(der []byte, password string) ([]byte, error) {
+	pass := javaPassword(password)
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	encrypted := make([]byte, len(der))
+	digest := salt
+	for i := 0; i < len(der); i += sha1.Size {
+		h := sha1.New()
+		h.Write(pass)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(der); j++ {
+			encrypted[i+j] = der[i+j] ^ digest[j]
+		}
+	}
+	h := sha1.New()
+	h.Write(pass)
+	h.Write(der)
+
+	protected := append(append(append([]byte{}, salt...), encrypted...), h.Sum(nil)...)
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
+
+// Java password bytes (big endian UTF-16, no terminator)
+func javaPassword(password string) []byte {
+	bmp := bmpPassword(password)
+	return bmp[:len(bmp)-2]
+}
+
+// Java DataOutput.writeUTF (modified UTF-8 with a 2 bytes length)
+func writeJavaUTF(buf *bytes.Buffer, s string) {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		switch {
+		case c >= 0x01 && c <= 0x7f:
+			b = append(b, byte(c))
+		case c <= 0x7ff:
+			b = append(b, byte(0xc0|c>>6), byte(0x80|c&0x3f))
+		default:
+			b = append(b, byte(0xe0|c>>12), byte(0x80|(c>>6)&0x3f), byte(0x80|c&0x3f))
+		}
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(b)))
+	buf.Write(b)
+}
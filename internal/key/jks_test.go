@@ -0,0 +1,300 @@
+package key
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+// Java key store entry as loaded by keytool
+type jksEntry struct {
+	alias string
+	key   []byte // PKCS#8 private key, nil for trusted certificates
+	certs [][]byte
+}
+
+// Decode a Java key store like sun.security.provider.JavaKeyStore: check the store digest,
+// then recover the private keys like sun.security.provider.KeyProtector
+func decodeJKS(data []byte, password string) ([]jksEntry, error) {
+	if len(data) < sha1.Size {
+		return nil, errors.New("key store too short")
+	}
+	content, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	h := sha1.New()
+	h.Write(javaPassword(password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(content)
+	if !hmac.Equal(h.Sum(nil), digest) {
+		return nil, errors.New("key store digest mismatch")
+	}
+
+	r := bytes.NewReader(content)
+	readInt := func() uint32 {
+		var v uint32
+		binary.Read(r, binary.BigEndian, &v)
+		return v
+	}
+	readBytes := func(n uint32) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	readCert := func() ([]byte, error) {
+		if certType, err := readJavaUTF(r); err != nil || certType != "X.509" {
+			return nil, errors.New("unexpected certificate type")
+		}
+		return readBytes(readInt()), nil
+	}
+
+	if readInt() != jksMagic || readInt() != jksVersion {
+		return nil, errors.New("not a JKS key store")
+	}
+	count := readInt()
+	var entries []jksEntry
+	for i := uint32(0); i < count; i++ {
+		tag := readInt()
+		alias, err := readJavaUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		readBytes(8) // creation date
+		entry := jksEntry{alias: alias}
+		switch tag {
+		case jksTrustedCertEntry:
+			crt, err := readCert()
+			if err != nil {
+				return nil, err
+			}
+			entry.certs = [][]byte{crt}
+		case jksPrivateKeyEntry:
+			if entry.key, err = jksRecoverKey(readBytes(readInt()), password); err != nil {
+				return nil, err
+			}
+			for n := readInt(); n > 0; n-- {
+				crt, err := readCert()
+				if err != nil {
+					return nil, err
+				}
+				entry.certs = append(entry.certs, crt)
+			}
+		default:
+			return nil, errors.New("unknown entry tag")
+		}
+		entries = append(entries, entry)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing data in key store")
+	}
+	return entries, nil
+}
+
+// Recover a private key protected with the Sun JKS key protector
+func jksRecoverKey(protected []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(protected, &info); err != nil || !info.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		return nil, errors.New("unexpected key protection")
+	}
+	data := info.EncryptedData
+	if len(data) < 2*sha1.Size {
+		return nil, errors.New("protected key too short")
+	}
+	pass := javaPassword(password)
+	salt, encrypted, check := data[:sha1.Size], data[sha1.Size:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	der := make([]byte, len(encrypted))
+	digest := salt
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		h := sha1.New()
+		h.Write(pass)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			der[i+j] = encrypted[i+j] ^ digest[j]
+		}
+	}
+	h := sha1.New()
+	h.Write(pass)
+	h.Write(der)
+	if !hmac.Equal(h.Sum(nil), check) {
+		return nil, errors.New("key protection check mismatch")
+	}
+	return der, nil
+}
+
+// Java DataInput.readUTF
+func readJavaUTF(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	var s []uint16
+	for i := 0; i < len(b); {
+		switch {
+		case b[i] < 0x80:
+			s = append(s, uint16(b[i]))
+			i++
+		case b[i]&0xe0 == 0xc0 && i+1 < len(b):
+			s = append(s, uint16(b[i]&0x1f)<<6|uint16(b[i+1]&0x3f))
+			i += 2
+		case b[i]&0xf0 == 0xe0 && i+2 < len(b):
+			s = append(s, uint16(b[i]&0x0f)<<12|uint16(b[i+1]&0x3f)<<6|uint16(b[i+2]&0x3f))
+			i += 3
+		default:
+			return "", errors.New("invalid modified UTF-8")
+		}
+	}
+	return string(utf16.Decode(s)), nil
+}
+
+// Bytes written by Java DataOutputStream.writeUTF
+func TestWriteJavaUTF(t *testing.T) {
+	tests := map[string]string{
+		"alias":      "0005616c696173",
+		"é":          "0002c3a9",
+		"€":          "0003e282ac",
+		"\x00":       "0002c080",
+		"\U0001F600": "0006eda0bdedb880",
+	}
+	for s, expected := range tests {
+		var buf bytes.Buffer
+		writeJavaUTF(&buf, s)
+		if got := hex.EncodeToString(buf.Bytes()); got != expected {
+			t.Errorf("writeUTF(%q): got %s, expected %s", s, got, expected)
+		}
+		if got, err := readJavaUTF(bytes.NewReader(buf.Bytes())); err != nil || got != s {
+			t.Errorf("readUTF(writeUTF(%q)): got %q, %v", s, got, err)
+		}
+	}
+}
+
+func TestEncodeJKSRoundTrip(t *testing.T) {
+	curve, _ := ParseCurve("P256")
+	ca, err := GenerateKey("ecdsa", 0, curve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert := testCertificate(t, ca, "ca")
+	for _, keyType := range []string{"rsa", "ecdsa", "ed25519"} {
+		privatekey, err := GenerateKey(keyType, 2048, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crt := testCertificate(t, privatekey, keyType)
+		data, err := EncodeJKS([]KeyStoreEntry{
+			{Alias: "Server", PrivateKey: privatekey, Certs: []*x509.Certificate{crt, caCert}},
+			{Alias: "CA", Certs: []*x509.Certificate{caCert}},
+		}, "secret")
+		if err != nil {
+			t.Fatalf("%s: %s", keyType, err)
+		}
+
+		entries, err := decodeJKS(data, "secret")
+		if err != nil {
+			t.Errorf("%s: %s", keyType, err)
+			continue
+		}
+		expectedKey, _ := x509.MarshalPKCS8PrivateKey(privatekey)
+		if len(entries) != 2 {
+			t.Fatalf("%s: got %d entries, expected 2", keyType, len(entries))
+		}
+		if e := entries[0]; e.alias != "server" || !bytes.Equal(e.key, expectedKey) ||
+			len(e.certs) != 2 || !bytes.Equal(e.certs[0], crt.Raw) || !bytes.Equal(e.certs[1], caCert.Raw) {
+			t.Errorf("%s: unexpected private key entry %s", keyType, e.alias)
+		}
+		if e := entries[1]; e.alias != "ca" || e.key != nil || len(e.certs) != 1 || !bytes.Equal(e.certs[0], caCert.Raw) {
+			t.Errorf("%s: unexpected trusted certificate entry %s", keyType, e.alias)
+		}
+		if _, err := decodeJKS(data, "wrong"); err == nil {
+			t.Errorf("%s: wrong password: got no error", keyType)
+		}
+	}
+
+	if _, err := EncodeJKS([]KeyStoreEntry{{Alias: "ca", Certs: []*x509.Certificate{caCert}}, {Alias: "CA", Certs: []*x509.Certificate{caCert}}}, "secret"); err == nil {
+		t.Errorf("duplicate alias: got no error")
+	}
+	if _, err := EncodeJKS([]KeyStoreEntry{{Alias: "ca"}}, "secret"); err == nil {
+		t.Errorf("no certificate: got no error")
+	}
+}
+
+// Key stores read and written by keytool, when a JDK is installed
+func TestJKSKeytool(t *testing.T) {
+	keytool, err := exec.LookPath("keytool")
+	if err != nil {
+		t.Skip("keytool not found")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		if out, err := exec.Command(keytool, args...).CombinedOutput(); err != nil {
+			t.Fatalf("keytool %v: %s\n%s", args, err, out)
+		}
+	}
+
+	// Key store written by keytool
+	jks := filepath.Join(dir, "keytool.jks")
+	run("-genkeypair", "-keyalg", "EC", "-keysize", "256", "-alias", "server", "-dname", "CN=server",
+		"-storetype", "JKS", "-keystore", jks, "-storepass", "secret", "-keypass", "secret")
+	data, err := os.ReadFile(jks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := decodeJKS(data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].alias != "server" || len(entries[0].certs) != 1 {
+		t.Fatalf("keytool key store: got %d entries", len(entries))
+	}
+	privatekey, err := x509.ParsePKCS8PrivateKey(entries[0].key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(entries[0].certs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub, ok := crt.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(privatekey.(crypto.Signer).Public()) {
+		t.Errorf("keytool key store: private key does not match its certificate")
+	}
+
+	// Key store read by keytool: converted to PKCS#12 and read back
+	ours := filepath.Join(dir, "ours.jks")
+	p12 := filepath.Join(dir, "ours.p12")
+	data, err = EncodeJKS([]KeyStoreEntry{{Alias: "server", PrivateKey: privatekey, Certs: []*x509.Certificate{crt}}}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ours, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	run("-importkeystore", "-noprompt", "-srckeystore", ours, "-srcstoretype", "JKS", "-srcstorepass", "secret", "-srckeypass", "secret",
+		"-srcalias", "server", "-destkeystore", p12, "-deststoretype", "PKCS12", "-deststorepass", "secret", "-destkeypass", "secret")
+	data, err = os.ReadFile(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, _, err := DecodePKCS12(data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := converted.(interface{ Equal(crypto.PrivateKey) bool }); !ok || !k.Equal(privatekey) {
+		t.Errorf("key store read by keytool: got a different private key")
+	}
+}
@@ -0,0 +1,643 @@
+package key
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+	"unicode/utf16"
+)
+
+// PKCS#12 / PFX bundles (RFC 7292) of a private key and its certificates
+// Bundles are written with PBES2 (PBKDF2-HMAC-SHA256, AES-256-CBC) and a HMAC-SHA256 integrity check,
+// or with 3DES and HMAC-SHA1 for older Windows and Java versions (legacy).
+// Bundles encrypted with 3DES or RC2 (legacy OpenSSL and Java keytool defaults) can be read.
+
+////
+// Types
+////
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	Id   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+////
+// Variables & Constants
+////
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd2KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 4}
+	oidPBEWithSHAAnd128BitRC2CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+const (
+	pkcs12Iterations       = 2048
+	pkcs12LegacyIterations = 2048
+)
+
+////
+// Encoding
+////
+
+// Encode a private key (optional), its certificate and the certificate chain in a PKCS#12 bundle
+// certs[0] is the certificate of the private key, name its friendly name (alias of Java key stores)
+func EncodePKCS12(privatekey any, certs []*x509.Certificate, password, name string, legacy bool) ([]byte, error) {
+	return EncodePKCS12Store([]KeyStoreEntry{{Alias: name, PrivateKey: privatekey, Certs: certs}}, password, legacy)
+}
+
+// Encode key store entries in a PKCS#12 bundle
+// Trusted certificates are marked for Java trust stores (Oracle trusted key usage attribute).
+func EncodePKCS12Store(entries []KeyStoreEntry, password string, legacy bool) ([]byte, error) {
+	var certBags, keyBags []safeBag
+	for _, entry := range entries {
+		if len(entry.Certs) == 0 {
+			return nil, errors.New("No certificate to store in PKCS#12 bundle")
+		}
+		var attributes []pkcs12Attribute
+		var err error
+		if entry.PrivateKey == nil {
+			if attributes, err = pkcs12TrustedAttributes(entry.Alias); err != nil {
+				return nil, err
+			}
+		} else {
+			localKeyID := sha1.Sum(entry.Certs[0].Raw)
+			if attributes, err = pkcs12Attributes(entry.Alias, localKeyID[:]); err != nil {
+				return nil, err
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(entry.PrivateKey)
+			if err != nil {
+				return nil, errors.New("Can not convert private key to PKCS#8: " + err.Error())
+			}
+			algorithm, encrypted, err := encryptPKCS12Content(der, password, legacy)
+			if err != nil {
+				return nil, err
+			}
+			info, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: algorithm, EncryptedData: encrypted})
+			if err != nil {
+				return nil, err
+			}
+			keyBags = append(keyBags, safeBag{Id: oidPKCS8ShroudedKeyBag, Value: explicitContent(info), Attributes: attributes})
+		}
+		for i, c := range entry.Certs {
+			data, err := asn1.Marshal(certBag{Id: oidCertTypeX509, Data: c.Raw})
+			if err != nil {
+				return nil, err
+			}
+			bag := safeBag{Id: oidCertBag, Value: explicitContent(data)}
+			if i == 0 {
+				bag.Attributes = attributes
+			}
+			certBags = append(certBags, bag)
+		}
+	}
+
+	// Certificates, encrypted
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, encrypted, err := encryptPKCS12Content(certContents, password, legacy)
+	if err != nil {
+		return nil, err
+	}
+	data, err := asn1.Marshal(encryptedData{
+		Version: 0,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: algorithm,
+			EncryptedContent:           encrypted,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	authSafe := []contentInfo{{ContentType: oidEncryptedDataContentType, Content: explicitContent(data)}}
+
+	// Private keys, in shrouded (encrypted) key bags
+	if len(keyBags) > 0 {
+		keyContents, err := asn1.Marshal(keyBags)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = asn1.Marshal(keyContents); err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, contentInfo{ContentType: oidDataContentType, Content: explicitContent(data)})
+	}
+
+	authSafeBytes, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = asn1.Marshal(authSafeBytes); err != nil {
+		return nil, err
+	}
+
+	// Integrity
+	h, hashOID, iterations := sha256.New, oidSHA256, pkcs12Iterations
+	if legacy {
+		h, hashOID, iterations = sha1.New, oidSHA1, pkcs12LegacyIterations
+	}
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPdu{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicitContent(data)},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: hashOID, Parameters: asn1.NullRawValue},
+				Digest:    pkcs12MAC(h, authSafeBytes, bmpPassword(password), salt, iterations),
+			},
+			MacSalt:    salt,
+			Iterations: iterations,
+		},
+	})
+}
+
+func encryptPKCS12Content(data []byte, password string, legacy bool) (pkix.AlgorithmIdentifier, []byte, error) {
+	if !legacy {
+		return encryptPBES2(data, password, "pbkdf2", "aes-256-cbc")
+	}
+	var algorithm pkix.AlgorithmIdentifier
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return algorithm, nil, err
+	}
+	params, err := asn1.Marshal(pkcs12PBEParams{Salt: salt, Iterations: pkcs12LegacyIterations})
+	if err != nil {
+		return algorithm, nil, err
+	}
+	algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDESCBC, Parameters: asn1.RawValue{FullBytes: params}}
+	block, iv, err := pkcs12PBECipher(algorithm.Algorithm, bmpPassword(password), salt, pkcs12LegacyIterations)
+	if err != nil {
+		return algorithm, nil, err
+	}
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	encrypted := append(append([]byte{}, data...), bytesOf(byte(padding), padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	return algorithm, encrypted, nil
+}
+
+func pkcs12Attributes(name string, localKeyID []byte) ([]pkcs12Attribute, error) {
+	id, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{Id: oidLocalKeyID, Value: setOf(id)}}
+	if len(name) > 0 {
+		friendlyName, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: javaPassword(name)})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{Id: oidFriendlyName, Value: setOf(friendlyName)})
+	}
+	return attributes, nil
+}
+
+// Attributes of a trusted certificate: friendly name and usage (any) for Java trust stores
+func pkcs12TrustedAttributes(name string) ([]pkcs12Attribute, error) {
+	usage, err := asn1.Marshal(oidAnyExtendedKeyUsage)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{Id: oidJavaTrustedKeyUsage, Value: setOf(usage)}}
+	if len(name) > 0 {
+		friendlyName, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: javaPassword(name)})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{Id: oidFriendlyName, Value: setOf(friendlyName)})
+	}
+	return attributes, nil
+}
+
+// [0] EXPLICIT wrapper of a DER value
+func explicitContent(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func setOf(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+}
+
+////
+// Decoding
+////
+
+// Tell if data is a PKCS#12 bundle (DER encoded PFX)
+func IsPKCS12(data []byte) bool {
+	var pfx pfxPdu
+	rest, err := asn1.Unmarshal(data, &pfx)
+	return err == nil && len(rest) == 0 && pfx.Version == 3
+}
+
+// Decode a PKCS#12 bundle, returns the private key (nil if there is none) and the certificates,
+// the certificate of the private key first
+func DecodePKCS12(data []byte, password string) (any, []*x509.Certificate, error) {
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		return nil, nil, errors.New("Can not decode PKCS#12 bundle")
+	}
+	if pfx.Version != 3 {
+		return nil, nil, errors.New("Unsupported PKCS#12 version")
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, nil, errors.New("Only password integrity PKCS#12 bundles are supported")
+	}
+	var authSafeBytes []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeBytes); err != nil {
+		return nil, nil, errors.New("Can not decode PKCS#12 bundle")
+	}
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) > 0 {
+		if err := checkPKCS12MAC(pfx.MacData, authSafeBytes, password); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var authSafe []contentInfo
+	if _, err := asn1.Unmarshal(authSafeBytes, &authSafe); err != nil {
+		return nil, nil, errors.New("Can not decode PKCS#12 bundle")
+	}
+	var privatekey any
+	var certs []*x509.Certificate
+	for _, ci := range authSafe {
+		var contents []byte
+		switch {
+		case ci.ContentType.Equal(oidDataContentType):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &contents); err != nil {
+				return nil, nil, errors.New("Can not decode PKCS#12 bundle")
+			}
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			var ed encryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, nil, errors.New("Can not decode PKCS#12 bundle")
+			}
+			var err error
+			info := ed.EncryptedContentInfo
+			if contents, err = decryptPBE(info.ContentEncryptionAlgorithm, info.EncryptedContent, password); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, errors.New("Unsupported PKCS#12 content " + ci.ContentType.String())
+		}
+
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(contents, &bags); err != nil {
+			return nil, nil, errors.New("Can not decode PKCS#12 bundle")
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.Id.Equal(oidCertBag):
+				var cb certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil || !cb.Id.Equal(oidCertTypeX509) {
+					return nil, nil, errors.New("Can not decode PKCS#12 certificate")
+				}
+				crt, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return nil, nil, err
+				}
+				certs = append(certs, crt)
+			case bag.Id.Equal(oidPKCS8ShroudedKeyBag), bag.Id.Equal(oidKeyBag):
+				der := bag.Value.Bytes
+				if bag.Id.Equal(oidPKCS8ShroudedKeyBag) {
+					var err error
+					if der, err = DecryptPKCS8PrivateKey(der, password); err != nil {
+						return nil, nil, err
+					}
+				}
+				var err error
+				if privatekey, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+					return nil, nil, errors.New("Can not decode PKCS#12 private key")
+				}
+			}
+		}
+	}
+
+	// Certificate of the private key first
+	if signer, ok := privatekey.(crypto.Signer); ok {
+		for i, c := range certs {
+			if pub, ok := c.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(signer.Public()) {
+				certs[0], certs[i] = certs[i], certs[0]
+				break
+			}
+		}
+	}
+	return privatekey, certs, nil
+}
+
+func checkPKCS12MAC(mac macData, data []byte, password string) error {
+	var h func() hash.Hash
+	switch algorithm := mac.Mac.Algorithm.Algorithm; {
+	case algorithm.Equal(oidSHA1):
+		h = sha1.New
+	case algorithm.Equal(oidSHA256):
+		h = sha256.New
+	case algorithm.Equal(oidSHA384):
+		h = sha512.New384
+	case algorithm.Equal(oidSHA512):
+		h = sha512.New
+	default:
+		return errors.New("Unsupported PKCS#12 integrity algorithm " + algorithm.String())
+	}
+	if mac.Iterations < 1 {
+		return errors.New("Invalid PKCS#12 iteration count")
+	}
+	if hmac.Equal(pkcs12MAC(h, data, bmpPassword(password), mac.MacSalt, mac.Iterations), mac.Mac.Digest) {
+		return nil
+	}
+	// Some tools use an empty password instead of an empty string (two zero bytes)
+	if password == "" && hmac.Equal(pkcs12MAC(h, data, nil, mac.MacSalt, mac.Iterations), mac.Mac.Digest) {
+		return nil
+	}
+	return errors.New("Can not open PKCS#12 bundle with password")
+}
+
+func pkcs12MAC(h func() hash.Hash, data, password, salt []byte, iterations int) []byte {
+	key := pkcs12KDF(h, password, salt, 3, iterations, h().Size())
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+////
+// Legacy PKCS#12 password based encryption
+////
+
+func isPKCS12PBE(algorithm asn1.ObjectIdentifier) bool {
+	return algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC) || algorithm.Equal(oidPBEWithSHAAnd2KeyTripleDESCBC) ||
+		algorithm.Equal(oidPBEWithSHAAnd128BitRC2CBC) || algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC)
+}
+
+func decryptPKCS12PBE(algorithm pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	var params pkcs12PBEParams
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, errors.New("Can not decode PKCS#12 encryption parameters")
+	}
+	if params.Iterations < 1 {
+		return nil, errors.New("Invalid PKCS#12 iteration count")
+	}
+	block, iv, err := pkcs12PBECipher(algorithm.Algorithm, bmpPassword(password), params.Salt, params.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	return cbcDecrypt(block, iv, data)
+}
+
+// Cipher and IV of a PKCS#12 password based encryption (RFC 7292 appendix B and C)
+func pkcs12PBECipher(algorithm asn1.ObjectIdentifier, password, salt []byte, iterations int) (cipher.Block, []byte, error) {
+	var block cipher.Block
+	var err error
+	switch {
+	case algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC):
+		block, err = des.NewTripleDESCipher(pkcs12KDF(sha1.New, password, salt, 1, iterations, 24))
+	case algorithm.Equal(oidPBEWithSHAAnd2KeyTripleDESCBC):
+		key := pkcs12KDF(sha1.New, password, salt, 1, iterations, 16)
+		block, err = des.NewTripleDESCipher(append(key, key[:8]...))
+	case algorithm.Equal(oidPBEWithSHAAnd128BitRC2CBC):
+		block, err = newRC2Cipher(pkcs12KDF(sha1.New, password, salt, 1, iterations, 16), 128)
+	case algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		block, err = newRC2Cipher(pkcs12KDF(sha1.New, password, salt, 1, iterations, 5), 40)
+	default:
+		return nil, nil, errors.New("Unsupported PKCS#12 encryption " + algorithm.String())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, pkcs12KDF(sha1.New, password, salt, 2, iterations, block.BlockSize()), nil
+}
+
+// PKCS#12 key derivation (RFC 7292 appendix B.2), id 1 for keys, 2 for IVs and 3 for MAC keys
+func pkcs12KDF(h func() hash.Hash, password, salt []byte, id byte, iterations, size int) []byte {
+	u := h().Size()
+	v := h().BlockSize()
+
+	fill := func(b []byte) []byte {
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	I := append(fill(salt), fill(password)...)
+	D := bytesOf(id, v)
+
+	var out []byte
+	for len(out) < size {
+		hh := h()
+		hh.Write(D)
+		hh.Write(I)
+		A := hh.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			hh.Reset()
+			hh.Write(A)
+			A = hh.Sum(A[:0])
+		}
+		out = append(out, A...)
+		if len(out) >= size {
+			break
+		}
+		B := make([]byte, v)
+		for i := range B {
+			B[i] = A[i%u]
+		}
+		for j := 0; j < len(I); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				x := int(I[j+k]) + int(B[k]) + carry
+				I[j+k] = byte(x)
+				carry = x >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// Password as a null terminated BMPString (big endian UTF-16)
+func bmpPassword(password string) []byte {
+	var bmp []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		bmp = append(bmp, byte(c>>8), byte(c))
+	}
+	return append(bmp, 0, 0)
+}
+
+////
+// RC2 (RFC 2268), only used to read legacy PKCS#12 bundles
+////
+
+type rc2Cipher struct {
+	k [64]uint16
+}
+
+var rc2PiTable = [256]byte{
+	0xd9, 0x78, 0xf9, 0xc4, 0x19, 0xdd, 0xb5, 0xed, 0x28, 0xe9, 0xfd, 0x79, 0x4a, 0xa0, 0xd8, 0x9d,
+	0xc6, 0x7e, 0x37, 0x83, 0x2b, 0x76, 0x53, 0x8e, 0x62, 0x4c, 0x64, 0x88, 0x44, 0x8b, 0xfb, 0xa2,
+	0x17, 0x9a, 0x59, 0xf5, 0x87, 0xb3, 0x4f, 0x13, 0x61, 0x45, 0x6d, 0x8d, 0x09, 0x81, 0x7d, 0x32,
+	0xbd, 0x8f, 0x40, 0xeb, 0x86, 0xb7, 0x7b, 0x0b, 0xf0, 0x95, 0x21, 0x22, 0x5c, 0x6b, 0x4e, 0x82,
+	0x54, 0xd6, 0x65, 0x93, 0xce, 0x60, 0xb2, 0x1c, 0x73, 0x56, 0xc0, 0x14, 0xa7, 0x8c, 0xf1, 0xdc,
+	0x12, 0x75, 0xca, 0x1f, 0x3b, 0xbe, 0xe4, 0xd1, 0x42, 0x3d, 0xd4, 0x30, 0xa3, 0x3c, 0xb6, 0x26,
+	0x6f, 0xbf, 0x0e, 0xda, 0x46, 0x69, 0x07, 0x57, 0x27, 0xf2, 0x1d, 0x9b, 0xbc, 0x94, 0x43, 0x03,
+	0xf8, 0x11, 0xc7, 0xf6, 0x90, 0xef, 0x3e, 0xe7, 0x06, 0xc3, 0xd5, 0x2f, 0xc8, 0x66, 0x1e, 0xd7,
+	0x08, 0xe8, 0xea, 0xde, 0x80, 0x52, 0xee, 0xf7, 0x84, 0xaa, 0x72, 0xac, 0x35, 0x4d, 0x6a, 0x2a,
+	0x96, 0x1a, 0xd2, 0x71, 0x5a, 0x15, 0x49, 0x74, 0x4b, 0x9f, 0xd0, 0x5e, 0x04, 0x18, 0xa4, 0xec,
+	0xc2, 0xe0, 0x41, 0x6e, 0x0f, 0x51, 0xcb, 0xcc, 0x24, 0x91, 0xaf, 0x50, 0xa1, 0xf4, 0x70, 0x39,
+	0x99, 0x7c, 0x3a, 0x85, 0x23, 0xb8, 0xb4, 0x7a, 0xfc, 0x02, 0x36, 0x5b, 0x25, 0x55, 0x97, 0x31,
+	0x2d, 0x5d, 0xfa, 0x98, 0xe3, 0x8a, 0x92, 0xae, 0x05, 0xdf, 0x29, 0x10, 0x67, 0x6c, 0xba, 0xc9,
+	0xd3, 0x00, 0xe6, 0xcf, 0xe1, 0x9e, 0xa8, 0x2c, 0x63, 0x16, 0x01, 0x3f, 0x58, 0xe2, 0x89, 0xa9,
+	0x0d, 0x38, 0x34, 0x1b, 0xab, 0x33, 0xff, 0xb0, 0xbb, 0x48, 0x0c, 0x5f, 0xb9, 0xb1, 0xcd, 0x2e,
+	0xc5, 0xf3, 0xdb, 0x47, 0xe5, 0xa5, 0x9c, 0x77, 0x0a, 0xa6, 0x20, 0x68, 0xfe, 0x7f, 0xc1, 0xad,
+}
+
+func newRC2Cipher(key []byte, effectiveBits int) (cipher.Block, error) {
+	if len(key) == 0 || len(key) > 128 {
+		return nil, errors.New("Invalid RC2 key size")
+	}
+	var l [128]byte
+	copy(l[:], key)
+	t := len(key)
+	for i := t; i < 128; i++ {
+		l[i] = rc2PiTable[l[i-1]+l[i-t]]
+	}
+	t8 := (effectiveBits + 7) / 8
+	tm := byte(0xff >> uint(8*t8-effectiveBits))
+	l[128-t8] = rc2PiTable[l[128-t8]&tm]
+	for i := 127 - t8; i >= 0; i-- {
+		l[i] = rc2PiTable[l[i+1]^l[i+t8]]
+	}
+	c := new(rc2Cipher)
+	for i := range c.k {
+		c.k[i] = uint16(l[2*i]) | uint16(l[2*i+1])<<8
+	}
+	return c, nil
+}
+
+func (c *rc2Cipher) BlockSize() int { return 8 }
+
+func (c *rc2Cipher) Encrypt(dst, src []byte) {
+	r := [4]uint16{}
+	for i := range r {
+		r[i] = uint16(src[2*i]) | uint16(src[2*i+1])<<8
+	}
+	j := 0
+	for round := 0; round < 16; round++ {
+		for i := 0; i < 4; i++ {
+			r[i] += c.k[j] + (r[(i+3)%4] & r[(i+2)%4]) + (^r[(i+3)%4] & r[(i+1)%4])
+			r[i] = r[i]<<rc2Shifts[i] | r[i]>>(16-rc2Shifts[i])
+			j++
+		}
+		if round == 4 || round == 10 {
+			for i := 0; i < 4; i++ {
+				r[i] += c.k[r[(i+3)%4]&63]
+			}
+		}
+	}
+	for i := range r {
+		dst[2*i], dst[2*i+1] = byte(r[i]), byte(r[i]>>8)
+	}
+}
+
+func (c *rc2Cipher) Decrypt(dst, src []byte) {
+	r := [4]uint16{}
+	for i := range r {
+		r[i] = uint16(src[2*i]) | uint16(src[2*i+1])<<8
+	}
+	j := 63
+	for round := 15; round >= 0; round-- {
+		for i := 3; i >= 0; i-- {
+			r[i] = r[i]>>rc2Shifts[i] | r[i]<<(16-rc2Shifts[i])
+			r[i] -= c.k[j] + (r[(i+3)%4] & r[(i+2)%4]) + (^r[(i+3)%4] & r[(i+1)%4])
+			j--
+		}
+		if round == 5 || round == 11 {
+			for i := 3; i >= 0; i-- {
+				r[i] -= c.k[r[(i+3)%4]&63]
+			}
+		}
+	}
+	for i := range r {
+		dst[2*i], dst[2*i+1] = byte(r[i]), byte(r[i]>>8)
+	}
+}
+
+var rc2Shifts = [4]uint{1, 2, 3, 5}
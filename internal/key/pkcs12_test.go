@@ -0,0 +1,176 @@
+package key
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"hash"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Self-signed certificate of a private key
+func testCertificate(t *testing.T, privatekey crypto.Signer, cn string) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, privatekey.Public(), privatekey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+// Vectors of the OpenSSL and Bouncy Castle PKCS#12 tests, checked with openssl kdf PKCS12KDF
+func TestPKCS12KDF(t *testing.T) {
+	tests := []struct {
+		h          func() hash.Hash
+		password   string
+		salt       string
+		id         byte
+		iterations int
+		expected   string
+	}{
+		{sha1.New, "smeg", "0a58cf64530d823f", 1, 1, "8aaae6297b6cb04642ab5b077851284eb7128f1a2a7fbca3"},
+		{sha1.New, "smeg", "0a58cf64530d823f", 2, 1, "79993dfe048d3b76"},
+		{sha1.New, "queeg", "1682c0fc5b3f7ec5", 1, 1000, "483dd6e919d7de2e8e648ba8f862f3fbfbdc2bcb2c02957f"},
+		{sha1.New, "queeg", "1682c0fc5b3f7ec5", 2, 1000, "9d461d1b00355c50"},
+		{sha1.New, "smeg", "3d83c0e4546ac140", 3, 1, "8d967d88f6caa9d714800ab3d48051d63f73a312"},
+		{sha256.New, "secret", "0102030405060708", 3, 2048, "f5482fd03f702689b4e96cbbea867c6b16e5bda934929f2ecaf4da9e1c67be8f"},
+	}
+	for _, test := range tests {
+		expected := unhex(t, test.expected)
+		got := pkcs12KDF(test.h, bmpPassword(test.password), unhex(t, test.salt), test.id, test.iterations, len(expected))
+		if !bytes.Equal(got, expected) {
+			t.Errorf("KDF(%q, %s, id %d): got %x, expected %x", test.password, test.salt, test.id, got, expected)
+		}
+	}
+}
+
+// RFC 2268 section 5 test vectors
+func TestRC2(t *testing.T) {
+	tests := []struct {
+		key           string
+		effectiveBits int
+		plain         string
+		cipher        string
+	}{
+		{"0000000000000000", 63, "0000000000000000", "ebb773f993278eff"},
+		{"ffffffffffffffff", 64, "ffffffffffffffff", "278b27e42e2f0d49"},
+		{"3000000000000000", 64, "1000000000000001", "30649edf9be7d2c2"},
+		{"88", 64, "0000000000000000", "61a8a244adacccf0"},
+		{"88bca90e90875a", 64, "0000000000000000", "6ccf4308974c267f"},
+		{"88bca90e90875a7f0f79c384627bafb2", 64, "0000000000000000", "1a807d272bbe5db1"},
+		{"88bca90e90875a7f0f79c384627bafb2", 128, "0000000000000000", "2269552ab0f85ca6"},
+	}
+	for _, test := range tests {
+		block, err := newRC2Cipher(unhex(t, test.key), test.effectiveBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, expected := unhex(t, test.plain), unhex(t, test.cipher)
+		got := make([]byte, 8)
+		block.Encrypt(got, plain)
+		if !bytes.Equal(got, expected) {
+			t.Errorf("RC2 %s/%d: got %x, expected %x", test.key, test.effectiveBits, got, expected)
+		}
+		block.Decrypt(got, expected)
+		if !bytes.Equal(got, plain) {
+			t.Errorf("RC2 %s/%d decryption: got %x, expected %x", test.key, test.effectiveBits, got, plain)
+		}
+	}
+}
+
+// Bundles of key.pem and cert.pem written by openssl pkcs12 -export with password "secret":
+// legacy.p12 with -legacy (RC2-40 certificates, 3DES key, HMAC-SHA1), aes.p12 with OpenSSL 3 defaults
+func TestDecodePKCS12OpenSSL(t *testing.T) {
+	expectedKey := readPEM(t, "key.pem")
+	expectedCert := readPEM(t, "cert.pem")
+	for _, name := range []string{"legacy.p12", "aes.p12"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsPKCS12(data) {
+			t.Errorf("%s: not detected as a PKCS#12 bundle", name)
+		}
+		privatekey, certs, err := DecodePKCS12(data, "secret")
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if der, err := x509.MarshalPKCS8PrivateKey(privatekey); err != nil || !bytes.Equal(der, expectedKey) {
+			t.Errorf("%s: got a different private key", name)
+		}
+		if len(certs) != 1 || !bytes.Equal(certs[0].Raw, expectedCert) {
+			t.Errorf("%s: got %d certificates, expected cert.pem", name, len(certs))
+		}
+		if _, _, err := DecodePKCS12(data, "wrong"); err == nil {
+			t.Errorf("%s: wrong password: got no error", name)
+		}
+	}
+}
+
+func TestEncodePKCS12RoundTrip(t *testing.T) {
+	curve, _ := ParseCurve("P256")
+	ca, err := GenerateKey("ecdsa", 0, curve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert := testCertificate(t, ca, "ca")
+	for _, keyType := range []string{"rsa", "ecdsa", "ed25519"} {
+		privatekey, err := GenerateKey(keyType, 2048, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crt := testCertificate(t, privatekey, keyType)
+		for _, legacy := range []bool{false, true} {
+			name := keyType
+			if legacy {
+				name += " legacy"
+			}
+			data, err := EncodePKCS12(privatekey, []*x509.Certificate{crt, caCert}, "secret", "alias", legacy)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			decoded, certs, err := DecodePKCS12(data, "secret")
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			if k, ok := decoded.(interface{ Equal(crypto.PrivateKey) bool }); !ok || !k.Equal(privatekey) {
+				t.Errorf("%s: got a different private key", name)
+			}
+			if len(certs) != 2 || !certs[0].Equal(crt) || !certs[1].Equal(caCert) {
+				t.Errorf("%s: got %d certificates, expected the certificate and its chain", name, len(certs))
+			}
+			if _, _, err := DecodePKCS12(data, "wrong"); err == nil {
+				t.Errorf("%s: wrong password: got no error", name)
+			}
+		}
+	}
+
+	// Trust store without private key and with an empty password
+	data, err := EncodePKCS12(nil, []*x509.Certificate{caCert}, "", "ca", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privatekey, certs, err := DecodePKCS12(data, ""); err != nil || privatekey != nil || len(certs) != 1 || !certs[0].Equal(caCert) {
+		t.Errorf("certificates only: got %v, %d certificates, %v", privatekey, len(certs), err)
+	}
+}
@@ -0,0 +1,463 @@
+package key
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// Encrypted PKCS#8 private keys (RFC 5958) with PBES2 (RFC 8018):
+// PBKDF2-HMAC-SHA256 or scrypt (RFC 7914) key derivation, AES-256-CBC or AES-256-GCM encryption
+
+////
+// Types
+////
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+////
+// Variables & Constants
+////
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES256GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+)
+
+const (
+	pbkdf2Iterations = 100000
+	scryptN          = 1 << 14 // OpenSSL default, within its 32 MB scrypt memory limit
+	scryptR          = 8
+	scryptP          = 1
+)
+
+// Key derivation functions and ciphers of encrypted private keys
+var (
+	KDFs    = []string{"pbkdf2", "scrypt"}
+	Ciphers = []string{"aes-256-cbc", "aes-256-gcm"}
+
+	DefaultKDF    = "pbkdf2"
+	DefaultCipher = "aes-256-cbc"
+
+	errDecrypt = errors.New("Can not decrypt private key with passphrase")
+)
+
+////
+// Encryption
+////
+
+// Encrypt a private key of any type in an ENCRYPTED PRIVATE KEY PEM block (PKCS#8, PBES2)
+func EncryptPKCS8PrivateKey(privatekey any, passphrase, kdf, cipherName string) (*pem.Block, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privatekey)
+	if err != nil {
+		return nil, errors.New("Can not convert private key to PKCS#8: " + err.Error())
+	}
+	algorithm, encrypted, err := encryptPBES2(der, passphrase, kdf, cipherName)
+	if err != nil {
+		return nil, err
+	}
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: algorithm, EncryptedData: encrypted})
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: info}, nil
+}
+
+// Decrypt an encrypted PKCS#8 private key, returns the PKCS#8 private key
+func DecryptPKCS8PrivateKey(der []byte, passphrase string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, errors.New("Can not decode encrypted private key")
+	}
+	return decryptPBE(info.Algorithm, info.EncryptedData, passphrase)
+}
+
+// Decrypt data encrypted with PBES2 or a PKCS#12 password based encryption scheme
+func decryptPBE(algorithm pkix.AlgorithmIdentifier, data []byte, passphrase string) ([]byte, error) {
+	if algorithm.Algorithm.Equal(oidPBES2) {
+		return decryptPBES2(algorithm.Parameters, data, passphrase)
+	}
+	if isPKCS12PBE(algorithm.Algorithm) {
+		return decryptPKCS12PBE(algorithm, data, passphrase)
+	}
+	return nil, errors.New("Unsupported private key encryption " + algorithm.Algorithm.String())
+}
+
+// Encrypt data with PBES2, returns the encryption algorithm and the encrypted data
+func encryptPBES2(data []byte, passphrase, kdf, cipherName string) (pkix.AlgorithmIdentifier, []byte, error) {
+	var algorithm pkix.AlgorithmIdentifier
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return algorithm, nil, err
+	}
+
+	var kdfAlgorithm pkix.AlgorithmIdentifier
+	var key []byte
+	switch kdf {
+	case "pbkdf2":
+		params, err := asn1.Marshal(pbkdf2Params{
+			Salt:           salt,
+			IterationCount: pbkdf2Iterations,
+			PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return algorithm, nil, err
+		}
+		kdfAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: params}}
+		key = pbkdf2Key([]byte(passphrase), salt, pbkdf2Iterations, 32, sha256.New)
+	case "scrypt":
+		params, err := asn1.Marshal(scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP, KeyLength: 32})
+		if err != nil {
+			return algorithm, nil, err
+		}
+		kdfAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: params}}
+		if key, err = scryptKey([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32); err != nil {
+			return algorithm, nil, err
+		}
+	default:
+		return algorithm, nil, errors.New("Unknown key derivation function " + kdf)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return algorithm, nil, err
+	}
+	var encAlgorithm pkix.AlgorithmIdentifier
+	var encrypted []byte
+	switch cipherName {
+	case "aes-256-cbc":
+		iv := make([]byte, aes.BlockSize)
+		if _, err = rand.Read(iv); err != nil {
+			return algorithm, nil, err
+		}
+		params, err := asn1.Marshal(iv)
+		if err != nil {
+			return algorithm, nil, err
+		}
+		encAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: params}}
+		padding := aes.BlockSize - len(data)%aes.BlockSize
+		encrypted = append(append([]byte{}, data...), bytesOf(byte(padding), padding)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	case "aes-256-gcm":
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return algorithm, nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return algorithm, nil, err
+		}
+		params, err := asn1.Marshal(gcmParams{Nonce: nonce, ICVLen: aead.Overhead()})
+		if err != nil {
+			return algorithm, nil, err
+		}
+		encAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidAES256GCM, Parameters: asn1.RawValue{FullBytes: params}}
+		encrypted = aead.Seal(nil, nonce, data, nil)
+	default:
+		return algorithm, nil, errors.New("Unknown cipher " + cipherName)
+	}
+
+	params, err := asn1.Marshal(pbes2Params{KeyDerivationFunc: kdfAlgorithm, EncryptionScheme: encAlgorithm})
+	if err != nil {
+		return algorithm, nil, err
+	}
+	algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+	return algorithm, encrypted, nil
+}
+
+// Decrypt data encrypted with PBES2
+func decryptPBES2(parameters asn1.RawValue, data []byte, passphrase string) ([]byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(parameters.FullBytes, &params); err != nil {
+		return nil, errors.New("Can not decode private key encryption parameters")
+	}
+
+	// AES-128/192-CBC are only supported for keys encrypted by other tools
+	keyLen := 32
+	switch enc := params.EncryptionScheme.Algorithm; {
+	case enc.Equal(oidAES128CBC):
+		keyLen = 16
+	case enc.Equal(oidAES192CBC):
+		keyLen = 24
+	}
+
+	var key []byte
+	switch kdf := params.KeyDerivationFunc; {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var p pbkdf2Params
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &p); err != nil {
+			return nil, errors.New("Can not decode PBKDF2 parameters")
+		}
+		h := sha1.New
+		switch {
+		case len(p.PRF.Algorithm) == 0 || p.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		case p.PRF.Algorithm.Equal(oidHMACWithSHA256):
+			h = sha256.New
+		case p.PRF.Algorithm.Equal(oidHMACWithSHA384):
+			h = sha512.New384
+		case p.PRF.Algorithm.Equal(oidHMACWithSHA512):
+			h = sha512.New
+		default:
+			return nil, errors.New("Unsupported PBKDF2 function " + p.PRF.Algorithm.String())
+		}
+		if p.IterationCount < 1 {
+			return nil, errors.New("Invalid PBKDF2 iteration count")
+		}
+		key = pbkdf2Key([]byte(passphrase), p.Salt, p.IterationCount, keyLen, h)
+	case kdf.Algorithm.Equal(oidScrypt):
+		var p scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &p); err != nil {
+			return nil, errors.New("Can not decode scrypt parameters")
+		}
+		var err error
+		if key, err = scryptKey([]byte(passphrase), p.Salt, p.CostParameter, p.BlockSize, p.ParallelizationParameter, keyLen); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Unsupported key derivation function " + kdf.Algorithm.String())
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	switch enc := params.EncryptionScheme; {
+	case enc.Algorithm.Equal(oidAES128CBC), enc.Algorithm.Equal(oidAES192CBC), enc.Algorithm.Equal(oidAES256CBC):
+		var iv []byte
+		if _, err := asn1.Unmarshal(enc.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+			return nil, errors.New("Can not decode AES-CBC parameters")
+		}
+		return cbcDecrypt(block, iv, data)
+	case enc.Algorithm.Equal(oidAES256GCM):
+		var p gcmParams
+		if _, err := asn1.Unmarshal(enc.Parameters.FullBytes, &p); err != nil {
+			return nil, errors.New("Can not decode AES-GCM parameters")
+		}
+		aead, err := cipher.NewGCMWithNonceSize(block, len(p.Nonce))
+		if err != nil {
+			return nil, err
+		}
+		if p.ICVLen != aead.Overhead() {
+			return nil, errors.New("Unsupported AES-GCM tag length")
+		}
+		plain, err := aead.Open(nil, p.Nonce, data, nil)
+		if err != nil {
+			return nil, errDecrypt
+		}
+		return plain, nil
+	default:
+		return nil, errors.New("Unsupported private key cipher " + enc.Algorithm.String())
+	}
+}
+
+// Decrypt CBC encrypted data and remove the PKCS#7 padding
+func cbcDecrypt(block cipher.Block, iv, data []byte) ([]byte, error) {
+	size := block.BlockSize()
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, errDecrypt
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > size {
+		return nil, errDecrypt
+	}
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return nil, errDecrypt
+		}
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+////
+// Key derivation
+////
+
+// PBKDF2 (RFC 8018 section 5.2)
+func pbkdf2Key(password, salt []byte, iterations, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+	key := make([]byte, 0, (keyLen+size-1)/size*size)
+	buf := make([]byte, 4)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, block)
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// scrypt (RFC 7914), N must be a power of 2
+func scryptKey(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 {
+		return nil, errors.New("scrypt cost parameter must be a power of 2")
+	}
+	if r < 1 || p < 1 || uint64(r)*uint64(p) >= 1<<30 || r > 1<<24/N || r > 1<<24/p {
+		return nil, errors.New("scrypt parameters are too large")
+	}
+	b := pbkdf2Key(password, salt, 1, p*128*r, sha256.New)
+	v := make([]uint32, 32*r*N)
+	xy := make([]uint32, 64*r)
+	for i := 0; i < p; i++ {
+		scryptSMix(b[i*128*r:], r, N, v, xy)
+	}
+	return pbkdf2Key(password, b, 1, keyLen, sha256.New), nil
+}
+
+func scryptSMix(b []byte, r, N int, v, xy []uint32) {
+	var tmp [16]uint32
+	R := 32 * r
+	x := xy
+	y := xy[R:]
+	for i := 0; i < R; i++ {
+		x[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	for i := 0; i < N; i += 2 {
+		copy(v[i*R:], x)
+		scryptBlockMix(&tmp, x, y, r)
+		copy(v[(i+1)*R:], y)
+		scryptBlockMix(&tmp, y, x, r)
+	}
+	for i := 0; i < N; i += 2 {
+		j := int(x[(2*r-1)*16] & uint32(N-1))
+		for k := 0; k < R; k++ {
+			x[k] ^= v[j*R+k]
+		}
+		scryptBlockMix(&tmp, x, y, r)
+		j = int(y[(2*r-1)*16] & uint32(N-1))
+		for k := 0; k < R; k++ {
+			y[k] ^= v[j*R+k]
+		}
+		scryptBlockMix(&tmp, y, x, r)
+	}
+	for i := 0; i < R; i++ {
+		binary.LittleEndian.PutUint32(b[i*4:], x[i])
+	}
+}
+
+func scryptBlockMix(tmp *[16]uint32, in, out []uint32, r int) {
+	copy(tmp[:], in[(2*r-1)*16:])
+	for i := 0; i < 2*r; i += 2 {
+		salsa208(tmp, in[i*16:])
+		copy(out[i*8:], tmp[:])
+		salsa208(tmp, in[i*16+16:])
+		copy(out[i*8+r*16:], tmp[:])
+	}
+}
+
+// XOR a block into the state and apply the Salsa20/8 core
+func salsa208(b *[16]uint32, in []uint32) {
+	var w, x [16]uint32
+	for i := range w {
+		w[i] = b[i] ^ in[i]
+	}
+	x = w
+	for i := 0; i < 8; i += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range b {
+		b[i] = x[i] + w[i]
+	}
+}
+
+func bytesOf(b byte, n int) []byte {
+	s := make([]byte, n)
+	for i := range s {
+		s[i] = b
+	}
+	return s
+}
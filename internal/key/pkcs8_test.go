@@ -0,0 +1,137 @@
+package key
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// DER content of the first PEM block of a test file
+func readPEM(t *testing.T, name string) []byte {
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		t.Fatalf("%s: no PEM block", name)
+	}
+	return block.Bytes
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 6070 (HMAC-SHA1) and RFC 7914 section 11 (HMAC-SHA256) test vectors
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		h          func() hash.Hash
+		password   string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		{sha1.New, "password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{sha1.New, "password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{sha256.New, "passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{sha256.New, "Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, test := range tests {
+		expected := unhex(t, test.expected)
+		if got := pbkdf2Key([]byte(test.password), []byte(test.salt), test.iterations, len(expected), test.h); !bytes.Equal(got, expected) {
+			t.Errorf("PBKDF2(%q, %q, %d): got %x, expected %x", test.password, test.salt, test.iterations, got, expected)
+		}
+	}
+}
+
+// RFC 7914 section 12 test vectors
+func TestScrypt(t *testing.T) {
+	tests := []struct {
+		password string
+		salt     string
+		N, r, p  int
+		expected string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1, "7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"},
+	}
+	for _, test := range tests {
+		expected := unhex(t, test.expected)
+		got, err := scryptKey([]byte(test.password), []byte(test.salt), test.N, test.r, test.p, len(expected))
+		if err != nil {
+			t.Errorf("scrypt(%q, %q): %s", test.password, test.salt, err)
+		} else if !bytes.Equal(got, expected) {
+			t.Errorf("scrypt(%q, %q): got %x, expected %x", test.password, test.salt, got, expected)
+		}
+	}
+
+	if _, err := scryptKey([]byte("password"), []byte("salt"), 1000, 8, 1, 32); err == nil {
+		t.Errorf("scrypt with N not a power of 2: got no error")
+	}
+}
+
+// Keys encrypted by openssl pkcs8 -topk8 (-v2 aes-256-cbc -v2prf hmacWithSHA256,
+// -v2 aes-128-cbc -v2prf hmacWithSHA1 and -scrypt) with passphrase "secret"
+func TestDecryptPKCS8OpenSSL(t *testing.T) {
+	expected := readPEM(t, "key.pem")
+	for _, name := range []string{"pkcs8-pbkdf2-sha256-aes256cbc.pem", "pkcs8-pbkdf2-sha1-aes128cbc.pem", "pkcs8-scrypt-aes256cbc.pem"} {
+		der := readPEM(t, name)
+		if got, err := DecryptPKCS8PrivateKey(der, "secret"); err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if !bytes.Equal(got, expected) {
+			t.Errorf("%s: got %x, expected %x", name, got, expected)
+		}
+		if _, err := DecryptPKCS8PrivateKey(der, "wrong"); err == nil {
+			t.Errorf("%s: wrong passphrase: got no error", name)
+		}
+	}
+}
+
+func TestEncryptPKCS8RoundTrip(t *testing.T) {
+	curve, _ := ParseCurve("P256")
+	for _, keyType := range []string{"rsa", "ecdsa", "ed25519"} {
+		privateKey, err := GenerateKey(keyType, 2048, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kdf := range KDFs {
+			for _, cipherName := range Ciphers {
+				name := keyType + " " + kdf + " " + cipherName
+				block, err := EncryptPKCS8PrivateKey(privateKey, "secret", kdf, cipherName)
+				if err != nil {
+					t.Errorf("%s: %s", name, err)
+					continue
+				}
+				decrypted, err := LoadPrivateKey(pem.EncodeToMemory(block), "secret")
+				if err != nil {
+					t.Errorf("%s: %s", name, err)
+				} else if k, ok := decrypted.(interface{ Equal(crypto.PrivateKey) bool }); !ok || !k.Equal(privateKey) {
+					t.Errorf("%s: got a different key", name)
+				}
+				if _, err := LoadPrivateKey(pem.EncodeToMemory(block), "wrong"); err == nil {
+					t.Errorf("%s: wrong passphrase: got no error", name)
+				}
+			}
+		}
+	}
+
+	if _, _, err := encryptPBES2([]byte("data"), "secret", "bcrypt", DefaultCipher); err == nil {
+		t.Errorf("unknown key derivation function: got no error")
+	}
+	if _, _, err := encryptPBES2([]byte("data"), "secret", DefaultKDF, "des-cbc"); err == nil {
+		t.Errorf("unknown cipher: got no error")
+	}
+}
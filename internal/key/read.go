@@ -2,23 +2,27 @@ package key
 
 import (
 	"bufio"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"simpleca/internal/info"
 	"simpleca/tools"
+	"strings"
 )
 
 func ReadUsage() {
 	fmt.Println(`
 Usage:  simpleca key read [OPTIONS] FILENAME
 
-Read a key
+Read a key (PEM file or PKCS#12 bundle)
 
 Options:`)
 	f.PrintDefaults()
@@ -32,7 +36,8 @@ var (
 
 func Read(args []string) {
 
-	passphrase := f.String("passphrase", "", "Private key passphrase")
+	passphrase := f.String("passphrase", "", "Private key passphrase (password of PKCS#12 bundles)")
+	format := f.String("format", "text", "Output format ("+strings.Join(info.Formats, ", ")+"), json and yaml describe the public key only")
 
 	f.SetUsage(ReadUsage)
 	f.Parse(args[1:])
@@ -40,6 +45,10 @@ func Read(args []string) {
 		ReadUsage()
 	} else {
 		filename := f.Arg(0)
+		if err := info.CheckFormat(*format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 
 		if filename != "-" {
 			if b, _ := tools.Exists(filename); !b {
@@ -47,7 +56,19 @@ func Read(args []string) {
 				os.Exit(1)
 			}
 		}
-		if key, err := LoadPrivateKeyFile(filename, *passphrase); err == nil {
+		if key, err := LoadPrivateKeyFile(filename, *passphrase); err == nil && *format != "text" {
+			k, err := info.FromKey(PublicKey(key))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if bytes, err := info.Marshal(k, *format); err == nil {
+				os.Stdout.Write(bytes)
+			} else {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		} else if err == nil {
 			switch key.(type) {
 			case *rsa.PrivateKey:
 				if bytes, err := EncodeRSAPrivateKeyToPEM(key.(*rsa.PrivateKey), ""); err == nil {
@@ -61,9 +82,12 @@ func Read(args []string) {
 				} else {
 					fmt.Fprintln(os.Stderr, err)
 				}
-			case *ed25519.PrivateKey:
-				fmt.Fprintln(os.Stderr, "ED25519 private key not available")
-				os.Exit(1)
+			case ed25519.PrivateKey:
+				if block, err := ConvertEd25519KeyToBlock(key.(ed25519.PrivateKey), ""); err == nil {
+					fmt.Println(string(pem.EncodeToMemory(block)))
+				} else {
+					fmt.Fprintln(os.Stderr, err)
+				}
 			default:
 				fmt.Fprintln(os.Stderr, "Unknown private key type")
 				os.Exit(1)
@@ -98,6 +122,22 @@ func LoadPrivateKeyStream(file *os.File, passphrase string) (any, error) {
 }
 
 func LoadPrivateKey(bytes []byte, passphrase string) (any, error) {
+	if block, _ := pem.Decode(bytes); block == nil {
+		// DER (or base64 without PEM headers) private key or PKCS#12 bundle
+		if der, ok := tools.DecodeBase64(bytes); ok {
+			bytes = der
+		}
+		if IsPKCS12(bytes) {
+			if key, _, err := DecodePKCS12(bytes, passphrase); err != nil {
+				return nil, err
+			} else if key == nil {
+				return nil, errors.New("No private key in PKCS#12 bundle")
+			} else {
+				return key, nil
+			}
+		}
+		return ParsePrivateKeyDER(bytes, passphrase)
+	}
 	if key, err := LoadRSAKey(bytes, passphrase); err == nil {
 		return key, nil
 	} else if err == ECDSA {
@@ -107,12 +147,36 @@ func LoadPrivateKey(bytes []byte, passphrase string) (any, error) {
 			return nil, err
 		}
 	} else if err == ED25519 {
-		return nil, err
+		return LoadEd25519Key(bytes, passphrase)
 	} else {
 		return nil, err
 	}
 }
 
+// Parse a DER private key: PKCS#8 (encrypted or not), PKCS#1 (RSA) or SEC 1 (ECDSA)
+func ParsePrivateKeyDER(der []byte, passphrase string) (any, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err == nil && len(rest) == 0 {
+		decrypted, err := DecryptPKCS8PrivateKey(der, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if key, err := x509.ParsePKCS8PrivateKey(decrypted); err == nil {
+			return key, nil
+		}
+	}
+	return nil, errors.New("Can not decode private key")
+}
+
 func LoadECDSAKeyFile(filename, passphrase string) (*ecdsa.PrivateKey, error) {
 	if filename == "-" {
 		return LoadECDSAKeyStream(os.Stdin, passphrase)
@@ -137,17 +201,29 @@ func LoadECDSAKeyStream(file *os.File, passphrase string) (*ecdsa.PrivateKey, er
 
 func LoadECDSAKey(bytes []byte, passphrase string) (*ecdsa.PrivateKey, error) {
 	var key *ecdsa.PrivateKey = nil
-	var err error
 	block, _ := pem.Decode(bytes)
 	if block == nil {
 		return key, errors.New("Can not decode private key")
 	} else {
+		privateKeyBytes, err := decryptBlock(block, passphrase)
+		if err != nil {
+			return key, err
+		}
 		switch block.Type {
 		case "EC PRIVATE KEY":
-			if key, err = x509.ParseECPrivateKey(block.Bytes); err == nil {
+			if key, err = x509.ParseECPrivateKey(privateKeyBytes); err == nil {
 			} else {
 				return key, errors.New("Can not decode private key")
 			}
+		case "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+			mkey, err := x509.ParsePKCS8PrivateKey(privateKeyBytes)
+			if err != nil {
+				return key, errors.New("Can not decode private key")
+			}
+			var ok bool
+			if key, ok = mkey.(*ecdsa.PrivateKey); !ok {
+				return nil, errors.New("Private key is not an ECDSA key")
+			}
 		default:
 			return key, errors.New("Unknown private key type")
 		}
@@ -155,6 +231,54 @@ func LoadECDSAKey(bytes []byte, passphrase string) (*ecdsa.PrivateKey, error) {
 	return key, nil
 }
 
+func LoadEd25519Key(bytes []byte, passphrase string) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(bytes)
+	if block == nil || (block.Type != "PRIVATE KEY" && block.Type != "ENCRYPTED PRIVATE KEY") {
+		return nil, errors.New("Can not decode private key")
+	}
+	privateKeyBytes, err := decryptBlock(block, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	mkey, err := x509.ParsePKCS8PrivateKey(privateKeyBytes)
+	if err != nil {
+		return nil, errors.New("Can not decode private key")
+	}
+	key, ok := mkey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("Private key is not an Ed25519 key")
+	}
+	return key, nil
+}
+
+// Load a private key able to sign (RSA, ECDSA or Ed25519), e.g. a certificate authority key
+func LoadSignerFile(filename, passphrase string) (crypto.Signer, error) {
+	mkey, err := LoadPrivateKeyFile(filename, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := mkey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("Private key " + filename + " can not sign")
+	}
+	return signer, nil
+}
+
+// Decrypt a PEM block encrypted with a passphrase (encrypted PKCS#8 or legacy OpenSSL encryption)
+func decryptBlock(block *pem.Block, passphrase string) ([]byte, error) {
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return DecryptPKCS8PrivateKey(block.Bytes, passphrase)
+	}
+	if !x509.IsEncryptedPEMBlock(block) {
+		return block.Bytes, nil
+	}
+	privateKeyBytes, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+	if err != nil {
+		return nil, errors.New("Can not decrypt private key with passphrase")
+	}
+	return privateKeyBytes, nil
+}
+
 func LoadRSAKeyFile(filename, passphrase string) (*rsa.PrivateKey, error) {
 	if filename == "-" {
 		return LoadRSAKeyStream(os.Stdin, passphrase)
@@ -186,15 +310,18 @@ func LoadRSAKey(bytes []byte, passphrase string) (*rsa.PrivateKey, error) {
 	} else {
 		var privateKeyBytes []byte
 		switch block.Type {
-		case "PRIVATE KEY":
-			if mkey, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		case "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+			if privateKeyBytes, err = decryptBlock(block, passphrase); err != nil {
+				return key, err
+			}
+			if mkey, err := x509.ParsePKCS8PrivateKey(privateKeyBytes); err == nil {
 				switch mkey.(type) {
 				case *rsa.PrivateKey:
 					return mkey.(*rsa.PrivateKey), nil
 				case *ecdsa.PrivateKey:
 					return nil, ECDSA
-				case *ed25519.PrivateKey:
-					return nil, errors.New("ED25519")
+				case ed25519.PrivateKey:
+					return nil, ED25519
 				default:
 					return nil, errors.New("Unknown private key type")
 				}
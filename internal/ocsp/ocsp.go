@@ -0,0 +1,486 @@
+// Package ocsp implements the subset of the Online Certificate Status Protocol
+// (RFC 6960) needed by the certificate authority responder.
+package ocsp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// Certificate statuses
+const (
+	Good    = 0
+	Revoked = 1
+	Unknown = 2
+)
+
+// Response statuses
+const (
+	Successful       = 0
+	MalformedRequest = 1
+	InternalError    = 2
+	TryLater         = 3
+	SigRequired      = 5
+	Unauthorized     = 6
+)
+
+var (
+	oidBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidNonce         = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidSignatureSHA1WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSignatureSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSignatureSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSignatureSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidSignatureECDSAWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidSignatureECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSignatureECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidSignatureECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidSignatureEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+var hashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   oidSHA1,
+	crypto.SHA256: oidSHA256,
+	crypto.SHA384: oidSHA384,
+	crypto.SHA512: oidSHA512,
+}
+
+var signatureAlgorithms = []struct {
+	oid       asn1.ObjectIdentifier
+	algorithm x509.SignatureAlgorithm
+}{
+	{oidSignatureSHA1WithRSA, x509.SHA1WithRSA},
+	{oidSignatureSHA256WithRSA, x509.SHA256WithRSA},
+	{oidSignatureSHA384WithRSA, x509.SHA384WithRSA},
+	{oidSignatureSHA512WithRSA, x509.SHA512WithRSA},
+	{oidSignatureECDSAWithSHA1, x509.ECDSAWithSHA1},
+	{oidSignatureECDSAWithSHA256, x509.ECDSAWithSHA256},
+	{oidSignatureECDSAWithSHA384, x509.ECDSAWithSHA384},
+	{oidSignatureECDSAWithSHA512, x509.ECDSAWithSHA512},
+	{oidSignatureEd25519, x509.PureEd25519},
+}
+
+var responseStatuses = map[int]string{
+	MalformedRequest: "malformed request",
+	InternalError:    "internal error",
+	TryLater:         "try later",
+	SigRequired:      "signature required",
+	Unauthorized:     "unauthorized",
+}
+
+////
+// ASN.1 structures
+////
+
+type certID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type request struct {
+	Cert       certID
+	Extensions []pkix.Extension `asn1:"explicit,tag:0,optional"`
+}
+
+type tbsRequest struct {
+	Version       int              `asn1:"explicit,tag:0,default:0,optional"`
+	RequestorName asn1.RawValue    `asn1:"explicit,tag:1,optional"`
+	RequestList   []request        `asn1:""`
+	Extensions    []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+type ocspRequest struct {
+	TBSRequest tbsRequest
+	Signature  asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type singleResponse struct {
+	CertID           certID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          revokedInfo      `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type responseData struct {
+	Raw                asn1.RawContent
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID     asn1.RawValue
+	ProducedAt         time.Time        `asn1:"generalized"`
+	Responses          []singleResponse `asn1:""`
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type basicResponse struct {
+	TBSResponseData    responseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+////
+// Requests
+////
+
+// Request is a certificate status request
+type Request struct {
+	HashAlgorithm  crypto.Hash
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+	Nonce          []byte
+
+	id certID
+}
+
+// Parse a DER encoded OCSP request (only the first requested certificate is handled)
+func ParseRequest(der []byte) (*Request, error) {
+	var req ocspRequest
+	rest, err := asn1.Unmarshal(der, &req)
+	if err != nil {
+		return nil, errors.New("Can not parse OCSP request: " + err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("Trailing data in OCSP request")
+	}
+	if len(req.TBSRequest.RequestList) == 0 {
+		return nil, errors.New("OCSP request contains no certificate")
+	}
+	id := req.TBSRequest.RequestList[0].Cert
+	r := &Request{
+		HashAlgorithm:  hashFromOID(id.HashAlgorithm.Algorithm),
+		IssuerNameHash: id.IssuerNameHash,
+		IssuerKeyHash:  id.IssuerKeyHash,
+		SerialNumber:   id.SerialNumber,
+		id:             id,
+	}
+	if r.HashAlgorithm == 0 {
+		return nil, errors.New("Unsupported OCSP request hash algorithm")
+	}
+	for _, ext := range req.TBSRequest.Extensions {
+		if ext.Id.Equal(oidNonce) {
+			r.Nonce = ext.Value
+		}
+	}
+	return r, nil
+}
+
+// Create a DER encoded OCSP request for a certificate
+func CreateRequest(crt, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newCertID(crypto.SHA1, issuer, crt.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{
+		TBSRequest: tbsRequest{
+			RequestList: []request{{Cert: id}},
+		},
+	})
+}
+
+// Check the request is about a certificate issued by the issuer
+func (r *Request) Matches(issuer *x509.Certificate) bool {
+	id, err := newCertID(r.HashAlgorithm, issuer, r.SerialNumber)
+	if err != nil {
+		return false
+	}
+	return string(id.IssuerNameHash) == string(r.IssuerNameHash) && string(id.IssuerKeyHash) == string(r.IssuerKeyHash)
+}
+
+////
+// Responses
+////
+
+// Response is a certificate status response
+type Response struct {
+	Status           int
+	SerialNumber     *big.Int
+	ProducedAt       time.Time
+	ThisUpdate       time.Time
+	NextUpdate       time.Time
+	RevokedAt        time.Time
+	RevocationReason int
+
+	// Delegated OCSP signing certificate (nil when signed by the issuer)
+	Certificate *x509.Certificate
+}
+
+// Create an OCSP error response (malformed request, internal error, unauthorized...)
+func ErrorResponse(status int) []byte {
+	der, _ := asn1.Marshal(struct {
+		Status asn1.Enumerated
+	}{asn1.Enumerated(status)})
+	return der
+}
+
+// Create and sign a DER encoded OCSP response to a request
+// The responder certificate is either the issuer itself or a delegated OCSP signing certificate.
+func CreateResponse(issuer, responder *x509.Certificate, req *Request, tmpl Response, signer crypto.Signer) ([]byte, error) {
+	single := singleResponse{
+		CertID:     req.id,
+		ThisUpdate: tmpl.ThisUpdate.UTC(),
+		NextUpdate: tmpl.NextUpdate.UTC(),
+	}
+	switch tmpl.Status {
+	case Good:
+		single.Good = true
+	case Revoked:
+		single.Revoked = revokedInfo{
+			RevocationTime: tmpl.RevokedAt.UTC(),
+			Reason:         asn1.Enumerated(tmpl.RevocationReason),
+		}
+	case Unknown:
+		single.Unknown = true
+	default:
+		return nil, errors.New("Unknown OCSP certificate status")
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(responder.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, errors.New("Can not parse responder public key: " + err.Error())
+	}
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	responderID, err := asn1.Marshal(keyHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	producedAt := tmpl.ProducedAt
+	if producedAt.IsZero() {
+		producedAt = time.Now()
+	}
+	data := responseData{
+		RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: responderID},
+		ProducedAt:     producedAt.UTC().Truncate(time.Second),
+		Responses:      []singleResponse{single},
+	}
+	if len(req.Nonce) > 0 {
+		data.ResponseExtensions = []pkix.Extension{{Id: oidNonce, Value: req.Nonce}}
+	}
+	tbs, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, hash, err := signingParams(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	digest := tbs
+	if hash != 0 {
+		h := hash.New()
+		h.Write(tbs)
+		digest = h.Sum(nil)
+	}
+	signature, err := signer.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, errors.New("Can not sign OCSP response: " + err.Error())
+	}
+
+	basic := basicResponse{
+		TBSResponseData:    responseData{Raw: tbs},
+		SignatureAlgorithm: algorithm,
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	}
+	if !responder.Equal(issuer) {
+		basic.Certificates = []asn1.RawValue{{FullBytes: responder.Raw}}
+	}
+	basicBytes, err := asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspResponse{
+		Status: asn1.Enumerated(Successful),
+		Response: responseBytes{
+			ResponseType: oidBasicResponse,
+			Response:     basicBytes,
+		},
+	})
+}
+
+// Parse a DER encoded OCSP response (only the first certificate status is handled)
+// The signature is checked when the issuer is given: the response must be signed by the issuer
+// or by a delegated OCSP signing certificate issued by the issuer.
+func ParseResponse(der []byte, issuer *x509.Certificate) (*Response, error) {
+	var resp ocspResponse
+	rest, err := asn1.Unmarshal(der, &resp)
+	if err != nil {
+		return nil, errors.New("Can not parse OCSP response: " + err.Error())
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("Trailing data in OCSP response")
+	}
+	if status := int(resp.Status); status != Successful {
+		if name, found := responseStatuses[status]; found {
+			return nil, errors.New("OCSP responder error: " + name)
+		}
+		return nil, errors.New("OCSP responder error " + strconv.Itoa(status))
+	}
+	if !resp.Response.ResponseType.Equal(oidBasicResponse) {
+		return nil, errors.New("Unsupported OCSP response type " + resp.Response.ResponseType.String())
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, errors.New("Can not parse OCSP basic response: " + err.Error())
+	}
+	if len(basic.TBSResponseData.Responses) == 0 {
+		return nil, errors.New("OCSP response contains no certificate status")
+	}
+
+	single := basic.TBSResponseData.Responses[0]
+	r := &Response{
+		SerialNumber: single.CertID.SerialNumber,
+		ProducedAt:   basic.TBSResponseData.ProducedAt,
+		ThisUpdate:   single.ThisUpdate,
+		NextUpdate:   single.NextUpdate,
+	}
+	switch {
+	case bool(single.Good):
+		r.Status = Good
+	case bool(single.Unknown):
+		r.Status = Unknown
+	case !single.Revoked.RevocationTime.IsZero():
+		r.Status = Revoked
+		r.RevokedAt = single.Revoked.RevocationTime
+		r.RevocationReason = int(single.Revoked.Reason)
+	default:
+		return nil, errors.New("OCSP response contains no certificate status")
+	}
+	if len(basic.Certificates) > 0 {
+		if r.Certificate, err = x509.ParseCertificate(basic.Certificates[0].FullBytes); err != nil {
+			return nil, errors.New("Can not parse OCSP responder certificate: " + err.Error())
+		}
+	}
+
+	if issuer == nil {
+		return r, nil
+	}
+	algorithm := x509.UnknownSignatureAlgorithm
+	for _, a := range signatureAlgorithms {
+		if a.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			algorithm = a.algorithm
+		}
+	}
+	responder := issuer
+	if r.Certificate != nil && !r.Certificate.Equal(issuer) {
+		if err := r.Certificate.CheckSignatureFrom(issuer); err != nil {
+			return nil, errors.New("OCSP responder certificate is not issued by the issuer: " + err.Error())
+		}
+		if !hasOCSPSigning(r.Certificate) {
+			return nil, errors.New("OCSP responder certificate is not allowed to sign OCSP responses")
+		}
+		responder = r.Certificate
+	}
+	if err := responder.CheckSignature(algorithm, basic.TBSResponseData.Raw, basic.Signature.RightAlign()); err != nil {
+		return nil, errors.New("Invalid OCSP response signature: " + err.Error())
+	}
+	return r, nil
+}
+
+////
+// Utility functions
+////
+
+func hasOCSPSigning(crt *x509.Certificate) bool {
+	for _, u := range crt.ExtKeyUsage {
+		if u == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
+
+func hashFromOID(oid asn1.ObjectIdentifier) crypto.Hash {
+	for h, o := range hashOIDs {
+		if o.Equal(oid) {
+			return h
+		}
+	}
+	return 0
+}
+
+func newCertID(hash crypto.Hash, issuer *x509.Certificate, serial *big.Int) (certID, error) {
+	oid, found := hashOIDs[hash]
+	if !found || !hash.Available() {
+		return certID{}, errors.New("Unsupported OCSP hash algorithm")
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return certID{}, errors.New("Can not parse issuer public key: " + err.Error())
+	}
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+	return certID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash,
+		IssuerKeyHash:  keyHash,
+		SerialNumber:   serial,
+	}, nil
+}
+
+func signingParams(pub crypto.PublicKey) (pkix.AlgorithmIdentifier, crypto.Hash, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSignatureSHA256WithRSA, Parameters: asn1.NullRawValue}, crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P384():
+			return pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA384}, crypto.SHA384, nil
+		case elliptic.P521():
+			return pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA512}, crypto.SHA512, nil
+		default:
+			return pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSAWithSHA256}, crypto.SHA256, nil
+		}
+	case ed25519.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSignatureEd25519}, 0, nil
+	default:
+		return pkix.AlgorithmIdentifier{}, 0, errors.New("Unsupported OCSP signing key type")
+	}
+}
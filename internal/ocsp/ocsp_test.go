@@ -0,0 +1,299 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test files written with openssl:
+//   ca.pem/ca.key          self-signed P-256 CA
+//   responder.pem/.key     delegated responder issued by the CA, with the OCSPSigning extended key usage
+//   leaf1001.pem, leaf1002.pem  certificates issued by the CA with serials 0x1001 and 0x1002
+//   req-nonce.der          openssl ocsp -issuer ca.pem -cert leaf1001.pem -reqout (SHA-1, with a nonce)
+//   req-sha256.der         openssl ocsp -issuer ca.pem -sha256 -cert leaf1002.pem -no_nonce -reqout
+//   resp-*.der             openssl ocsp -index -CA ca.pem -rsigner -rkey -ndays 7 -respout, for an index
+//                          with 0x1001 valid and 0x1002 revoked on 2026-01-02 03:04:05 (keyCompromise):
+//                          resp-good.der to req-nonce.der signed by the CA, resp-revoked.der to req-sha256.der
+//                          signed by the delegated responder, resp-unknown.der about serial 0x1003 signed by the CA
+
+func readFile(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// DER content of the first PEM block of a test file
+func readPEM(t *testing.T, name string) []byte {
+	block, _ := pem.Decode(readFile(t, name))
+	if block == nil {
+		t.Fatalf("%s: no PEM block", name)
+	}
+	return block.Bytes
+}
+
+func readCertificate(t *testing.T, name string) *x509.Certificate {
+	crt, err := x509.ParseCertificate(readPEM(t, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func readKey(t *testing.T, name string) crypto.Signer {
+	privatekey, err := x509.ParsePKCS8PrivateKey(readPEM(t, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privatekey.(crypto.Signer)
+}
+
+// Nonce extension value of an OCSP response
+func responseNonce(t *testing.T, der []byte) []byte {
+	var resp ocspResponse
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range basic.TBSResponseData.ResponseExtensions {
+		if ext.Id.Equal(oidNonce) {
+			return ext.Value
+		}
+	}
+	return nil
+}
+
+func TestParseRequestOpenSSL(t *testing.T) {
+	ca := readCertificate(t, "ca.pem")
+	responder := readCertificate(t, "responder.pem")
+	tests := []struct {
+		name   string
+		hash   crypto.Hash
+		serial int64
+		nonce  bool
+	}{
+		{"req-nonce.der", crypto.SHA1, 0x1001, true},
+		{"req-sha256.der", crypto.SHA256, 0x1002, false},
+	}
+	for _, test := range tests {
+		req, err := ParseRequest(readFile(t, test.name))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if req.HashAlgorithm != test.hash {
+			t.Errorf("%s: got hash %v, expected %v", test.name, req.HashAlgorithm, test.hash)
+		}
+		if req.SerialNumber.Cmp(big.NewInt(test.serial)) != 0 {
+			t.Errorf("%s: got serial %x, expected %x", test.name, req.SerialNumber, test.serial)
+		}
+		// The nonce value is the DER encoding of a 16 byte OCTET STRING
+		if test.nonce && (len(req.Nonce) != 18 || req.Nonce[0] != asn1.TagOctetString) {
+			t.Errorf("%s: got nonce %x, expected an OCTET STRING", test.name, req.Nonce)
+		} else if !test.nonce && req.Nonce != nil {
+			t.Errorf("%s: got nonce %x, expected none", test.name, req.Nonce)
+		}
+		if !req.Matches(ca) {
+			t.Errorf("%s: does not match its issuer", test.name)
+		}
+		if req.Matches(responder) {
+			t.Errorf("%s: matches another issuer", test.name)
+		}
+	}
+
+	if _, err := ParseRequest(readFile(t, "resp-good.der")); err == nil {
+		t.Errorf("response parsed as a request: got no error")
+	}
+	if _, err := ParseRequest(append(readFile(t, "req-nonce.der"), 0)); err == nil {
+		t.Errorf("trailing data: got no error")
+	}
+}
+
+func TestParseResponseOpenSSL(t *testing.T) {
+	ca := readCertificate(t, "ca.pem")
+	responder := readCertificate(t, "responder.pem")
+	other := readCertificate(t, "leaf1001.pem")
+	revokedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		status    int
+		serial    int64
+		responder *x509.Certificate
+	}{
+		{"resp-good.der", Good, 0x1001, ca},
+		{"resp-revoked.der", Revoked, 0x1002, responder},
+		{"resp-unknown.der", Unknown, 0x1003, ca},
+	}
+	for _, test := range tests {
+		der := readFile(t, test.name)
+		resp, err := ParseResponse(der, ca)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if resp.Status != test.status || resp.SerialNumber.Cmp(big.NewInt(test.serial)) != 0 {
+			t.Errorf("%s: got status %d for %x, expected %d for %x", test.name, resp.Status, resp.SerialNumber, test.status, test.serial)
+		}
+		if test.status == Revoked && (!resp.RevokedAt.Equal(revokedAt) || resp.RevocationReason != 1) {
+			t.Errorf("%s: got revocation at %s for reason %d, expected %s for reason 1", test.name, resp.RevokedAt, resp.RevocationReason, revokedAt)
+		}
+		if resp.NextUpdate.Sub(resp.ThisUpdate) != 7*24*time.Hour {
+			t.Errorf("%s: got validity from %s to %s, expected 7 days", test.name, resp.ThisUpdate, resp.NextUpdate)
+		}
+		if resp.Certificate == nil || !resp.Certificate.Equal(test.responder) {
+			t.Errorf("%s: unexpected responder certificate", test.name)
+		}
+		if _, err := ParseResponse(der, other); err == nil {
+			t.Errorf("%s: other issuer: got no error", test.name)
+		}
+	}
+
+	// The nonce of the request is echoed
+	req, _ := ParseRequest(readFile(t, "req-nonce.der"))
+	if nonce := responseNonce(t, readFile(t, "resp-good.der")); !bytes.Equal(nonce, req.Nonce) {
+		t.Errorf("resp-good.der: got nonce %x, expected %x", nonce, req.Nonce)
+	}
+
+	// Signature altered
+	der := readFile(t, "resp-good.der")
+	var resp ocspResponse
+	var basic basicResponse
+	asn1.Unmarshal(der, &resp)
+	asn1.Unmarshal(resp.Response.Response, &basic)
+	der[bytes.Index(der, basic.Signature.Bytes)+8] ^= 1
+	if _, err := ParseResponse(der, ca); err == nil {
+		t.Errorf("altered signature: got no error")
+	}
+	if _, err := ParseResponse(der, nil); err != nil {
+		t.Errorf("altered signature without issuer: got %s", err)
+	}
+}
+
+func TestCreateResponse(t *testing.T) {
+	ca := readCertificate(t, "ca.pem")
+	caKey := readKey(t, "ca.key")
+	responder := readCertificate(t, "responder.pem")
+	responderKey := readKey(t, "responder.key")
+	other := readCertificate(t, "leaf1001.pem")
+	now := time.Now().UTC().Truncate(time.Second)
+	revokedAt := now.Add(-time.Hour)
+
+	for _, name := range []string{"req-nonce.der", "req-sha256.der"} {
+		req, err := ParseRequest(readFile(t, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, status := range []int{Good, Revoked, Unknown} {
+			for _, signer := range []struct {
+				crt *x509.Certificate
+				key crypto.Signer
+			}{{ca, caKey}, {responder, responderKey}} {
+				tmpl := Response{
+					Status:           status,
+					ThisUpdate:       now,
+					NextUpdate:       now.Add(time.Hour),
+					RevokedAt:        revokedAt,
+					RevocationReason: 4,
+				}
+				der, err := CreateResponse(ca, signer.crt, req, tmpl, signer.key)
+				if err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				label := name + " " + signer.crt.Subject.CommonName
+				resp, err := ParseResponse(der, ca)
+				if err != nil {
+					t.Errorf("%s: %s", label, err)
+					continue
+				}
+				if resp.Status != status || resp.SerialNumber.Cmp(req.SerialNumber) != 0 {
+					t.Errorf("%s: got status %d for %x, expected %d for %x", label, resp.Status, resp.SerialNumber, status, req.SerialNumber)
+				}
+				if !resp.ThisUpdate.Equal(now) || !resp.NextUpdate.Equal(now.Add(time.Hour)) {
+					t.Errorf("%s: got validity from %s to %s", label, resp.ThisUpdate, resp.NextUpdate)
+				}
+				if status == Revoked && (!resp.RevokedAt.Equal(revokedAt) || resp.RevocationReason != 4) {
+					t.Errorf("%s: got revocation at %s for reason %d", label, resp.RevokedAt, resp.RevocationReason)
+				}
+				if (signer.crt == ca) != (resp.Certificate == nil) {
+					t.Errorf("%s: responder certificate included: %v", label, resp.Certificate != nil)
+				}
+				if nonce := responseNonce(t, der); !bytes.Equal(nonce, req.Nonce) {
+					t.Errorf("%s: got nonce %x, expected %x", label, nonce, req.Nonce)
+				}
+				if _, err := ParseResponse(der, other); err == nil {
+					t.Errorf("%s: other issuer: got no error", label)
+				}
+			}
+		}
+	}
+
+	// Delegated responder without the OCSPSigning extended key usage
+	req, _ := ParseRequest(readFile(t, "req-nonce.der"))
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x20),
+		Subject:      pkix.Name{CommonName: "Not OCSP"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	crtDER, err := x509.CreateCertificate(rand.Reader, tmpl, ca, responderKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notOCSP, err := x509.ParseCertificate(crtDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := CreateResponse(ca, notOCSP, req, Response{Status: Good, ThisUpdate: now}, responderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseResponse(der, ca); err == nil {
+		t.Errorf("responder without OCSPSigning: got no error")
+	}
+
+	if _, err := CreateResponse(ca, ca, req, Response{Status: 3, ThisUpdate: now}, caKey); err == nil {
+		t.Errorf("unknown status: got no error")
+	}
+}
+
+func TestCreateRequest(t *testing.T) {
+	ca := readCertificate(t, "ca.pem")
+	leaf := readCertificate(t, "leaf1001.pem")
+	der, err := CreateRequest(leaf, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same certificate ID as openssl, without the nonce
+	expected, _ := ParseRequest(readFile(t, "req-nonce.der"))
+	req, err := ParseRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.HashAlgorithm != expected.HashAlgorithm || !bytes.Equal(req.IssuerNameHash, expected.IssuerNameHash) ||
+		!bytes.Equal(req.IssuerKeyHash, expected.IssuerKeyHash) || req.SerialNumber.Cmp(expected.SerialNumber) != 0 || req.Nonce != nil {
+		t.Errorf("got request %+v, expected %+v", req, expected)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	for status, name := range responseStatuses {
+		if _, err := ParseResponse(ErrorResponse(status), nil); err == nil || err.Error() != "OCSP responder error: "+name {
+			t.Errorf("status %d: got %v, expected %s", status, err, name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package pkcs7 implements the subset of the Cryptographic Message Syntax (RFC 2315, RFC 5652)
+// needed to read and write certificate bundles (.p7b, .p7c): degenerate signed data with
+// certificates and no signers.
+package pkcs7
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+////
+// ASN.1 structures
+////
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"tag:0,optional"`
+	CRLs             asn1.RawValue `asn1:"tag:1,optional"`
+	SignerInfos      asn1.RawValue
+}
+
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// Tell if data is a DER encoded PKCS#7 signed data
+func IsPKCS7(der []byte) bool {
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(der, &ci)
+	return err == nil && len(rest) == 0 && ci.ContentType.Equal(oidSignedData)
+}
+
+// Certificates of a DER encoded PKCS#7 signed data, in the order of the bundle
+func ParseCertificates(der []byte) ([]*x509.Certificate, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, errors.New("Can not decode PKCS#7 data")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("PKCS#7 data is not signed data")
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, errors.New("Can not decode PKCS#7 signed data")
+	}
+	if len(sd.Certificates.Bytes) == 0 {
+		return nil, errors.New("No certificate in PKCS#7 data")
+	}
+	return x509.ParseCertificates(sd.Certificates.Bytes)
+}
+
+// Encode certificates in a DER encoded PKCS#7 signed data, without signers
+func EncodeCertificates(certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("No certificate to encode")
+	}
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
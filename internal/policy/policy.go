@@ -0,0 +1,322 @@
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Identifier types of policy violations
+const (
+	TypeDNS   = "dns"
+	TypeIP    = "ip"
+	TypeEmail = "email"
+	TypeURI   = "uri"
+	TypeKey   = "key"
+
+	// Issuance profile not allowed
+	TypeProfile = "profile"
+)
+
+// ACME challenge types
+var ChallengeTypes = []string{"http-01", "dns-01", "tls-alpn-01"}
+
+// ChallengeRule sets the ACME challenge types allowed for domains
+type ChallengeRule struct {
+	Domains []string `yaml:"domains"`
+	Types   []string `yaml:"types"`
+}
+
+// Policy restricts the names and keys the certificate authority will sign
+//
+// Domain entries are suffixes: "example.com" matches example.com and all its
+// subdomains, ".example.com" only its subdomains. Empty allow lists allow
+// everything not denied.
+type Policy struct {
+	AllowDomains      []string        `yaml:"allowDomains"`
+	DenyDomains       []string        `yaml:"denyDomains"`
+	DenyWildcards     bool            `yaml:"denyWildcards"`
+	AllowIPs          []string        `yaml:"allowIPs"`
+	DenyIPs           []string        `yaml:"denyIPs"`
+	AllowEmailDomains []string        `yaml:"allowEmailDomains"`
+	DenyEmailDomains  []string        `yaml:"denyEmailDomains"`
+	AllowURISchemes   []string        `yaml:"allowURISchemes"`
+	AllowURIDomains   []string        `yaml:"allowURIDomains"`
+	DenyURIDomains    []string        `yaml:"denyURIDomains"`
+	AllowProfiles     []string        `yaml:"allowProfiles"`
+	MaxDays           map[string]int  `yaml:"maxDays"` // by profile name
+	MinRSASize        int             `yaml:"minRSASize"`
+	Curves            []string        `yaml:"curves"`
+	Challenges        []ChallengeRule `yaml:"challenges"` // first matching rule applies
+
+	allowNets []*net.IPNet
+	denyNets  []*net.IPNet
+}
+
+// Violation is the error returned when a request is forbidden by the policy
+type Violation struct {
+	Type   string
+	Value  string
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return "Policy forbids " + v.Type + " " + v.Value + ": " + v.Reason
+}
+
+// Load a policy from a YAML file (a policy allowing everything if filename is empty)
+func Load(filename string) (*Policy, error) {
+	p := &Policy{}
+	if len(filename) == 0 {
+		return p, nil
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.New("Can not read policy file " + filename)
+	}
+	if err = yaml.Unmarshal(content, p); err != nil {
+		return nil, errors.New("Can not parse policy file " + filename + ": " + err.Error())
+	}
+	if p.allowNets, err = parseNets(p.AllowIPs); err != nil {
+		return nil, err
+	}
+	if p.denyNets, err = parseNets(p.DenyIPs); err != nil {
+		return nil, err
+	}
+	for _, rule := range p.Challenges {
+		for _, t := range rule.Types {
+			if !contains(ChallengeTypes, t) {
+				return nil, errors.New("Unknown challenge type " + t + " in policy")
+			}
+		}
+	}
+	return p, nil
+}
+
+// Cap a number of days to the maximum validity of a profile
+func (p *Policy) Days(profile string, days int) int {
+	if max, found := p.MaxDays[profile]; found && max > 0 && days > max {
+		return max
+	}
+	return days
+}
+
+// Check the issuance profile, all names and the public key of a certificate signing request
+func (p *Policy) CheckCSR(csr *x509.CertificateRequest, profile string) error {
+	if err := p.CheckProfile(profile); err != nil {
+		return err
+	}
+	for _, name := range csr.DNSNames {
+		if err := p.CheckDNSName(name); err != nil {
+			return err
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if err := p.CheckIP(ip); err != nil {
+			return err
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		if err := p.CheckEmail(email); err != nil {
+			return err
+		}
+	}
+	for _, uri := range csr.URIs {
+		if err := p.CheckURI(uri); err != nil {
+			return err
+		}
+	}
+	if err := p.CheckCommonName(csr.Subject.CommonName); err != nil {
+		return err
+	}
+	return p.CheckKey(csr.PublicKey)
+}
+
+// Check a subject common name which looks like an IP address, an email or a domain name
+func (p *Policy) CheckCommonName(cn string) error {
+	if ip := net.ParseIP(cn); ip != nil {
+		return p.CheckIP(ip)
+	} else if strings.Contains(cn, "@") {
+		return p.CheckEmail(cn)
+	} else if isDomainName(cn) {
+		return p.CheckDNSName(cn)
+	}
+	return nil
+}
+
+// Check a DNS name (wildcards included)
+func (p *Policy) CheckDNSName(name string) error {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	wildcard := strings.HasPrefix(name, "*.")
+	if wildcard && p.DenyWildcards {
+		return &Violation{TypeDNS, name, "wildcard names are not allowed"}
+	}
+	for _, d := range p.DenyDomains {
+		if matchDomain(name, d) || (wildcard && coversDomain(name, d)) {
+			return &Violation{TypeDNS, name, "domain is denied"}
+		}
+	}
+	if len(p.AllowDomains) > 0 && !matchAnyDomain(name, p.AllowDomains) {
+		return &Violation{TypeDNS, name, "domain is not allowed"}
+	}
+	return nil
+}
+
+// Check an IP address
+func (p *Policy) CheckIP(ip net.IP) error {
+	for _, n := range p.denyNets {
+		if n.Contains(ip) {
+			return &Violation{TypeIP, ip.String(), "address is denied"}
+		}
+	}
+	if len(p.allowNets) == 0 {
+		return nil
+	}
+	for _, n := range p.allowNets {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return &Violation{TypeIP, ip.String(), "address is not allowed"}
+}
+
+// Check the domain of an email address
+func (p *Policy) CheckEmail(email string) error {
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	if matchAnyDomain(domain, p.DenyEmailDomains) {
+		return &Violation{TypeEmail, email, "email domain is denied"}
+	}
+	if len(p.AllowEmailDomains) > 0 && !matchAnyDomain(domain, p.AllowEmailDomains) {
+		return &Violation{TypeEmail, email, "email domain is not allowed"}
+	}
+	return nil
+}
+
+// Check the scheme and the host of a URI
+func (p *Policy) CheckURI(uri *url.URL) error {
+	if len(p.AllowURISchemes) > 0 && !contains(p.AllowURISchemes, strings.ToLower(uri.Scheme)) {
+		return &Violation{TypeURI, uri.String(), "scheme is not allowed"}
+	}
+	host := strings.ToLower(uri.Hostname())
+	if matchAnyDomain(host, p.DenyURIDomains) {
+		return &Violation{TypeURI, uri.String(), "domain is denied"}
+	}
+	if len(p.AllowURIDomains) > 0 && !matchAnyDomain(host, p.AllowURIDomains) {
+		return &Violation{TypeURI, uri.String(), "domain is not allowed"}
+	}
+	return nil
+}
+
+// Check an issuance profile name
+func (p *Policy) CheckProfile(profile string) error {
+	if len(p.AllowProfiles) > 0 && !contains(p.AllowProfiles, profile) {
+		return &Violation{TypeProfile, profile, "profile is not allowed"}
+	}
+	return nil
+}
+
+// Check the size of RSA keys and the curve of ECDSA keys
+func (p *Policy) CheckKey(publicKey any) error {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		if size := k.N.BitLen(); size < p.MinRSASize {
+			return &Violation{TypeKey, "rsa " + strconv.Itoa(size), "key size lower than " + strconv.Itoa(p.MinRSASize)}
+		}
+	case *ecdsa.PublicKey:
+		if len(p.Curves) == 0 {
+			return nil
+		}
+		curve := k.Curve.Params().Name
+		for _, c := range p.Curves {
+			if strings.EqualFold(strings.ReplaceAll(c, "-", ""), strings.ReplaceAll(curve, "-", "")) {
+				return nil
+			}
+		}
+		return &Violation{TypeKey, "ecdsa " + curve, "curve is not allowed"}
+	}
+	return nil
+}
+
+// ACME challenge types allowed for a domain (nil if no rule applies)
+func (p *Policy) AllowedChallenges(domain string) []string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, rule := range p.Challenges {
+		if matchAnyDomain(domain, rule.Domains) {
+			return rule.Types
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNets(list []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("Invalid IP range " + s + " in policy")
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// "example.com" matches the domain and its subdomains, ".example.com" only its subdomains
+func matchDomain(name, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, ".") {
+		return strings.HasSuffix(name, pattern)
+	}
+	return name == pattern || strings.HasSuffix(name, "."+pattern)
+}
+
+func matchAnyDomain(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if matchDomain(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Test if a wildcard name (*.example.com) covers a domain (admin.example.com)
+func coversDomain(wildcard, domain string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	i := strings.Index(domain, ".")
+	return i > 0 && domain[i:] == wildcard[1:]
+}
+
+func isDomainName(s string) bool {
+	if !strings.Contains(s, ".") {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '*') {
+			return false
+		}
+	}
+	return true
+}
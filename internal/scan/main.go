@@ -0,0 +1,420 @@
+package scan
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"simpleca/flags"
+	"simpleca/internal/cert"
+	"simpleca/internal/info"
+	"simpleca/internal/ocsp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	f = flags.NewFlag("simpleca")
+
+	oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// TLS handshake report of a server
+type Result struct {
+	Target      string       `yaml:"target" json:"target"`
+	Address     string       `yaml:"address" json:"address"`
+	ServerName  string       `yaml:"serverName" json:"serverName"`
+	StartTLS    string       `yaml:"starttls,omitempty" json:"starttls,omitempty"`
+	Ok          bool         `yaml:"ok" json:"ok"`
+	Error       string       `yaml:"error,omitempty" json:"error,omitempty"`
+	TLSVersion  string       `yaml:"tlsVersion,omitempty" json:"tlsVersion,omitempty"`
+	CipherSuite string       `yaml:"cipherSuite,omitempty" json:"cipherSuite,omitempty"`
+	ALPN        string       `yaml:"alpn,omitempty" json:"alpn,omitempty"`
+	OCSP        *OCSPStatus  `yaml:"ocsp,omitempty" json:"ocsp,omitempty"`
+	SCTs        []SCT        `yaml:"scts,omitempty" json:"scts,omitempty"`
+	Chain       []ChainCert  `yaml:"chain,omitempty" json:"chain,omitempty"`
+	Checks      []cert.Check `yaml:"checks,omitempty" json:"checks,omitempty"`
+	NotAfter    *time.Time   `yaml:"notAfter,omitempty" json:"notAfter,omitempty"`
+	DaysLeft    int          `yaml:"daysToExpiry" json:"daysToExpiry"`
+}
+
+// Stapled OCSP response
+type OCSPStatus struct {
+	Status     string     `yaml:"status" json:"status"`
+	Error      string     `yaml:"error,omitempty" json:"error,omitempty"`
+	ProducedAt *time.Time `yaml:"producedAt,omitempty" json:"producedAt,omitempty"`
+	ThisUpdate *time.Time `yaml:"thisUpdate,omitempty" json:"thisUpdate,omitempty"`
+	NextUpdate *time.Time `yaml:"nextUpdate,omitempty" json:"nextUpdate,omitempty"`
+	RevokedAt  *time.Time `yaml:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// Signed certificate timestamp, sent in the TLS handshake or embedded in the certificate
+type SCT struct {
+	Source    string    `yaml:"source" json:"source"`
+	LogID     string    `yaml:"logID" json:"logID"`
+	Timestamp time.Time `yaml:"timestamp" json:"timestamp"`
+}
+
+// Certificate of the chain (the built chain, or the certificates sent by the server)
+type ChainCert struct {
+	Subject  string    `yaml:"subject" json:"subject"`
+	Issuer   string    `yaml:"issuer" json:"issuer"`
+	NotAfter time.Time `yaml:"notAfter" json:"notAfter"`
+	DaysLeft int       `yaml:"daysToExpiry" json:"daysToExpiry"`
+}
+
+// Options of a scan
+type Options struct {
+	Roots      *x509.CertPool
+	ServerName string
+	StartTLS   string
+	ALPN       []string
+	Timeout    time.Duration
+}
+
+func Usage() {
+	fmt.Println(`
+Usage:  simpleca scan [OPTIONS] HOST[:PORT]...
+
+Connect to TLS servers and report the negotiated TLS version, cipher suite and ALPN protocol,
+the stapled OCSP response, the signed certificate timestamps, the validity of the chain,
+the host name match and the days to expiry (exit code 1 when a server fails)
+The default port is 443, or the port of the STARTTLS protocol
+
+Options:`)
+	f.PrintDefaults()
+	os.Exit(0)
+}
+
+func Main(args []string) {
+	caFile := f.String("ca", "", "Trusted certificate authorities file (default system certificate authorities)")
+	sni := f.String("sni", "", "Server name sent in the handshake and checked (default host)")
+	starttls := f.String("starttls", "", "Upgrade a plain connection with STARTTLS ("+strings.Join(StartTLSProtocols(), ", ")+")")
+	alpn := f.String("alpn", "h2,http/1.1", "ALPN protocols offered, comma separated (none with STARTTLS)")
+	timeout := f.Duration("timeout", 10*time.Second, "Connection timeout")
+	parallel := f.Int("parallel", 8, "Number of servers scanned in parallel")
+	format := f.String("format", "text", "Output format ("+strings.Join(info.Formats, ", ")+")")
+
+	f.SetUsage(Usage)
+	f.Parse(args[1:])
+	if f.NArg() == 0 {
+		Usage()
+	}
+	if err := info.CheckFormat(*format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if _, found := startTLS[*starttls]; !found {
+		fmt.Fprintln(os.Stderr, "Unknown STARTTLS protocol "+*starttls)
+		os.Exit(1)
+	}
+
+	opts := Options{ServerName: *sni, StartTLS: *starttls, Timeout: *timeout}
+	if len(*alpn) > 0 && len(*starttls) == 0 {
+		opts.ALPN = strings.Split(*alpn, ",")
+	}
+	if len(*caFile) > 0 {
+		roots, err := cert.LoadCertsFile(*caFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Can not read certificate authorities "+*caFile+": "+err.Error())
+			os.Exit(1)
+		}
+		opts.Roots = x509.NewCertPool()
+		for _, c := range roots {
+			opts.Roots.AddCert(c)
+		}
+	}
+	if *parallel < 1 {
+		*parallel = 1
+	}
+
+	results := make([]*Result, f.NArg())
+	sem := make(chan bool, *parallel)
+	var wg sync.WaitGroup
+	for i, target := range f.Args() {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			sem <- true
+			results[i] = Scan(target, opts)
+			<-sem
+		}(i, target)
+	}
+	wg.Wait()
+
+	ok := true
+	for _, r := range results {
+		ok = ok && r.Ok
+	}
+	if *format == "text" {
+		for _, r := range results {
+			r.Print()
+		}
+	} else {
+		bytes, err := info.Marshal(results, *format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(bytes)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// Scan a server: TLS handshake, then check of its certificates
+func Scan(target string, opts Options) *Result {
+	r := &Result{Target: target, StartTLS: opts.StartTLS}
+	host, port, err := splitTarget(target, opts.StartTLS)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Address = net.JoinHostPort(host, port)
+	r.ServerName = opts.ServerName
+	if len(r.ServerName) == 0 {
+		r.ServerName = host
+	}
+
+	conn, err := net.DialTimeout("tcp", r.Address, opts.Timeout)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(opts.Timeout))
+	if err = startTLS[opts.StartTLS].upgrade(conn); err != nil {
+		r.Error = "STARTTLS: " + err.Error()
+		return r
+	}
+	config := &tls.Config{
+		ServerName:         r.ServerName,
+		NextProtos:         opts.ALPN,
+		InsecureSkipVerify: true,
+	}
+	// IP addresses are not sent as server names
+	if net.ParseIP(r.ServerName) != nil {
+		config.ServerName = ""
+	}
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.Handshake(); err != nil {
+		r.Error = "TLS handshake: " + err.Error()
+		return r
+	}
+	state := tlsConn.ConnectionState()
+	r.TLSVersion = tls.VersionName(state.Version)
+	r.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	r.ALPN = state.NegotiatedProtocol
+	if len(state.PeerCertificates) == 0 {
+		r.Error = "No certificate sent by the server"
+		return r
+	}
+	r.check(state, opts)
+	return r
+}
+
+// Check the certificates, the stapled OCSP response and the signed certificate timestamps
+func (r *Result) check(state tls.ConnectionState, opts Options) {
+	certs := state.PeerCertificates
+	leaf := certs[0]
+	verify := x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: x509.NewCertPool(),
+		DNSName:       r.ServerName,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, c := range certs[1:] {
+		verify.Intermediates.AddCert(c)
+	}
+	chain, checks, err := cert.VerifyCert(leaf, verify)
+	r.Checks = checks
+	r.Ok = err == nil
+	if chain == nil {
+		chain = certs
+	}
+	now := time.Now()
+	for _, c := range chain {
+		r.Chain = append(r.Chain, ChainCert{
+			Subject:  c.Subject.String(),
+			Issuer:   c.Issuer.String(),
+			NotAfter: c.NotAfter.UTC(),
+			DaysLeft: daysLeft(c.NotAfter, now),
+		})
+	}
+	notAfter := leaf.NotAfter.UTC()
+	r.NotAfter = &notAfter
+	r.DaysLeft = daysLeft(leaf.NotAfter, now)
+
+	if len(state.OCSPResponse) > 0 {
+		var issuer *x509.Certificate
+		if len(chain) > 1 {
+			issuer = chain[1]
+		}
+		r.OCSP = parseOCSP(state.OCSPResponse, leaf, issuer)
+		if r.OCSP.Status == "revoked" {
+			r.Ok = false
+		}
+	}
+	for _, sct := range state.SignedCertificateTimestamps {
+		if s, err := parseSCT(sct, "tls"); err == nil {
+			r.SCTs = append(r.SCTs, s)
+		}
+	}
+	for _, e := range leaf.Extensions {
+		if e.Id.Equal(oidSCTList) {
+			r.SCTs = append(r.SCTs, parseSCTList(e.Value, "certificate")...)
+		}
+	}
+}
+
+// Print a report in text
+func (r *Result) Print() {
+	fmt.Println(r.Target + ":")
+	if len(r.Address) > 0 {
+		fmt.Println("  Address:         " + r.Address + " (server name " + r.ServerName + ")")
+	}
+	if len(r.Error) > 0 {
+		fmt.Println("  Error:           " + r.Error)
+		fmt.Println("  Result:          FAILED")
+		fmt.Println("")
+		return
+	}
+	fmt.Println("  TLS version:     " + r.TLSVersion)
+	fmt.Println("  Cipher suite:    " + r.CipherSuite)
+	if len(r.ALPN) > 0 {
+		fmt.Println("  ALPN:            " + r.ALPN)
+	} else {
+		fmt.Println("  ALPN:            none")
+	}
+	switch {
+	case r.OCSP == nil:
+		fmt.Println("  OCSP stapling:   none")
+	case len(r.OCSP.Error) > 0:
+		fmt.Println("  OCSP stapling:   " + r.OCSP.Status + " (" + r.OCSP.Error + ")")
+	case r.OCSP.RevokedAt != nil:
+		fmt.Println("  OCSP stapling:   " + r.OCSP.Status + " at " + r.OCSP.RevokedAt.Format(time.RFC3339))
+	case r.OCSP.NextUpdate == nil:
+		fmt.Println("  OCSP stapling:   " + r.OCSP.Status + " (no next update)")
+	default:
+		fmt.Println("  OCSP stapling:   " + r.OCSP.Status + " (next update " + r.OCSP.NextUpdate.Format(time.RFC3339) + ")")
+	}
+	fmt.Println("  SCTs:            " + strconv.Itoa(len(r.SCTs)))
+	for _, s := range r.SCTs {
+		fmt.Println("    " + s.LogID + " at " + s.Timestamp.Format(time.RFC3339) + " (" + s.Source + ")")
+	}
+	fmt.Println("  Chain:")
+	for i, c := range r.Chain {
+		fmt.Printf("    %d: %s (expires %s, %d days)\n", i, c.Subject, c.NotAfter.Format(time.RFC3339), c.DaysLeft)
+	}
+	fmt.Println("  Checks:")
+	for _, c := range r.Checks {
+		if c.Ok {
+			fmt.Printf("    %-10s OK\n", c.Name)
+		} else {
+			fmt.Printf("    %-10s FAILED: %s\n", c.Name, c.Reason)
+		}
+	}
+	fmt.Println("  Days to expiry:  " + strconv.Itoa(r.DaysLeft))
+	if r.Ok {
+		fmt.Println("  Result:          OK")
+	} else {
+		fmt.Println("  Result:          FAILED")
+	}
+	fmt.Println("")
+}
+
+// Host and port of a target, the default port depends on the STARTTLS protocol
+func splitTarget(target, starttls string) (string, string, error) {
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "https://"), "/")
+	port := startTLS[starttls].port
+	if host, p, err := net.SplitHostPort(target); err == nil {
+		if len(p) > 0 {
+			port = p
+		}
+		return host, port, nil
+	}
+	if strings.Contains(target, ":") && net.ParseIP(target) == nil {
+		return "", "", errors.New("Invalid target " + target)
+	}
+	return target, port, nil
+}
+
+func parseOCSP(der []byte, leaf, issuer *x509.Certificate) *OCSPStatus {
+	resp, err := ocsp.ParseResponse(der, issuer)
+	if err != nil {
+		return &OCSPStatus{Status: "invalid", Error: err.Error()}
+	}
+	status := &OCSPStatus{
+		ProducedAt: &resp.ProducedAt,
+		ThisUpdate: &resp.ThisUpdate,
+	}
+	if !resp.NextUpdate.IsZero() {
+		status.NextUpdate = &resp.NextUpdate
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		status.Status = "good"
+	case ocsp.Revoked:
+		status.Status = "revoked"
+		status.RevokedAt = &resp.RevokedAt
+	default:
+		status.Status = "unknown"
+	}
+	if resp.SerialNumber == nil || resp.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		status.Status = "invalid"
+		status.Error = "OCSP response is not about the server certificate"
+	} else if issuer == nil {
+		// Only the leaf certificate was sent and it does not chain to a known certificate authority
+		status.Error = "OCSP response says " + status.Status + " but its signature can not be verified without the issuer certificate"
+		status.Status = "unverified"
+	} else if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		status.Error = "OCSP response expired"
+	}
+	return status
+}
+
+// Signed certificate timestamp list (RFC 6962), in an octet string
+func parseSCTList(value []byte, source string) []SCT {
+	var list []byte
+	if _, err := asn1.Unmarshal(value, &list); err != nil || len(list) < 2 {
+		return nil
+	}
+	list = list[2:]
+	var scts []SCT
+	for len(list) >= 2 {
+		n := int(binary.BigEndian.Uint16(list))
+		if len(list) < 2+n {
+			break
+		}
+		if s, err := parseSCT(list[2:2+n], source); err == nil {
+			scts = append(scts, s)
+		}
+		list = list[2+n:]
+	}
+	return scts
+}
+
+// Signed certificate timestamp: version, log ID, timestamp in milliseconds, extensions, signature
+func parseSCT(sct []byte, source string) (SCT, error) {
+	if len(sct) < 1+32+8 || sct[0] != 0 {
+		return SCT{}, errors.New("Unsupported signed certificate timestamp")
+	}
+	ms := int64(binary.BigEndian.Uint64(sct[33:41]))
+	return SCT{
+		Source:    source,
+		LogID:     base64.StdEncoding.EncodeToString(sct[1:33]),
+		Timestamp: time.UnixMilli(ms).UTC(),
+	}, nil
+}
+
+func daysLeft(notAfter, now time.Time) int {
+	return int(notAfter.Sub(now).Hours() / 24)
+}
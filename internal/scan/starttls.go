@@ -0,0 +1,178 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Plain text protocol upgraded to TLS, with its default port
+type starttlsProtocol struct {
+	port    string
+	upgrade func(conn net.Conn) error
+}
+
+var startTLS = map[string]starttlsProtocol{
+	"":         {"443", func(net.Conn) error { return nil }},
+	"smtp":     {"25", smtpStartTLS},
+	"imap":     {"143", imapStartTLS},
+	"ldap":     {"389", ldapStartTLS},
+	"postgres": {"5432", postgresStartTLS},
+}
+
+// Sorted names of the STARTTLS protocols
+func StartTLSProtocols() []string {
+	names := []string{}
+	for name := range startTLS {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SMTP (RFC 3207): greeting, EHLO then STARTTLS
+func smtpStartTLS(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	if err := smtpReply(reader, "220"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "EHLO simpleca\r\n"); err != nil {
+		return err
+	}
+	if err := smtpReply(reader, "250"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+	return smtpReply(reader, "220")
+}
+
+// Read a (multi-line) SMTP reply and check its code
+func smtpReply(reader *bufio.Reader, code string) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, code) {
+			return errors.New("Unexpected SMTP reply: " + strings.TrimSpace(line))
+		}
+		if len(line) < 4 || line[3] != '-' {
+			return nil
+		}
+	}
+}
+
+// IMAP (RFC 3501): greeting then tagged STARTTLS command
+func imapStartTLS(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return errors.New("Unexpected IMAP greeting: " + strings.TrimSpace(line))
+	}
+	if _, err := io.WriteString(conn, "a1 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	for {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "a1 ") {
+			break
+		}
+	}
+	if !strings.HasPrefix(line, "a1 OK") {
+		return errors.New("IMAP server refused STARTTLS: " + strings.TrimSpace(line))
+	}
+	return nil
+}
+
+// LDAP (RFC 4511): StartTLS extended operation 1.3.6.1.4.1.1466.20037
+func ldapStartTLS(conn net.Conn) error {
+	name := []byte("1.3.6.1.4.1.1466.20037")
+	request, err := asn1.Marshal(struct {
+		MessageID int
+		Request   asn1.RawValue
+	}{1, asn1.RawValue{Class: asn1.ClassApplication, Tag: 23, IsCompound: true,
+		Bytes: append([]byte{0x80, byte(len(name))}, name...)}})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// LDAPMessage: read the header to know the length
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 {
+			return errors.New("Invalid LDAP response")
+		}
+		size := make([]byte, n)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		header = append(header, size...)
+		length = 0
+		for _, b := range size {
+			length = length<<8 | int(b)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return err
+	}
+	var response struct {
+		MessageID int
+		Response  asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(append(header, body...), &response); err != nil {
+		return errors.New("Invalid LDAP response: " + err.Error())
+	}
+	var resultCode asn1.Enumerated
+	if response.Response.Tag != 24 {
+		return errors.New("Unexpected LDAP response")
+	}
+	if _, err := asn1.Unmarshal(response.Response.Bytes, &resultCode); err != nil {
+		return errors.New("Invalid LDAP response: " + err.Error())
+	}
+	if resultCode != 0 {
+		return errors.New("LDAP server refused StartTLS (result code " + strconv.Itoa(int(resultCode)) + ")")
+	}
+	return nil
+}
+
+// PostgreSQL: SSLRequest message, the server answers S (accepted) or N
+func postgresStartTLS(conn net.Conn) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:], 8)
+	binary.BigEndian.PutUint32(request[4:], 80877103)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return err
+	}
+	if answer[0] != 'S' {
+		return errors.New("PostgreSQL server refused SSL")
+	}
+	return nil
+}
@@ -1,5 +1,7 @@
 package web
 
+import "time"
+
 var (
 	ConfigC       string = "FR"
 	ConfigST      string = "France"
@@ -9,4 +11,9 @@ var (
 	ConfigSize    int    = 2048
 	ConfigDays    int    = 3650
 	ConfigKeyType string = "rsa"
+	ConfigCurve   string = "P384"
+	ConfigCrlDays int    = 7
+	ConfigProfile string = "default"
+
+	ConfigOcspValidity time.Duration = 24 * time.Hour
 )
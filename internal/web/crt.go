@@ -1,6 +1,7 @@
 package web
 
 import (
+	"crypto/elliptic"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -35,16 +36,6 @@ func Crt(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	if size < 1024 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Key size not big enough"))
-		return
-	}
-	if size > 16384 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Key size too much big"))
-		return
-	}
 	var days int = 3650
 	s = GetParam(r, "days", strconv.Itoa(ConfigDays))
 	if len(s) > 0 {
@@ -55,6 +46,13 @@ func Crt(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	profile, err := GetProfile(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	passphrase := GetParam(r, "passphrase", "")
 	C := GetParam(r, "C", ConfigC)
 	ST := GetParam(r, "ST", ConfigST)
@@ -68,6 +66,10 @@ func Crt(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Common name can not be empty", http.StatusBadRequest)
 		return
 	}
+	if tools.Contains(r.Header["Accept"], "application/x-java-keystore") && len(passphrase) == 0 {
+		http.Error(w, "Key store password can not be empty", http.StatusBadRequest)
+		return
+	}
 	altNames := strings.Split(GetParam(r, "altnames", name), ",")
 	ipss := GetParam(r, "ips", "")
 	var ips []net.IP = []net.IP{}
@@ -79,20 +81,46 @@ func Crt(w http.ResponseWriter, r *http.Request) {
 		ips = []net.IP{net.ParseIP(strings.TrimSpace("127.0.0.1"))}
 	}
 
-	mkey, err := key.GenerateRSAKey(size)
+	t := strings.ToLower(GetParam(r, "type", ConfigKeyType))
+	var curve elliptic.Curve
+	switch t {
+	case "rsa":
+		if size < 1024 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Key size not big enough"))
+			return
+		}
+		if size > 16384 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Key size too much big"))
+			return
+		}
+	case "ecdsa":
+		if curve, err = key.ParseCurve(GetParam(r, "curve", ConfigCurve)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Wrong elliptic curve"))
+			return
+		}
+	case "ed25519":
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Wrong key type"))
+		return
+	}
+	mkey, err := key.GenerateKey(t, size, curve)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Error while generate private key"))
 		return
 	}
-	keyBlock, err := key.ConvertRSAKeyToBlock(mkey, passphrase)
+	keyBlock, err := key.ConvertKeyToBlock(mkey, passphrase)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Can not convert key to block"))
 		return
 	}
 	keyBytes := pem.EncodeToMemory(keyBlock)
-	publicKey, err := x509.MarshalPKIXPublicKey(&mkey.PublicKey)
+	publicKey, err := x509.MarshalPKIXPublicKey(mkey.Public())
 	publicKeyBytes := pem.EncodeToMemory(&pem.Block{
 		Type:  "PUBLIC KEY",
 		Bytes: publicKey,
@@ -104,22 +132,54 @@ func Crt(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Error while generate certificate signing request"))
 		return
 	}
+	if err = Policy.CheckCSR(ccsr, profile.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	days = Policy.Days(profile.Name, days)
 	csrBlock := csr.ConvertCSRToBlock(ccsr)
 	csrBytes := pem.EncodeToMemory(csrBlock)
 
-	ccrt, err := ca.CASign(ccsr, days, CaCert, CaKey, CaCertURL)
+	ccrt, err := ca.CASign(ccsr, days, CaCert, CaKey, CaCertURL, CrlURL, OcspURL, profile)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Can not sign certificate signing request"))
 		return
 	}
-	crtBlock := cert.ConvertCertToBlock(ccrt)
-	crtBytes := pem.EncodeToMemory(crtBlock)
-
-	CaCertBlock := cert.ConvertCertToBlock(CaCert)
-	caCertBytes := pem.EncodeToMemory(CaCertBlock)
+	if err = CaDb.Add(ccrt, Requester(r)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Can not record certificate"))
+		return
+	}
+	crtBytes := cert.EncodeCertsToPEM(FullChain(ccrt))
+	caCertBytes := cert.EncodeCertsToPEM(CaCerts())
 
-	if tools.Contains(r.Header["Accept"], "application/json") {
+	if tools.Contains(r.Header["Accept"], "application/x-pkcs12") {
+		// Private key, certificate and certificate authority chain, protected by the passphrase
+		p12Bytes, err := key.EncodePKCS12(mkey, append([]*x509.Certificate{ccrt}, CaCerts()...), passphrase, name, false)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Can not create PKCS#12 bundle"))
+			return
+		}
+		w.Header().Add("Content-type", "application/x-pkcs12")
+		w.Header().Add("Content-Disposition", "attachment; filename=\""+name+".p12\"")
+		w.WriteHeader(http.StatusOK)
+		w.Write(p12Bytes)
+	} else if tools.Contains(r.Header["Accept"], "application/x-java-keystore") {
+		// JKS key store with the private key and its chain under the common name, protected by the passphrase
+		entries := []key.KeyStoreEntry{{Alias: name, PrivateKey: mkey, Certs: append([]*x509.Certificate{ccrt}, CaCerts()...)}}
+		jksBytes, err := key.EncodeJKS(entries, passphrase)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Can not create key store"))
+			return
+		}
+		w.Header().Add("Content-type", "application/x-java-keystore")
+		w.Header().Add("Content-Disposition", "attachment; filename=\""+name+".jks\"")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jksBytes)
+	} else if tools.Contains(r.Header["Accept"], "application/json") {
 		w.Header().Add("Content-type", "application/json")
 		r := Resp{Csr: string(csrBytes), Crt: string(crtBytes)}
 		r.Key = new(TKey)
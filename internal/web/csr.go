@@ -2,6 +2,7 @@ package web
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/json"
 	"encoding/pem"
@@ -79,9 +80,8 @@ func Csr(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusOK)
 				w.Write(csrBytes)
 			}
-		case *ecdsa.PrivateKey:
-			privateKey := kkey.(*ecdsa.PrivateKey)
-			ccsr, err := csr.GenerateCSR(name, C, ST, L, O, OU, SA, PC, altNames, ips, privateKey)
+		case *ecdsa.PrivateKey, ed25519.PrivateKey:
+			ccsr, err := csr.GenerateCSR(name, C, ST, L, O, OU, SA, PC, altNames, ips, kkey)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte("Error while generate certificate signing request"))
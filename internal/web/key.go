@@ -1,6 +1,7 @@
 package web
 
 import (
+	"crypto/elliptic"
 	"encoding/json"
 	"encoding/pem"
 	"net/http"
@@ -30,47 +31,41 @@ func Key(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	if size < 1024 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Key size not big enough"))
-		return
-	}
-	if size > 16384 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Key size too much big"))
-		return
-	}
 
 	passphrase := GetParam(r, "passphrase", "")
 	t := strings.ToLower(GetParam(r, "type", ConfigKeyType))
+	var curve elliptic.Curve
+	switch t {
+	case "rsa":
+		if size < 1024 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Key size not big enough"))
+			return
+		}
+		if size > 16384 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Key size too much big"))
+			return
+		}
+	case "ecdsa":
+		if curve, err = key.ParseCurve(GetParam(r, "curve", ConfigCurve)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Wrong elliptic curve"))
+			return
+		}
+	}
 
 	w.Header().Add("Cache-control", "no-cache, no-store, must-revalidate")
 	w.Header().Add("Expires", "0")
 	switch t {
-	case "rsa":
-		if key, err := key.GenerateRSAKeyBlock(size, passphrase); err != nil {
+	case "rsa", "ecdsa", "ed25519":
+		mkey, err := key.GenerateKey(t, size, curve)
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("Error while generate private key"))
 			return
-		} else {
-			bytes := pem.EncodeToMemory(key)
-			if tools.Contains(r.Header["Accept"], "application/json") {
-				w.Header().Add("Content-type", "application/json")
-				r := Resp{}
-				r.Key = new(TKey)
-				r.Key.Priv = string(bytes)
-				resp, _ := json.Marshal(r)
-				w.WriteHeader(http.StatusOK)
-				w.Write(resp)
-			} else {
-				w.Header().Add("Content-type", "text/plain")
-				w.WriteHeader(http.StatusOK)
-				w.Write(bytes)
-			}
 		}
-		return
-	case "ecdsa":
-		if key, err := key.GenerateECDSAKeyBlock(passphrase); err != nil {
+		if key, err := key.ConvertKeyToBlock(mkey, passphrase); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("Error while generate private key"))
 			return
@@ -2,10 +2,12 @@ package web
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,6 +15,7 @@ import (
 	"simpleca/internal/ca"
 	"simpleca/internal/cert"
 	"simpleca/internal/key"
+	"simpleca/internal/policy"
 	"simpleca/tools"
 	"strings"
 	"syscall"
@@ -36,9 +39,19 @@ Options:
 }
 
 var (
-	CaKey     *rsa.PrivateKey   = nil
-	CaCert    *x509.Certificate = nil
-	CaCertURL string            = ""
+	CaKey     crypto.Signer       = nil
+	CaCert    *x509.Certificate   = nil
+	CaChain   []*x509.Certificate = nil
+	CaTrust   []*x509.Certificate = nil // all certificates of the CA certificate file, root included
+	CaCertURL string              = ""
+	CrlURL    string              = ""
+	OcspURL   string              = ""
+	CaDb      *ca.Database        = nil
+	CaCrl     *ca.CRLCache        = &ca.CRLCache{}
+
+	Profiles   map[string]*ca.Profile = nil
+	CaProfiles []string               = nil
+	Policy     *policy.Policy         = nil
 )
 
 type TKey struct {
@@ -66,6 +79,18 @@ func Main(args []string) {
 	caPassphrase := f.String("ca-pass", "", "Private key passphrase of the certificates authority")
 	caCertFile := f.String("ca-cert", "ca.crt", "Certificate of the certificates authority")
 	caCertURL := f.String("issuer-cert-url", "", "URL of the certificates authority's certificate")
+	crlURL := f.String("crl-url", "", "URL of the certificates authority's revocation list")
+	crlDays := f.Int("crl-days", ConfigCrlDays, "Number of days until the next update of the served revocation list")
+	ocspURL := f.String("ocsp-url", "", "URL of the certificates authority's OCSP responder")
+	ocspKeyFile := f.String("ocsp-key", "", "Private key of the delegated OCSP responder (default CA private key)")
+	ocspPassphrase := f.String("ocsp-pass", "", "Private key passphrase of the delegated OCSP responder")
+	ocspCertFile := f.String("ocsp-cert", "", "Certificate of the delegated OCSP responder (default CA certificate)")
+	ocspValidity := f.Duration("ocsp-validity", ConfigOcspValidity, "Validity of OCSP responses")
+	dbFile := f.String("db", "", "Issuance database file (default index.json next to the CA certificate)")
+	profilesFile := f.String("profiles", "", "Issuance profiles file (YAML)")
+	policyFile := f.String("policy", "", "Issuance policy file (YAML)")
+	profile := f.String("profile", ConfigProfile, "Default issuance profile")
+	caProfiles := f.String("ca-profiles", "", "Profiles issuing certificate authorities allowed on the web server, comma separated (default none)")
 
 	ssl := f.Bool("ssl", false, "Enable SSL server mode")
 	keyFile := f.String("key", "", "Private key of the certificates authority web server")
@@ -89,6 +114,8 @@ func Main(args []string) {
 	ConfigOU = *ou
 	ConfigDays = *nbDays
 	ConfigSize = *size
+	ConfigCrlDays = *crlDays
+	ConfigOcspValidity = *ocspValidity
 
 	var err error
 
@@ -99,7 +126,7 @@ func Main(args []string) {
 			os.Exit(1)
 		}
 	}
-	CaKey, err = key.LoadRSAKeyFile(*caKeyFile, *caPassphrase)
+	CaKey, err = key.LoadSignerFile(*caKeyFile, *caPassphrase)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
@@ -107,12 +134,15 @@ func Main(args []string) {
 
 	if b, _ := tools.Exists(*caCertFile); !b {
 		fmt.Fprintln(os.Stderr, "Certificate authority certificate does not exist, creating", *caCertFile)
-		if err := ca.GenerateCACertFile("EasyCA", *c, *st, *l, *o, *ou, "", "", CaKey, *nbDays, *caCertFile); err != nil {
+		if err := ca.GenerateCACertFile("EasyCA", *c, *st, *l, *o, *ou, "", "", CaKey, *nbDays, false, *caCertFile); err != nil {
 			fmt.Fprintln(os.Stderr, "Can not create CA certificate file")
 			os.Exit(1)
 		}
 	}
-	CaCert, err = cert.LoadCertFile(*caCertFile)
+	CaCert, CaChain, err = ca.LoadCAChainFile(*caCertFile)
+	if err == nil {
+		CaTrust, err = cert.LoadCertsFile(*caCertFile)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
@@ -121,6 +151,83 @@ func Main(args []string) {
 	if len(*caCertURL) > 0 {
 		CaCertURL = *caCertURL
 	}
+	if len(*crlURL) > 0 {
+		CrlURL = *crlURL
+	}
+	if len(*ocspURL) > 0 {
+		OcspURL = *ocspURL
+	}
+
+	if len(*ocspKeyFile) > 0 || len(*ocspCertFile) > 0 {
+		if len(*ocspKeyFile) == 0 || len(*ocspCertFile) == 0 {
+			fmt.Fprintln(os.Stderr, "Both OCSP responder private key and certificate must be set")
+			os.Exit(1)
+		}
+		ocspKey, err := key.LoadPrivateKeyFile(*ocspKeyFile, *ocspPassphrase)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if signer, ok := ocspKey.(crypto.Signer); ok {
+			OcspKey = signer
+		} else {
+			fmt.Fprintln(os.Stderr, "OCSP responder private key can not sign")
+			os.Exit(1)
+		}
+		OcspCert, err = cert.LoadCertFile(*ocspCertFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if !tools.Contains(OcspCert.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning) {
+			fmt.Fprintln(os.Stderr, "OCSP responder certificate is not allowed to sign OCSP responses")
+			os.Exit(1)
+		}
+		if err = OcspCert.CheckSignatureFrom(CaCert); err != nil {
+			fmt.Fprintln(os.Stderr, "OCSP responder certificate is not issued by the certificate authority")
+			os.Exit(1)
+		}
+	}
+
+	if len(*dbFile) == 0 {
+		*dbFile = ca.DatabaseFilename(*caCertFile)
+	}
+	CaDb, err = ca.LoadDatabase(*dbFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	Profiles, err = ca.LoadProfiles(*profilesFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if _, found := Profiles[*profile]; !found {
+		fmt.Fprintln(os.Stderr, "Unknown profile "+*profile)
+		os.Exit(1)
+	}
+	for _, name := range strings.Split(*caProfiles, ",") {
+		if len(name) == 0 {
+			continue
+		}
+		if _, found := Profiles[name]; !found {
+			fmt.Fprintln(os.Stderr, "Unknown profile "+name)
+			os.Exit(1)
+		}
+		CaProfiles = append(CaProfiles, name)
+	}
+	if Profiles[*profile].IssuesCA() && !tools.Contains(CaProfiles, *profile) {
+		fmt.Fprintln(os.Stderr, "Profile "+*profile+" issues certificate authorities, it must be allowed with -ca-profiles")
+		os.Exit(1)
+	}
+	ConfigProfile = *profile
+
+	Policy, err = policy.Load(*policyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 
 	if *ssl {
 		if len(*keyFile) == 0 {
@@ -145,6 +252,10 @@ func Main(args []string) {
 	mux.Handle("/crt", Logs(http.HandlerFunc(Crt)))
 	mux.Handle("/sign", Logs(http.HandlerFunc(Sign)))
 	mux.Handle("/ca/ca.crt", Logs(http.HandlerFunc(CaCaCrt)))
+	mux.Handle("/ca/ca.crl", Logs(http.HandlerFunc(CaCaCrl)))
+	mux.Handle("/ca/truststore", Logs(http.HandlerFunc(CaTrustStore)))
+	mux.Handle("/ocsp", Logs(http.HandlerFunc(Ocsp)))
+	mux.Handle("/ocsp/", Logs(http.HandlerFunc(Ocsp)))
 
 	mux.Handle("/", Logs(http.FileServer(http.Dir(*dir))))
 
@@ -170,7 +281,7 @@ func Main(args []string) {
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -191,6 +302,31 @@ func alive(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "alive")
 }
 
+// Requester of a web request, as recorded in the issuance database
+func Requester(r *http.Request) string {
+	ra := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ra); err == nil {
+		ra = host
+	}
+	if forward := r.Header.Get("X-Forwarded-For"); len(forward) > 0 {
+		ra = ra + "," + forward
+	}
+	return "web:" + ra
+}
+
+// Certificate chain of a certificate issued by the certificate authority (leaf and intermediates)
+func FullChain(crt *x509.Certificate) []*x509.Certificate {
+	return append([]*x509.Certificate{crt}, CaChain...)
+}
+
+// Certificates of the certificate authority (the certificate and its intermediate parents)
+func CaCerts() []*x509.Certificate {
+	if len(CaChain) > 0 {
+		return CaChain
+	}
+	return []*x509.Certificate{CaCert}
+}
+
 func GetParam(r *http.Request, name, def string) string {
 	t := r.URL.Query().Get(name)
 	if len(t) == 0 {
@@ -198,3 +334,17 @@ func GetParam(r *http.Request, name, def string) string {
 	}
 	return t
 }
+
+// Issuance profile selected by the profile parameter,
+// profiles issuing certificate authorities are refused unless allowed by -ca-profiles
+func GetProfile(r *http.Request) (*ca.Profile, error) {
+	name := GetParam(r, "profile", ConfigProfile)
+	profile := Profiles[name]
+	if profile == nil {
+		return nil, errors.New("Unknown profile")
+	}
+	if profile.IssuesCA() && !tools.Contains(CaProfiles, name) {
+		return nil, errors.New("Profile " + name + " issues certificate authorities, not allowed on this server")
+	}
+	return profile, nil
+}
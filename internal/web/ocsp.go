@@ -0,0 +1,90 @@
+package web
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"simpleca/internal/ca"
+	"simpleca/internal/ocsp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	OcspKey  crypto.Signer     = nil
+	OcspCert *x509.Certificate = nil
+)
+
+// OCSP responder (RFC 6960), requests are received by GET (base64 encoded in URL) or POST
+func Ocsp(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	var err error
+	switch r.Method {
+	case "GET":
+		s := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/ocsp"), "/")
+		if s, err = url.PathUnescape(s); err == nil {
+			der, err = base64.StdEncoding.DecodeString(s)
+		}
+	case "POST":
+		defer r.Body.Close()
+		der, err = io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/ocsp-response")
+	if err != nil {
+		w.Write(ocsp.ErrorResponse(ocsp.MalformedRequest))
+		return
+	}
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		w.Write(ocsp.ErrorResponse(ocsp.MalformedRequest))
+		return
+	}
+	if !req.Matches(CaCert) {
+		w.Write(ocsp.ErrorResponse(ocsp.Unauthorized))
+		return
+	}
+
+	now := time.Now()
+	tmpl := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ConfigOcspValidity),
+	}
+	record, err := CaDb.Find(req.SerialNumber)
+	if err != nil {
+		w.Write(ocsp.ErrorResponse(ocsp.InternalError))
+		return
+	}
+	if record != nil && record.IssuerKeyID == hex.EncodeToString(CaCert.SubjectKeyId) {
+		switch record.Status {
+		case ca.StatusRevoked:
+			tmpl.Status = ocsp.Revoked
+			tmpl.RevokedAt = *record.RevokedAt
+			tmpl.RevocationReason = record.Reason
+		default:
+			tmpl.Status = ocsp.Good
+		}
+	}
+
+	signer, responder := OcspKey, OcspCert
+	if signer == nil || responder == nil {
+		signer, responder = CaKey, CaCert
+	}
+	resp, err := ocsp.CreateResponse(CaCert, responder, req, tmpl, signer)
+	if err != nil {
+		w.Write(ocsp.ErrorResponse(ocsp.InternalError))
+		return
+	}
+	w.Header().Add("Cache-Control", "max-age="+strconv.Itoa(int(ConfigOcspValidity.Seconds())))
+	w.Write(resp)
+}
@@ -1,7 +1,9 @@
 package web
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
@@ -58,8 +60,8 @@ func Pub(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusOK)
 				w.Write(bytes)
 			}
-		case *ecdsa.PrivateKey:
-			publickey := &kkey.(*ecdsa.PrivateKey).PublicKey
+		case *ecdsa.PrivateKey, ed25519.PrivateKey:
+			publickey := kkey.(crypto.Signer).Public()
 			publicKeyBytes, err := x509.MarshalPKIXPublicKey(publickey)
 			if err != nil {
 				http.Error(w, "Unable to convert to public key: "+err.Error(), http.StatusInternalServerError)
@@ -35,6 +35,12 @@ func Sign(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Number of days too small"))
 		return
 	}
+	profile, err := GetProfile(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	// Reading the request body
 	defer r.Body.Close()
 	body, err := io.ReadAll(r.Body)
@@ -45,14 +51,22 @@ func Sign(w http.ResponseWriter, r *http.Request) {
 
 	if ccsr, err := csr.LoadCSR(body); err != nil {
 		http.Error(w, "Unable to convert to certificate signing request: "+err.Error(), http.StatusBadRequest)
+	} else if err = Policy.CheckCSR(ccsr, profile.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 	} else {
-		crt, err := ca.CASign(ccsr, days, CaCert, CaKey, CaCertURL)
+		days = Policy.Days(profile.Name, days)
+		crt, err := ca.CASign(ccsr, days, CaCert, CaKey, CaCertURL, CrlURL, OcspURL, profile)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Can not sign certificate signing request"))
+			w.Write([]byte("Can not sign certificate signing request: " + err.Error()))
 			return
 		}
-		bytes := pem.EncodeToMemory(cert.ConvertCertToBlock(crt))
+		if err = CaDb.Add(crt, Requester(r)); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Can not record certificate"))
+			return
+		}
+		bytes := cert.EncodeCertsToPEM(FullChain(crt))
 		if tools.Contains(r.Header["Accept"], "application/json") {
 			w.Header().Add("Content-type", "application/json")
 			resp, _ := json.Marshal(Resp{Crt: string(bytes)})
@@ -66,14 +80,60 @@ func Sign(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func CaCaCrl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	crlBytes, err := CaCrl.Get(CaDb, CaCert, CaKey, ConfigCrlDays)
+	if err != nil {
+		http.Error(w, "Can not generate certificate revocation list: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tools.Contains(r.Header["Accept"], "application/pkix-crl") {
+		w.Header().Add("Content-Type", "application/pkix-crl")
+		w.Write(crlBytes)
+	} else {
+		w.Header().Add("Content-Type", "text/plain")
+		w.Write(pem.EncodeToMemory(ca.ConvertCRLBytesToBlock(crlBytes)))
+	}
+}
+
+// Java trust store (PKCS#12 or JKS) of the certificates of the CA certificate file (root included)
+func CaTrustStore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := GetParam(r, "format", "pkcs12")
+	if !tools.Contains(cert.KeyStoreFormats, format) {
+		http.Error(w, "Unknown trust store format", http.StatusBadRequest)
+		return
+	}
+	bytes, err := cert.EncodeKeyStore(cert.TrustStoreEntries(CaTrust, ""), format, GetParam(r, "password", "changeit"), false)
+	if err != nil {
+		http.Error(w, "Can not create trust store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if format == "jks" {
+		w.Header().Add("Content-Type", "application/x-java-keystore")
+		w.Header().Add("Content-Disposition", "attachment; filename=\"truststore.jks\"")
+	} else {
+		w.Header().Add("Content-Type", "application/x-pkcs12")
+		w.Header().Add("Content-Disposition", "attachment; filename=\"truststore.p12\"")
+	}
+	w.Write(bytes)
+}
+
 func CaCaCrt(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	CaCertBlock := cert.ConvertCertToBlock(CaCert)
-	bytes := pem.EncodeToMemory(CaCertBlock)
+	bytes := cert.EncodeCertsToPEM(CaCerts())
 	if tools.Contains(r.Header["Accept"], "application/json") {
 		w.Header().Add("Content-type", "application/json")
 		var r Resp
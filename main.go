@@ -7,8 +7,11 @@ import (
 	"simpleca/internal/acmeca"
 	"simpleca/internal/ca"
 	"simpleca/internal/cert"
+	"simpleca/internal/check"
+	"simpleca/internal/convert"
 	"simpleca/internal/csr"
 	"simpleca/internal/key"
+	"simpleca/internal/scan"
 	"simpleca/internal/web"
 )
 
@@ -22,8 +25,11 @@ Commands:
   acme             Start an ACME certificate authority web server
   ca               Manage certificate authority
   cert             Manage server certificates
+  check            Check that a private key, a request, a certificate and its chain match
+  convert          Convert certificates, requests and keys between formats (PEM, DER, PKCS#7, PKCS#12)
   csr              Manage server certificate signing request
   key              Manage keys
+  scan             Report the TLS handshake and certificates of servers
   web              Start an automatic certificate authority web server
 
 Run 'simpleca COMMAND -h' for more informations on a command
@@ -45,10 +51,16 @@ func Main(args []string) {
 			cert.Main(argsWithoutProg)
 		case "cert":
 			cert.Main(argsWithoutProg)
+		case "check":
+			check.Main(argsWithoutProg)
+		case "convert":
+			convert.Main(argsWithoutProg)
 		case "csr":
 			csr.Main(argsWithoutProg)
 		case "key":
 			key.Main(argsWithoutProg)
+		case "scan":
+			scan.Main(argsWithoutProg)
 		case "web":
 			web.Main(argsWithoutProg)
 
@@ -2,6 +2,7 @@ package tools
 
 import (
 	"bufio"
+	"encoding/base64"
 	"fmt"
 	"math/rand"
 	"net"
@@ -282,6 +283,21 @@ func EnsureStringNotLong(s string, l int) string {
 	return st
 }
 
+// Decode base64 data without PEM headers (line breaks allowed)
+func DecodeBase64(data []byte) ([]byte, bool) {
+	s := strings.Join(strings.Fields(string(data)), "")
+	if len(s) == 0 {
+		return nil, false
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return b, true
+	}
+	if b, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return b, true
+	}
+	return nil, false
+}
+
 /* Generic function to test is a value exists in aslice */
 func Contains[T comparable](s []T, e T) bool {
 	for _, v := range s {